@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+
+	"backup-home/internal/backup"
+	"backup-home/internal/logging"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/cobra"
+)
+
+// newBenchmarkCmd creates the command that compares compression levels on a
+// sample of the source
+func newBenchmarkCmd() *cobra.Command {
+	var source string
+	var formats []string
+	var levels []int
+	var concurrency int
+	var verbose bool
+	sampleSize := fs.SizeSuffix(32 * 1024 * 1024)
+
+	cmd := &cobra.Command{
+		Use:   "benchmark",
+		Short: "Compare compression levels and formats on a sample of the source",
+		Long: `Compress a sample of the source, the first 256 KiB of each included file up
+to --sample-size in total, at every --level with every --format, and print
+the output size, ratio and time of each. The recommendation is the fastest
+setting whose output is at most 2% larger than the smallest one, so already
+compressed media quickly points at a low level or --store.
+
+The platform's default excludes and the .backupignore at the source root
+apply, as for a backup.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := logging.InitLogger(verbose); err != nil {
+				return fmt.Errorf("failed to initialize logger: %w", err)
+			}
+			defer logging.SyncLogger()
+
+			if sampleSize <= 0 {
+				return fmt.Errorf("--sample-size must be positive")
+			}
+			if len(formats) == 0 {
+				formats = []string{""}
+			}
+
+			benchmark, err := backup.BenchmarkCompression(cmd.Context(), backup.Options{
+				Source:      source,
+				Verbose:     verbose,
+				Concurrency: concurrency,
+			}, formats, levels, int(sampleSize))
+			if err != nil {
+				return err
+			}
+			printBenchmark(benchmark)
+			return nil
+		},
+	}
+
+	home, _ := homedir.Dir()
+	cmd.Flags().StringVarP(&source, "source", "s", home, "Directory to sample")
+	cmd.Flags().StringSliceVar(&formats, "format", nil, "Archive formats to compare, may be repeated or comma separated (defaults to the platform default)")
+	cmd.Flags().IntSliceVar(&levels, "level", []int{0, 1, 3, 6, 9}, "Compression levels to compare, may be repeated or comma separated")
+	cmd.Flags().Var(&sampleSize, "sample-size", "Amount of source data to compress per setting")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 0, "Maximum goroutines compressing in parallel, as for a backup (0 uses one per CPU)")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
+	return cmd
+}
+
+// printBenchmark prints a table of the benchmark results and the recommended setting
+func printBenchmark(benchmark backup.CompressionBenchmark) {
+	if benchmark.SampleSize == 0 {
+		fmt.Println("No files to sample in the source")
+		return
+	}
+
+	sampleMB := float64(benchmark.SampleSize) / 1024 / 1024
+	fmt.Printf("Sample: %.2f MB\n\n", sampleMB)
+	fmt.Printf("%-8s %5s %12s %7s %9s %12s\n", "FORMAT", "LEVEL", "SIZE", "RATIO", "TIME", "SPEED")
+	for _, result := range benchmark.Results {
+		seconds := result.Duration.Seconds()
+		fmt.Printf("%-8s %5d %9.2f MB %6.1f%% %8.2fs %7.2f MB/s\n", result.Format, result.Level,
+			float64(result.Size)/1024/1024, float64(result.Size)*100/float64(benchmark.SampleSize),
+			seconds, sampleMB/max(seconds, 0.001))
+	}
+
+	if best, ok := benchmark.Recommended(); ok {
+		fmt.Printf("\nRecommended: --format %s -c %d\n", best.Format, best.Level)
+	}
+}
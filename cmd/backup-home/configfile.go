@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"backup-home/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+// applyConfigFile sets the flags of cmd named in the --config file at path.
+// Flags given on the command line are left alone, so they override the
+// file. Options of other subcommands are skipped, unknown ones rejected.
+func applyConfigFile(cmd *cobra.Command, path string) error {
+	file, err := config.LoadFlagFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range file.Names() {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			if !hasFlag(cmd.Root(), name) {
+				return fmt.Errorf("unknown option %q in %s", name, path)
+			}
+			continue
+		}
+		if flag.Changed || name == "config" {
+			continue
+		}
+
+		values, err := file.Values(name)
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			if err := cmd.Flags().Set(name, value); err != nil {
+				return fmt.Errorf("invalid %s in %s: %w", name, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// hasFlag reports whether cmd or any of its subcommands has a flag called name
+func hasFlag(cmd *cobra.Command, name string) bool {
+	if cmd.Flags().Lookup(name) != nil || cmd.PersistentFlags().Lookup(name) != nil {
+		return true
+	}
+	for _, sub := range cmd.Commands() {
+		if hasFlag(sub, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"backup-home/internal/credentials"
+	"backup-home/internal/logging"
+	"backup-home/internal/upload"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// rclonePasswordEnv is read by rclone to decrypt an encrypted configuration
+const rclonePasswordEnv = "RCLONE_CONFIG_PASS"
+
+// loadCredentials fills the secrets not given on the command line from the
+// keychain entries of --credential-name
+func (o *options) loadCredentials() error {
+	if o.credentialName == "" {
+		return nil
+	}
+	sugar := logging.GetSugar()
+
+	targets := map[string]*string{
+		credentials.SSHPassword:      &o.sshPassword,
+		credentials.SSHKeyPassphrase: &o.sshKeyPassphrase,
+		credentials.S3SecretKey:      &o.s3Config.SecretKey,
+	}
+	found := 0
+	for _, field := range credentials.Fields() {
+		secret, err := credentials.Get(o.credentialName, field)
+		if err != nil {
+			return err
+		}
+		if secret == "" {
+			continue
+		}
+		found++
+
+		if field == credentials.SSHKeyPassphrase && os.Getenv(upload.KeyPassphraseEnv) != "" {
+			continue
+		}
+		if field == credentials.RclonePassword {
+			if os.Getenv(rclonePasswordEnv) == "" {
+				if err := os.Setenv(rclonePasswordEnv, secret); err != nil {
+					return fmt.Errorf("failed to pass the rclone config password: %w", err)
+				}
+				sugar.Debugf("Using %s of credential %s", field, o.credentialName)
+			}
+			continue
+		}
+		if target := targets[field]; *target == "" {
+			*target = secret
+			sugar.Debugf("Using %s of credential %s", field, o.credentialName)
+		}
+	}
+	if found == 0 {
+		return fmt.Errorf("no secrets stored in the keychain for credential %s: add them with 'backup-home credentials set %s <field>'", o.credentialName, o.credentialName)
+	}
+	return nil
+}
+
+// newCredentialsCmd creates the command that manages the keychain entries
+// read with --credential-name
+func newCredentialsCmd() *cobra.Command {
+	fields := strings.Join(credentials.Fields(), ", ")
+	cmd := &cobra.Command{
+		Use:   "credentials",
+		Short: "Store SSH, S3 and rclone secrets in the system keychain for --credential-name",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <name> <field>",
+		Short: "Store a secret, read from the terminal or stdin (fields: " + fields + ")",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, field := args[0], args[1]
+			if err := credentials.Check(name, field); err != nil {
+				return err
+			}
+			secret, err := readCredentialSecret(field)
+			if err != nil {
+				return err
+			}
+			if secret == "" {
+				return fmt.Errorf("the secret must not be empty")
+			}
+			if err := credentials.Set(name, field, secret); err != nil {
+				return err
+			}
+			fmt.Printf("Stored %s of credential %s\n", field, name)
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete <name> [field...]",
+		Short: "Remove secrets of a credential, all of them when no field is given",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, fields := args[0], args[1:]
+			if len(fields) == 0 {
+				fields = credentials.Fields()
+			}
+			for _, field := range fields {
+				if err := credentials.Delete(name, field); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("Deleted credential %s: %s\n", name, strings.Join(fields, ", "))
+			return nil
+		},
+	})
+	return cmd
+}
+
+// readCredentialSecret reads the secret for field without echo from the
+// terminal, or as the first line of stdin when it is not a terminal
+func readCredentialSecret(field string) (string, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return promptSecret(fmt.Sprintf("Enter %s: ", field))
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read the secret from stdin: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"backup-home/internal/crypt"
+
+	"github.com/spf13/cobra"
+)
+
+// newDecryptCmd creates the command that decrypts an archive made with --encrypt
+func newDecryptCmd() *cobra.Command {
+	var passphrase, output string
+
+	cmd := &cobra.Command{
+		Use:   "decrypt <archive.enc>",
+		Short: "Decrypt an archive created with --encrypt",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input := args[0]
+			if passphrase == "" {
+				passphrase = os.Getenv(crypt.PassphraseEnv)
+			}
+			if passphrase == "" {
+				return fmt.Errorf("a passphrase is required: use --passphrase or $%s", crypt.PassphraseEnv)
+			}
+			if output == "" {
+				if !strings.HasSuffix(input, crypt.Extension) {
+					return fmt.Errorf("cannot derive output name from %s, use --output", input)
+				}
+				output = strings.TrimSuffix(input, crypt.Extension)
+			}
+			if _, err := os.Stat(output); err == nil {
+				return fmt.Errorf("output file already exists: %s", output)
+			}
+
+			if err := decryptFile(input, output, passphrase); err != nil {
+				os.Remove(output)
+				return err
+			}
+			fmt.Printf("Decrypted %s to %s\n", input, output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&passphrase, "passphrase", "", "Passphrase the archive was encrypted with (defaults to $"+crypt.PassphraseEnv+")")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Decrypted file path (defaults to the input without .enc)")
+	return cmd
+}
+
+// decryptFile decrypts input into a new file at output
+func decryptFile(input, output, passphrase string) error {
+	in, err := os.Open(input)
+	if err != nil {
+		return fmt.Errorf("failed to open encrypted archive: %w", err)
+	}
+	defer in.Close()
+
+	reader, err := crypt.NewReader(in, passphrase)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"backup-home/internal/logging"
+	"backup-home/internal/notify"
+	"backup-home/internal/upload"
+)
+
+// destination is one place the backup is uploaded to: the SSH hosts, or a
+// single rclone remote
+type destination struct {
+	ssh    bool
+	hosts  []string
+	rclone string
+}
+
+func (d destination) String() string {
+	if d.ssh {
+		return "ssh:" + strings.Join(d.hosts, ",")
+	}
+	return d.rclone
+}
+
+// destinations returns where the backup is uploaded: the SSH hosts first,
+// then each rclone remote in the order given
+func (o options) destinations() []destination {
+	var dests []destination
+	if o.useSSH {
+		dests = append(dests, destination{ssh: true, hosts: o.sshHosts})
+	}
+	for _, remote := range o.rclone {
+		dests = append(dests, destination{rclone: remote})
+	}
+	return dests
+}
+
+// checkDestinations confirms that every destination, and every SSH host on
+// its own, can be reached and written to, without uploading the archive.
+// Every destination is checked even if another one fails.
+func checkDestinations(ctx context.Context, opts options) error {
+	sugar := logging.GetSugar()
+
+	var checked int
+	var failed []string
+	for _, dest := range opts.destinations() {
+		if !dest.ssh {
+			checked++
+			if err := upload.CheckRclone(ctx, opts.rcloneConfig(dest.rclone), opts.verbose); err != nil {
+				sugar.Errorf("Check of %s failed: %v", dest, err)
+				failed = append(failed, fmt.Sprintf("%s: %v", dest, err))
+			}
+			continue
+		}
+		for _, host := range dest.hosts {
+			checked++
+			config := opts.sshConfig()
+			config.Host = host
+			if err := upload.CheckSSH(ctx, config); err != nil {
+				sugar.Errorf("Check of ssh:%s failed: %v", host, err)
+				failed = append(failed, fmt.Sprintf("ssh:%s: %v", host, err))
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d destination checks failed: %s", len(failed), checked, strings.Join(failed, "; "))
+	}
+	sugar.Infof("Every destination is reachable and writable")
+	return nil
+}
+
+// uploadFile uploads one local file to dest
+func uploadFile(ctx context.Context, localPath string, dest destination, opts options) error {
+	if dest.ssh && len(dest.hosts) > 1 {
+		// Upload the same file to every SSH host, retrying each on its own
+		return upload.UploadToSSHHosts(ctx, localPath, opts.sshConfig(), dest.hosts, opts.sshParallel, opts.uploadRetries, opts.verbose)
+	}
+	// Every attempt re-dials the connection; SFTP retries resume the upload
+	if dest.ssh {
+		return upload.RetrySSH(ctx, opts.uploadRetries, opts.sshConfig(), func(config upload.SSHConfig) error {
+			return upload.UploadToSSH(ctx, localPath, config, opts.verbose)
+		})
+	}
+	return upload.Retry(ctx, opts.uploadRetries, func() error {
+		return upload.UploadToRclone(ctx, localPath, opts.rcloneConfig(dest.rclone), opts.verbose)
+	})
+}
+
+// uploadToDestinations uploads every path to each destination in turn, or to
+// all of them at once with opts.parallelUploads. Every destination is
+// attempted even if another one fails; with several destinations a summary
+// and the combined throughput are logged. The transfer to each destination is
+// recorded in summary and the failed destinations are returned. Once ctx is
+// canceled the remaining destinations fail without an attempt.
+func uploadToDestinations(ctx context.Context, paths []string, opts options, summary *runSummary) []string {
+	sugar := logging.GetSugar()
+
+	var bytes int64
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			bytes += info.Size()
+		}
+	}
+
+	dests := opts.destinations()
+	errs := make([]error, len(dests))
+	durations := make([]time.Duration, len(dests))
+	skipped := make([]bool, len(dests))
+	uploadTo := func(i int) {
+		dest := dests[i]
+		if errs[i] = ctx.Err(); errs[i] != nil {
+			return
+		}
+		if opts.skipUnchanged && unchangedOn(dest, paths[0], opts) {
+			sugar.Infof("Skipping upload to %s: it already has this backup", dest)
+			skipped[i] = true
+			return
+		}
+		if len(dests) > 1 {
+			sugar.Infof("Uploading to destination %d of %d: %s", i+1, len(dests), dest)
+		}
+		startTime := time.Now()
+		for _, path := range paths {
+			if errs[i] = uploadFile(ctx, path, dest, opts); errs[i] != nil {
+				sugar.Errorf("Upload to %s failed: %v", dest, errs[i])
+				break
+			}
+		}
+		durations[i] = time.Since(startTime)
+	}
+
+	startTime := time.Now()
+	if opts.parallelUploads {
+		// The archive is only read, so the destinations can share it
+		var wg sync.WaitGroup
+		for i := range dests {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				uploadTo(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range dests {
+			uploadTo(i)
+		}
+	}
+	elapsed := time.Since(startTime)
+
+	var failed []string
+	var uploaded int64
+	if len(dests) > 1 {
+		sugar.Infof("Destination summary:")
+	}
+	for i, dest := range dests {
+		result := notify.NewDestination(dest.String(), bytes, durations[i], errs[i])
+		if skipped[i] {
+			result = notify.Destination{Name: dest.String(), Status: notify.StatusSkipped}
+		} else if errs[i] != nil {
+			// How much of a failed upload arrived is not known
+			result.Bytes, result.Throughput = 0, 0
+		}
+		summary.destinations = append(summary.destinations, result)
+		uploaded += result.Bytes
+		if errs[i] != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", dest, errs[i]))
+		}
+		if len(dests) == 1 {
+			continue
+		}
+		if errs[i] != nil {
+			sugar.Infof("  %s: FAILED after %s", dest, durations[i].Round(time.Second))
+		} else {
+			sugar.Infof("  %s: ok (%s)", dest, durations[i].Round(time.Second))
+		}
+	}
+	if len(dests) > 1 {
+		megabytes := float64(uploaded) / 1024 / 1024
+		sugar.Infof("Uploaded %.2f MB in total in %s (%.2f MB/s combined)", megabytes, elapsed.Round(time.Second), megabytes/elapsed.Seconds())
+	}
+	return failed
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"backup-home/internal/backup"
+	"backup-home/internal/config"
+	"backup-home/internal/logging"
+
+	"github.com/spf13/cobra"
+)
+
+// diffTopDirs is how many top-level directories the diff summary lists
+const diffTopDirs = 10
+
+// newDiffCmd creates the command that compares two contents manifests
+func newDiffCmd() *cobra.Command {
+	var oldPath, newPath string
+
+	cmd := &cobra.Command{
+		Use:   "diff --old <manifest> --new <manifest>",
+		Short: "Compare two contents manifests",
+		Long: `Compare two contents manifests written with --manifest and print the paths
+added, removed and modified (size, modification time, type or symlink target
+changed) between them, followed by the totals and the top-level directories
+whose size changed the most.
+
+It is a local operation on the two manifests; no archive is read. Use it to
+audit why a backup grew or shrank, e.g. because a cache directory was
+accidentally included.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			oldEntries, err := backup.ReadContents(oldPath)
+			if err != nil {
+				return err
+			}
+			newEntries, err := backup.ReadContents(newPath)
+			if err != nil {
+				return err
+			}
+			printContentsDiff(backup.DiffContents(oldEntries, newEntries))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&oldPath, "old", "", "Contents manifest of the earlier backup")
+	cmd.Flags().StringVar(&newPath, "new", "", "Contents manifest of the later backup")
+	cmd.MarkFlagRequired("old")
+	cmd.MarkFlagRequired("new")
+	return cmd
+}
+
+// printContentsDiff prints every change, the totals and the size change per
+// top-level directory, largest first
+func printContentsDiff(diff backup.ContentsDiff) {
+	byDir := make(map[string]int64)
+	total := func(changes []backup.ContentsChange) int64 {
+		var bytes int64
+		for _, change := range changes {
+			bytes += change.SizeChange()
+			byDir[strings.SplitN(change.Path, "/", 2)[0]] += change.SizeChange()
+		}
+		return bytes
+	}
+
+	for _, change := range diff.Added {
+		fmt.Printf("+ %s (%.2f MB)\n", contentsName(change.New), float64(change.New.Size)/1024/1024)
+	}
+	for _, change := range diff.Removed {
+		fmt.Printf("- %s (%.2f MB)\n", contentsName(change.Old), float64(change.Old.Size)/1024/1024)
+	}
+	for _, change := range diff.Modified {
+		fmt.Printf("~ %s (%.2f MB -> %.2f MB)\n", contentsName(change.New), float64(change.Old.Size)/1024/1024, float64(change.New.Size)/1024/1024)
+	}
+
+	added, removed, modified := total(diff.Added), total(diff.Removed), total(diff.Modified)
+	fmt.Printf("\nAdded: %d paths, %+.2f MB\n", len(diff.Added), float64(added)/1024/1024)
+	fmt.Printf("Removed: %d paths, %+.2f MB\n", len(diff.Removed), float64(removed)/1024/1024)
+	fmt.Printf("Modified: %d paths, %+.2f MB\n", len(diff.Modified), float64(modified)/1024/1024)
+	fmt.Printf("Net change: %+.2f MB\n", float64(added+removed+modified)/1024/1024)
+
+	dirs := make([]string, 0, len(byDir))
+	for dir, bytes := range byDir {
+		if bytes != 0 {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		a, b := abs(byDir[dirs[i]]), abs(byDir[dirs[j]])
+		if a != b {
+			return a > b
+		}
+		return dirs[i] < dirs[j]
+	})
+	if len(dirs) > diffTopDirs {
+		dirs = dirs[:diffTopDirs]
+	}
+	fmt.Printf("\nLargest changes by top-level path:\n")
+	for _, dir := range dirs {
+		fmt.Printf("  %+.2f MB %s\n", float64(byDir[dir])/1024/1024, dir)
+	}
+}
+
+// summarizeChanges logs in one line how the contents manifest at
+// contentsPath differs from the one of the last successful backup, so an
+// unexpectedly large backup stands out before it is uploaded
+func summarizeChanges(contentsPath string) {
+	sugar := logging.GetSugar()
+
+	lastPath, err := config.LastContentsPath()
+	if err != nil {
+		sugar.Warnf("Cannot compare with the last backup: %v", err)
+		return
+	}
+	if _, err := os.Stat(lastPath); os.IsNotExist(err) {
+		sugar.Infof("No contents manifest of a previous backup in %s to compare with", lastPath)
+		return
+	}
+	oldEntries, err := backup.ReadContents(lastPath)
+	if err != nil {
+		sugar.Warnf("Cannot compare with the last backup: %v", err)
+		return
+	}
+	newEntries, err := backup.ReadContents(contentsPath)
+	if err != nil {
+		sugar.Warnf("Cannot compare with the last backup: %v", err)
+		return
+	}
+
+	// Directories only change along with the files in them
+	diff := backup.DiffContents(oldEntries, newEntries)
+	var changed int
+	var newBytes int64
+	for _, changes := range [][]backup.ContentsChange{diff.Added, diff.Removed, diff.Modified} {
+		for _, change := range changes {
+			entry := change.New
+			if entry == nil {
+				entry = change.Old
+			}
+			if strings.HasPrefix(entry.Mode, "d") {
+				continue
+			}
+			changed++
+			if change.New != nil {
+				newBytes += change.New.Size
+			}
+		}
+	}
+	sugar.Infof("%d files changed, %.2f MB new since the last backup (%d added, %d removed, %d modified paths)",
+		changed, float64(newBytes)/1024/1024, len(diff.Added), len(diff.Removed), len(diff.Modified))
+}
+
+// recordContents keeps the contents manifest at contentsPath, if any, for
+// the summary of the next run. Failing to keep it does not fail the backup.
+func recordContents(contentsPath string) {
+	if contentsPath == "" {
+		return
+	}
+	if err := config.SaveLastContents(contentsPath); err != nil {
+		logging.GetSugar().Warnf("Failed to record contents manifest: %v", err)
+	}
+}
+
+// contentsName returns the path of a manifest entry, with a trailing slash for
+// a directory
+func contentsName(entry *backup.ContentsEntry) string {
+	if strings.HasPrefix(entry.Mode, "d") {
+		return entry.Path + "/"
+	}
+	return entry.Path
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,57 @@
+package main
+
+import "errors"
+
+// Exit statuses of a backup run, so a wrapper can tell what went wrong
+const (
+	exitSuccess = 0
+	// exitFailure is any failure not covered by a more specific status, such
+	// as invalid flags
+	exitFailure = 1
+	// exitBackupFailed means the archive could not be created
+	exitBackupFailed = 2
+	// exitUploadFailed means the archive was created but not uploaded
+	exitUploadFailed = 3
+	// exitSkippedFiles means the run succeeded, but the archive leaves out
+	// files that could not be read or is a partial archive
+	exitSkippedFiles = 4
+)
+
+// exitError carries the exit status of the failure it wraps
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode makes the process exit with code when err ends the run
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit status for the outcome of a run: the status
+// attached to err, exitFailure for other errors, and exitSkippedFiles for a
+// successful run that skipped files
+func exitCode(err error, summary runSummary) int {
+	var exitErr *exitError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	case err != nil:
+		return exitFailure
+	case summary.skipped:
+		return exitSkippedFiles
+	default:
+		return exitSuccess
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"backup-home/internal/config"
+	"backup-home/internal/logging"
+)
+
+// sinceLayouts are the timestamp formats accepted by --since, besides a file path
+var sinceLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// resolveSince returns the reference time of an incremental backup: --since
+// as a timestamp or as a file whose modification time is used, otherwise the
+// start of the last successful backup. The zero time means a full backup.
+func resolveSince(since string) (time.Time, error) {
+	sugar := logging.GetSugar()
+
+	if since == "" {
+		last, err := config.LoadLastBackup()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if last.IsZero() {
+			path, _ := config.LastBackupPath()
+			sugar.Warnf("No previous backup recorded in %s, creating a full backup", path)
+		}
+		return last, nil
+	}
+
+	for _, layout := range sinceLayouts {
+		if t, err := time.ParseInLocation(layout, since, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	info, err := os.Stat(since)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since %q: not a timestamp (e.g. 2024-01-31 or 2024-01-31T15:04:05) or an existing file", since)
+	}
+	return info.ModTime(), nil
+}
+
+// recordBackupTime saves the start time of a successful backup for the next
+// --incremental run. Failing to save it does not fail the backup.
+func recordBackupTime(start time.Time) {
+	if err := config.SaveLastBackup(start); err != nil {
+		logging.GetSugar().Warnf("Failed to record backup time: %v", err)
+	}
+}
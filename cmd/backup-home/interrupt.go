@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"backup-home/internal/logging"
+
+	"github.com/spf13/cobra"
+)
+
+// interruptExitCode is the exit status after a second interrupt, as a shell
+// reports a process killed by SIGINT
+const interruptExitCode = 130
+
+// interruptContext returns a context canceled by the first SIGINT or SIGTERM,
+// so a running backup stops and removes its incomplete archive. A second
+// signal exits immediately. stop releases the signal handler.
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			logging.GetSugar().Infof("Received %s, stopping (interrupt again to exit immediately)", sig)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		<-signals
+		os.Exit(interruptExitCode)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// withTimeout wraps the backup command so the backup and upload are canceled
+// once the --timeout in opts has passed, failing the run
+func withTimeout(run func(*cobra.Command, []string) error, opts *options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if opts.timeout <= 0 {
+			return run(cmd, args)
+		}
+		ctx, cancel := context.WithTimeout(cmd.Context(), opts.timeout)
+		defer cancel()
+		cmd.SetContext(ctx)
+
+		err := run(cmd, args)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			logging.GetSugar().Errorf("Backup timed out after %s", opts.timeout)
+			return fmt.Errorf("timed out after %s: %w", opts.timeout, err)
+		}
+		return err
+	}
+}
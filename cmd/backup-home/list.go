@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"backup-home/internal/backup"
+	"backup-home/internal/logging"
+	"backup-home/internal/upload"
+
+	"github.com/spf13/cobra"
+)
+
+// listFiles prints every path the backup would include or exclude, followed
+// by the totals, without creating an archive
+func listFiles(backupOpts backup.Options) error {
+	stats, err := backup.ListFiles(backupOpts, func(entry backup.ListEntry) {
+		name := filepath.ToSlash(entry.Path)
+		if entry.Info.IsDir() {
+			name += "/"
+		}
+		if entry.Excluded {
+			fmt.Printf("- %s (%s)\n", name, entry.Reason)
+		} else {
+			fmt.Printf("+ %s\n", name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nIncluded: %d files and %d directories, %.2f MB\n", stats.Files, stats.Dirs, float64(stats.Bytes)/1024/1024)
+	fmt.Printf("Excluded: %d paths (contents of excluded directories not counted)\n", stats.Excluded)
+	return nil
+}
+
+// newListCmd creates the command that lists the dated backups on the remote
+func newListCmd() *cobra.Command {
+	var opts options
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the dated backups of every host on the remote",
+		Long: `List the files in the hostname/Users/date backup directories of every host
+on each destination, newest date first, with their sizes. The paths printed
+are relative to --ssh-remote-path or the --rclone destination, as the restore
+command takes them.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := logging.InitLogger(opts.verbose); err != nil {
+				return fmt.Errorf("failed to initialize logger: %w", err)
+			}
+			defer logging.SyncLogger()
+
+			if err := opts.loadCredentials(); err != nil {
+				return err
+			}
+			if err := opts.addS3Destination(); err != nil {
+				return err
+			}
+			if err := opts.parseRcloneFlags(); err != nil {
+				return err
+			}
+
+			// SSH is the default remote, as for uploads
+			if len(opts.rclone) == 0 {
+				opts.useSSH = true
+			}
+			return listDestinations(cmd.Context(), opts)
+		},
+	}
+
+	addRemoteFlags(cmd, &opts)
+	cmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "Enable verbose output")
+
+	return cmd
+}
+
+// listDestinations prints the backups on every destination in opts
+func listDestinations(ctx context.Context, opts options) error {
+	first := true
+	printBackups := func(location string, backups []upload.RemoteBackup) {
+		if !first {
+			fmt.Println()
+		}
+		first = false
+		fmt.Printf("%s:\n", location)
+		printRemoteBackups(backups, opts.dateFormat)
+	}
+
+	for _, dest := range opts.destinations() {
+		if dest.ssh {
+			for _, host := range dest.hosts {
+				config := opts.sshConfig()
+				config.Host = host
+				backups, err := upload.ListSSHBackups(ctx, config)
+				if err != nil {
+					return err
+				}
+				printBackups(fmt.Sprintf("%s@%s:%s", config.User, host, config.RemotePath), backups)
+			}
+			continue
+		}
+
+		backups, err := upload.ListRcloneBackups(opts.rcloneConfig(dest.rclone), opts.verbose)
+		if err != nil {
+			return err
+		}
+		printBackups(dest.rclone, backups)
+	}
+	return nil
+}
+
+// printRemoteBackups prints one line per backup file, sorted by the date of
+// its directory, newest first, then by host and name. Directories whose name
+// does not match dateFormat come last.
+func printRemoteBackups(backups []upload.RemoteBackup, dateFormat string) {
+	if len(backups) == 0 {
+		fmt.Println("  no backups found")
+		return
+	}
+
+	dates := make(map[string]time.Time)
+	hostWidth := 0
+	for _, b := range backups {
+		if t, err := time.Parse(dateFormat, b.Date); err == nil {
+			dates[b.Date] = t
+		}
+		hostWidth = max(hostWidth, len(b.Host))
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		a, b := backups[i], backups[j]
+		if a.Date != b.Date {
+			ta, okA := dates[a.Date]
+			tb, okB := dates[b.Date]
+			if okA != okB {
+				return okA
+			}
+			if !ta.Equal(tb) {
+				return ta.After(tb)
+			}
+			return a.Date > b.Date
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Name < b.Name
+	})
+
+	var total int64
+	for _, b := range backups {
+		total += b.Size
+		fmt.Printf("  %s  %-*s  %10.2f MB  %s\n", b.Date, hostWidth, b.Host, float64(b.Size)/1024/1024, path.Join(b.Host, "Users", b.Date, b.Name))
+	}
+	fmt.Printf("  %d files, %.2f MB\n", len(backups), float64(total)/1024/1024)
+}
@@ -1,16 +1,31 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"backup-home/internal/backup"
+	"backup-home/internal/checksum"
+	"backup-home/internal/config"
+	"backup-home/internal/crypt"
 	"backup-home/internal/logging"
+	"backup-home/internal/platform"
+	"backup-home/internal/progress"
+	"backup-home/internal/retention"
+	"backup-home/internal/snapshot"
 	"backup-home/internal/upload"
 
 	"github.com/mitchellh/go-homedir"
-	_ "github.com/rclone/rclone/backend/all"   // import all backends
+	_ "github.com/rclone/rclone/backend/all" // import all backends
+	"github.com/rclone/rclone/fs"
 	_ "github.com/rclone/rclone/fs/operations" // import operations/* rc commands
 	_ "github.com/rclone/rclone/fs/sync"       // import sync/*
 	"github.com/spf13/cobra"
@@ -23,30 +38,279 @@ var (
 )
 
 type options struct {
-	source        string
-	rclone        string
-	backupPath    string
-	compression   int
-	verbose       bool
-	preview       bool
-	skipOnError   bool
-	skipUpload    bool
-	keepBackup    bool
-	ignoreExcludes bool
-	backupOnly    bool
-	skipBackup    bool
+	sources           []string
+	rclone            []string
+	backupPath        string
+	tempDir           string
+	compression       int
+	store             bool
+	verbose           bool
+	preview           bool
+	checkDestination  bool
+	skipOnError       bool
+	skipUpload        bool
+	keepBackup        bool
+	keepLastLocal     int
+	ignoreExcludes    bool
+	excludeCommon     bool
+	presets           []string
+	excludesFile      string
+	filesFrom         string
+	excludes          []string
+	includes          []string
+	useIgnoreFiles    bool
+	gitDirtyOnly      bool
+	preserveXattrs    bool
+	reproducible      bool
+	skippedList       bool
+	noSpaceCheck      bool
+	followSymlinks    bool
+	maxFileSize       fs.SizeSuffix
+	minFileSize       fs.SizeSuffix
+	manifest          bool
+	listExcluded      bool
+	keep              int
+	retention         fs.Duration
+	verifyArchive     bool
+	archiveMode       string
+	format            string
+	compressionFormat string
+	bestCompress      bool
+	allowPartial      bool
+	waitOnENOSPC      time.Duration
+	minBackupSize     fs.SizeSuffix
+	splitSize         fs.SizeSuffix
+	checksumAlgo      string
+	checksum          bool
+	skipUnchanged     bool
+	incremental       bool
+	since             string
+	snapshot          bool
+	atTime            bool
+	encrypt           bool
+	passphrase        string
+	backupOnly        bool
+	skipBackup        bool
+	uploadRetries     int
+	bwLimitSchedule   string
+	bwLimit           fs.BwTimetable
+	timeout           time.Duration
+	notifyURL         string
+	notifyCommand     string
+	resultFile        string
+	healthcheckURL    string
 	// SSH upload options
-	useSSH       bool
-	sshHost      string
-	sshPort      string
-	sshUser      string
-	sshPassword  string
-	sshKeyFile   string
-	sshRemotePath string
+	useSSH           bool
+	sshHosts         []string
+	sshParallel      int
+	parallelUploads  bool
+	sshMethod        string
+	sshPort          string
+	sshUser          string
+	sshPassword      string
+	sshKeyFile       string
+	sshCertFile      string
+	sshKeyPassphrase string
+	credentialName   string
+	sshRemotePath    string
+	sshFlat          bool
+	sshChmod         string
+	sshChmodMode     os.FileMode
+	sshChown         string
+	sshAcceptNew     bool
+	sshInsecure      bool
+	sshKeepAlive     time.Duration
+	verifyUpload     bool
+	minRemoteFree    fs.SizeSuffix
+	concurrency      int
+	// Shared remote layout options
+	rcloneDated bool
+	rcloneSync  bool
+	rcloneFlags []string
+	// S3-compatible bucket, uploaded to as an rclone destination
+	s3             bool
+	s3Config       upload.S3Config
+	rcloneOptions  map[string]interface{}
+	dateFormat     string
+	remoteTemplate string
+	remoteSubdir   string
+	stream         bool
+}
+
+// sshConfig builds the SSH upload configuration from the command line options
+func (o options) sshConfig() upload.SSHConfig {
+	return upload.SSHConfig{
+		Host:             o.sshHosts[0],
+		Port:             o.sshPort,
+		User:             o.sshUser,
+		Password:         o.sshPassword,
+		KeyFile:          o.sshKeyFile,
+		CertFile:         o.sshCertFile,
+		KeyPassphrase:    o.keyPassphrase(),
+		PromptPassphrase: promptKeyPassphrase,
+		RemotePath:       o.sshRemotePath,
+		Flat:             o.sshFlat,
+		DateFormat:       o.dateFormat,
+		Subdir:           o.remoteSubdir,
+		Chmod:            o.sshChmodMode,
+		Chown:            o.sshChown,
+		HostKey:          o.hostKeyMode(),
+		KeepAlive:        o.sshKeepAlive,
+		Method:           o.sshMethod,
+		Concurrency:      o.concurrency,
+		VerifyUpload:     o.verifyUpload,
+		MinRemoteFree:    int64(o.minRemoteFree),
+		BwLimit:          o.bwLimit,
+	}
+}
+
+// keyPassphrase returns the passphrase of encrypted SSH keys from the command
+// line options or the environment
+func (o options) keyPassphrase() string {
+	if o.sshKeyPassphrase != "" {
+		return o.sshKeyPassphrase
+	}
+	return os.Getenv(upload.KeyPassphraseEnv)
+}
+
+// hostKeyMode returns how SSH host keys are verified from the command line options
+func (o options) hostKeyMode() upload.HostKeyMode {
+	switch {
+	case o.sshInsecure:
+		return upload.HostKeyInsecure
+	case o.sshAcceptNew:
+		return upload.HostKeyAcceptNew
+	default:
+		return upload.HostKeyStrict
+	}
+}
+
+// checkBackupSize refuses archives, given as their parts, smaller than minSize so a backup of an
+// empty or unmounted source never replaces good remote backups
+func checkBackupSize(parts []string, minSize fs.SizeSuffix) error {
+	if minSize <= 0 {
+		return nil
+	}
+	size, err := archiveSize(parts)
+	if err != nil {
+		return err
+	}
+	if size < int64(minSize) {
+		return fmt.Errorf("backup is only %s, smaller than --min-backup-size %s; refusing to upload", fs.SizeSuffix(size).ByteUnit(), minSize.ByteUnit())
+	}
+	return nil
+}
+
+// archiveSize returns the total size of the archive files in parts
+func archiveSize(parts []string) (int64, error) {
+	var size int64
+	for _, part := range parts {
+		info, err := os.Stat(part)
+		if err != nil {
+			return 0, fmt.Errorf("failed to stat backup file: %w", err)
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// retentionPolicy returns the policy applied after uploading, or nil if
+// neither --keep nor --retention is set
+func (o options) retentionPolicy() *retention.Policy {
+	if o.keep == 0 && o.retention == 0 {
+		return nil
+	}
+	return &retention.Policy{Last: o.keep, Within: time.Duration(o.retention)}
+}
+
+// parseRcloneFlags validates --rclone-flag into the options passed to rclone
+func (o *options) parseRcloneFlags() error {
+	rcloneOptions, err := upload.ParseRcloneFlags(o.rcloneFlags)
+	if err != nil {
+		return fmt.Errorf("invalid --rclone-flag: %w", err)
+	}
+	o.rcloneOptions = rcloneOptions
+	return nil
+}
+
+// addS3Destination adds the bucket given with --s3 to the rclone
+// destinations
+func (o *options) addS3Destination() error {
+	if !o.s3 {
+		return nil
+	}
+	o.s3Config.Concurrency = o.concurrency
+	remote, err := upload.S3Remote(o.s3Config)
+	if err != nil {
+		return fmt.Errorf("invalid --s3 options: %w", err)
+	}
+	o.rclone = append(o.rclone, remote)
+	return nil
+}
+
+// addRemoteFlags registers the flags that select and connect to the remote
+func addRemoteFlags(cmd *cobra.Command, opts *options) {
+	cmd.Flags().StringArrayVarP(&opts.rclone, "rclone", "r", nil, "Rclone destination path (e.g., \"drive:\", \"gdrive:backup/home\"), may be repeated to upload to several remotes")
+	cmd.Flags().BoolVar(&opts.useSSH, "ssh", false, "Upload via SSH/SCP (may be combined with --rclone)")
+	cmd.Flags().StringSliceVar(&opts.sshHosts, "ssh-host", []string{upload.DefaultTargetMachine}, "SSH host to upload to, may be repeated or comma separated to upload to several hosts")
+	cmd.Flags().StringVar(&opts.sshPort, "ssh-port", upload.DefaultSSHPort, "SSH port")
+	cmd.Flags().StringVar(&opts.sshUser, "ssh-user", upload.DefaultSSHUser, "SSH username")
+	cmd.Flags().StringVar(&opts.sshPassword, "ssh-password", "", "SSH password (not recommended, use key file instead)")
+	cmd.Flags().StringVar(&opts.sshKeyFile, "ssh-key", "", "SSH private key file path (defaults to the SSH agent, then ~/.ssh/id_ed25519, id_rsa and id_ecdsa)")
+	cmd.Flags().StringVar(&opts.sshCertFile, "ssh-cert", "", "SSH certificate of --ssh-key (defaults to the key path with -cert.pub appended, when it exists)")
+	cmd.Flags().StringVar(&opts.sshKeyPassphrase, "ssh-key-passphrase", "", "Passphrase of an encrypted SSH key (defaults to $"+upload.KeyPassphraseEnv+", prompted for on a terminal otherwise; the binary method's ssh asks itself)")
+	cmd.Flags().StringVar(&opts.credentialName, "credential-name", "", "Keychain entry (see the credentials command) to read the SSH password, SSH key passphrase, S3 secret key and rclone config password from when not given otherwise")
+	cmd.Flags().StringVar(&opts.sshRemotePath, "ssh-remote-path", upload.DefaultBackupPath, "Remote base path for backups")
+	cmd.Flags().StringVar(&opts.dateFormat, "date-format", upload.DefaultDateFormat, "Go time layout of the date subdirectory for SSH and dated rclone uploads")
+	cmd.Flags().BoolVar(&opts.sshAcceptNew, "ssh-accept-new", false, "Trust and add the host key of an SSH host missing from ~/.ssh/known_hosts (a changed key is still rejected)")
+	cmd.Flags().BoolVar(&opts.sshInsecure, "ssh-insecure", false, "Do not verify SSH host keys at all (vulnerable to man-in-the-middle attacks)")
+	cmd.MarkFlagsMutuallyExclusive("ssh-accept-new", "ssh-insecure")
+	cmd.Flags().DurationVar(&opts.sshKeepAlive, "ssh-keepalive-interval", upload.DefaultKeepAlive, "Send SSH keepalives this often so slow or idle transfers are not dropped; the connection is closed after 3 unanswered (0 disables)")
+	cmd.Flags().BoolVar(&opts.s3, "s3", false, "Upload to an S3-compatible bucket (AWS, MinIO, ...) configured with the --s3-* flags, without an rclone remote")
+	cmd.Flags().StringVar(&opts.s3Config.Endpoint, "s3-endpoint", "", "S3 endpoint URL, e.g. https://minio.local:9000 (defaults to AWS)")
+	cmd.Flags().StringVar(&opts.s3Config.Region, "s3-region", "", "S3 region (defaults to us-east-1)")
+	cmd.Flags().StringVar(&opts.s3Config.Bucket, "s3-bucket", "", "S3 bucket to upload to")
+	cmd.Flags().StringVar(&opts.s3Config.Prefix, "s3-prefix", "", "Directory inside the S3 bucket")
+	cmd.Flags().StringVar(&opts.s3Config.AccessKey, "s3-access-key", "", "S3 access key (defaults to the AWS environment variables and credential files)")
+	cmd.Flags().StringVar(&opts.s3Config.SecretKey, "s3-secret-key", "", "S3 secret key")
+	cmd.Flags().StringArrayVar(&opts.rcloneFlags, "rclone-flag", nil, "Rclone option as key=value, named like rclone's global flags (e.g. transfers=8, buffer-size=64M), may be repeated")
+}
+
+// rcloneConfig builds the rclone upload configuration for remote from the command line options
+func (o options) rcloneConfig(remote string) upload.RcloneConfig {
+	return upload.RcloneConfig{
+		Destination: remote,
+		Dated:       o.rcloneDated,
+		DateFormat:  o.dateFormat,
+		Subdir:      o.remoteSubdir,
+		Flags:       o.rcloneOptions,
+		BwLimit:     o.bwLimit,
+	}
+}
+
+// setProgressFD sends progress events to the file descriptor fd, which the
+// calling program must have opened, e.g. with 3>file in a shell
+func setProgressFD(fd int) error {
+	if fd < 0 {
+		return fmt.Errorf("--progress-fd must not be negative")
+	}
+	file := os.NewFile(uintptr(fd), "progress-fd")
+	if file == nil {
+		return fmt.Errorf("--progress-fd %d is not a valid file descriptor", fd)
+	}
+	if _, err := file.Stat(); err != nil {
+		return fmt.Errorf("--progress-fd %d is not open: %w", fd, err)
+	}
+	progress.SetOutput(file)
+	return nil
 }
 
 func main() {
 	var opts options
+	var summary runSummary
+	var logFormat, logFile, logFileFormat, configFile string
+	var progressFD int
+	var progressInterval time.Duration
 
 	// We'll update the logger with the verbose flag after parsing args
 	// but initialize with defaults for now
@@ -55,48 +319,115 @@ func main() {
 	}
 	defer logging.SyncLogger()
 
-	// Get sugar for local use
-	sugar := logging.GetSugar()
-
 	var rootCmd = &cobra.Command{
 		Use:     "backup-home",
 		Short:   "Backup home directory to cloud storage",
 		Version: fmt.Sprintf("%s (commit: %s, built at: %s)", version, gitCommit, buildTime),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Get source directory or default to home
-			if opts.source == "" {
+			// Get sugar for local use, after --log-format has been applied
+			sugar := logging.GetSugar()
+
+			// Get source directories or default to home
+			opts.sources = slices.DeleteFunc(opts.sources, func(source string) bool { return source == "" })
+			if len(opts.sources) == 0 {
 				home, err := homedir.Dir()
 				if err != nil {
 					return fmt.Errorf("could not determine home directory: %w", err)
 				}
-				opts.source = home
+				opts.sources = []string{home}
+			}
+
+			// A check alone ends here; with --preview it follows the summary
+			if opts.checkDestination && !opts.preview {
+				return checkDestinations(cmd.Context(), opts)
 			}
 
 			if opts.preview {
 				fmt.Println("\nPreview summary:")
 				fmt.Println("---------------")
-				fmt.Printf("Source: %s\n", opts.source)
+				fmt.Printf("Source: %s\n", strings.Join(opts.sources, ", "))
 				if !opts.skipUpload && !opts.backupOnly {
 					if opts.useSSH {
-						fmt.Printf("SSH Destination: %s@%s:%s%s\n", opts.sshUser, opts.sshHost, opts.sshRemotePath, "[hostname]/Users/[date]/")
-					} else {
-						fmt.Printf("Rclone destination: %s\n", opts.rclone)
+						if opts.sshFlat {
+							for _, host := range opts.sshHosts {
+								fmt.Printf("SSH Destination: %s@%s:%s\n", opts.sshUser, host, opts.sshRemotePath)
+							}
+						} else {
+							for _, host := range opts.sshHosts {
+								fmt.Printf("SSH Destination: %s@%s:%s\n", opts.sshUser, host, path.Join(opts.sshRemotePath, opts.remoteSubdir)+"/")
+							}
+						}
 					}
+					for _, remote := range opts.rclone {
+						if opts.rcloneDated {
+							fmt.Printf("Rclone destination: %s%s/\n", remote, opts.remoteSubdir)
+						} else {
+							fmt.Printf("Rclone destination: %s\n", remote)
+						}
+					}
+				}
+				if opts.compression == backup.StoreCompressionLevel {
+					fmt.Println("Compression level: 0 (store without compression)")
+				} else {
+					fmt.Printf("Compression level: %d\n", opts.compression)
+				}
+				if opts.format != "" {
+					fmt.Printf("Archive format: %s\n", opts.format)
+				} else if opts.bestCompress {
+					fmt.Println("Archive format: best of sampled tar formats")
 				}
-				fmt.Printf("Compression level: %d\n", opts.compression)
 				if opts.ignoreExcludes {
 					fmt.Println("Ignore excludes: Yes (backing up everything)")
+				} else {
+					if opts.excludesFile != "" {
+						fmt.Printf("Excludes file: %s\n", opts.excludesFile)
+					}
+					if opts.useIgnoreFiles {
+						fmt.Printf("Ignore files: %s in any directory\n", backup.IgnoreFileName)
+					}
+					if len(opts.presets) > 0 {
+						fmt.Printf("Exclude presets: %s\n", strings.Join(opts.presets, ", "))
+					}
+					if len(opts.excludes) > 0 {
+						fmt.Printf("Exclude patterns: %s\n", strings.Join(opts.excludes, ", "))
+					}
+					if len(opts.includes) > 0 {
+						fmt.Printf("Include patterns: %s\n", strings.Join(opts.includes, ", "))
+					}
+				}
+				if opts.gitDirtyOnly {
+					fmt.Println("Git repositories: uncommitted changes only")
+				}
+				if opts.verifyArchive {
+					fmt.Println("Verify archive: Yes")
+				}
+				if opts.stream {
+					fmt.Println("Stream: Yes (no local temp file)")
+				}
+				if opts.rcloneSync {
+					fmt.Println("Rclone sync: Yes (mirror files, no archive)")
+				}
+				if opts.snapshot {
+					fmt.Println("Snapshot: Yes (APFS, Btrfs or VSS, live directory if unsupported)")
+				}
+				if opts.incremental {
+					since := opts.since
+					if since == "" {
+						since = "last backup"
+					}
+					fmt.Printf("Incremental: Yes (files modified after %s)\n", since)
 				}
 				fmt.Println("\nThis would:")
-				fmt.Printf("1. Create backup archive of: %s\n", opts.source)
+				fmt.Printf("1. Create backup archive of: %s\n", strings.Join(opts.sources, ", "))
 				if opts.backupOnly {
 					fmt.Println("2. Keep backup file locally (backup-only mode)")
 				} else if !opts.skipUpload {
+					var targets []string
 					if opts.useSSH {
-						fmt.Printf("2. Upload via SSH to: %s@%s\n", opts.sshUser, opts.sshHost)
-					} else {
-						fmt.Printf("2. Upload to: %s\n", opts.rclone)
+						targets = append(targets, fmt.Sprintf("%s@%s (SSH)", opts.sshUser, strings.Join(opts.sshHosts, ", ")))
 					}
+					targets = append(targets, opts.rclone...)
+					fmt.Printf("2. Upload to: %s\n", strings.Join(targets, "; "))
 					if !opts.keepBackup {
 						fmt.Println("3. Clean up temporary files")
 					} else {
@@ -105,60 +436,274 @@ func main() {
 				} else {
 					fmt.Println("2. Skip upload (backup file will be preserved)")
 				}
-				return nil
+				if opts.retentionPolicy() != nil && !opts.skipUpload && !opts.backupOnly {
+					fmt.Println("\nRetention policy applied to the existing backups (the new upload will count too):")
+					if err := pruneDestinations(opts, *opts.retentionPolicy(), true); err != nil {
+						return err
+					}
+				}
+				if opts.checkDestination {
+					fmt.Println()
+					if err := checkDestinations(cmd.Context(), opts); err != nil {
+						return err
+					}
+				}
+				if !opts.listExcluded {
+					return nil
+				}
+				fmt.Println()
+			}
+
+			archiveMode, err := strconv.ParseUint(opts.archiveMode, 8, 32)
+			if err != nil || archiveMode > 0777 {
+				return fmt.Errorf("invalid --archive-mode %q: must be an octal permission like 0600", opts.archiveMode)
 			}
 
 			// Create or use existing backup
 			var backupPath string
-			var err error
+			var excludes []string
+			if !opts.ignoreExcludes && len(opts.presets) > 0 {
+				cfg, err := config.LoadDefault()
+				if err != nil {
+					return err
+				}
+				excludes, err = cfg.PresetPatterns(opts.presets...)
+				if err != nil {
+					return err
+				}
+			}
+			var baseExcludes, includes []string
+			if !opts.ignoreExcludes && opts.excludesFile != "" {
+				baseExcludes, includes, err = platform.LoadExcludePatterns(opts.excludesFile)
+				if err != nil {
+					return err
+				}
+				sugar.Infof("Using exclude patterns from %s", opts.excludesFile)
+			}
+			for _, exclude := range opts.excludes {
+				excludes = append(excludes, platform.NormalizePattern(exclude))
+			}
+			for _, include := range opts.includes {
+				includes = append(includes, platform.NormalizePattern(include))
+			}
+			backupOpts := backup.Options{
+				Source:           opts.sources[0],
+				BackupPath:       opts.backupPath,
+				TempDir:          opts.tempDir,
+				CompressionLevel: opts.compression,
+				Verbose:          opts.verbose,
+				IgnoreExcludes:   opts.ignoreExcludes,
+				SkipOnError:      opts.skipOnError,
+				VerifyArchive:    opts.verifyArchive,
+				Format:           opts.format,
+				ArchiveMode:      os.FileMode(archiveMode),
+				AllowPartial:     opts.allowPartial,
+				WaitOnDiskFull:   opts.waitOnENOSPC,
+				CheckChanges:     opts.atTime,
+				Encrypt:          opts.encrypt,
+				Passphrase:       opts.passphrase,
+				BaseExcludes:     baseExcludes,
+				Excludes:         excludes,
+				Includes:         includes,
+				SplitSize:        int64(opts.splitSize),
+				UseIgnoreFiles:   opts.useIgnoreFiles,
+				GitDirtyOnly:     opts.gitDirtyOnly,
+				PreserveXattrs:   opts.preserveXattrs,
+				Reproducible:     opts.reproducible,
+				SkippedList:      opts.skippedList,
+				NoSpaceCheck:     opts.noSpaceCheck,
+				FollowSymlinks:   opts.followSymlinks,
+				// Like a partial upload failure, low space is only tolerated by an explicit --skip-errors
+				LowSpaceWarning: cmd.Flags().Changed("skip-errors") && opts.skipOnError,
+				MaxFileSize:     int64(opts.maxFileSize),
+				MinFileSize:     int64(opts.minFileSize),
+				Concurrency:     opts.concurrency,
+				Manifest:        opts.manifest,
+				ChecksumAlgo:    opts.checksumAlgo,
+				OnSkipped:       func([]backup.SkippedFile) { summary.skipped = true },
+			}
+			if len(opts.sources) > 1 {
+				backupOpts.Source, backupOpts.Sources = "", opts.sources
+			}
+			if opts.filesFrom != "" {
+				if backupOpts.FilesFrom, err = backup.ReadFileList(opts.filesFrom); err != nil {
+					return err
+				}
+				sugar.Infof("Archiving the %d paths listed in %s", len(backupOpts.FilesFrom), opts.filesFrom)
+			}
+
+			if opts.incremental && !opts.skipBackup {
+				if backupOpts.Since, err = resolveSince(opts.since); err != nil {
+					return err
+				}
+			}
+			if opts.listExcluded {
+				return listFiles(backupOpts)
+			}
+			startTime := time.Now()
+
+			if opts.snapshot && !opts.skipBackup {
+				snap, err := snapshot.Create(backupOpts.Source)
+				if errors.Is(err, snapshot.ErrUnsupported) {
+					sugar.Warnf("Cannot snapshot the source, backing up the live directory instead: %v", err)
+				} else if err != nil {
+					return withExitCode(exitBackupFailed, fmt.Errorf("failed to create snapshot: %w", err))
+				} else {
+					defer func() {
+						if err := snap.Release(); err != nil {
+							sugar.Warnf("Failed to remove snapshot: %v", err)
+						}
+					}()
+					sugar.Infof("Backing up from snapshot: %s", snap.Path)
+					backupOpts.Source = snap.Path
+				}
+			}
+
+			if opts.rcloneSync {
+				if err := syncDestinations(cmd.Context(), opts, backupOpts, &summary); err != nil {
+					return withExitCode(exitUploadFailed, err)
+				}
+				if policy := opts.retentionPolicy(); policy != nil {
+					if err := pruneDestinations(opts, *policy, false); err != nil {
+						sugar.Warnf("Failed to delete old backups: %v", err)
+					}
+				}
+				recordBackupTime(startTime)
+				return nil
+			}
+
+			if opts.bestCompress && !opts.skipBackup {
+				if !cmd.Flags().Changed("compression") {
+					backupOpts.CompressionLevel = 9
+				}
+				backupOpts.Format, err = backup.ChooseBestFormat(cmd.Context(), backupOpts)
+				if err != nil {
+					return withExitCode(exitBackupFailed, fmt.Errorf("failed to choose compression format: %w", err))
+				}
+				opts.format = backupOpts.Format
+			}
+
+			if opts.stream {
+				if err := streamBackup(cmd.Context(), opts, backupOpts); err != nil {
+					return err
+				}
+				recordBackupTime(startTime)
+				return nil
+			}
+
 			if opts.skipBackup {
 				if opts.backupPath == "" {
 					return fmt.Errorf("--backup-path is required when using --skip-backup")
 				}
-				if _, err := os.Stat(opts.backupPath); os.IsNotExist(err) {
-					return fmt.Errorf("backup file not found: %s", opts.backupPath)
+				if _, _, err := backup.ArchiveParts(opts.backupPath); err != nil {
+					return err
 				}
 				backupPath = opts.backupPath
 				sugar.Infof("Using existing backup file: %s", backupPath)
 			} else {
-				backupPath, err = backup.CreateBackup(opts.source, opts.backupPath, opts.compression, opts.verbose, opts.ignoreExcludes, opts.skipOnError)
+				backupPath, err = backup.CreateBackup(cmd.Context(), backupOpts)
 			}
 			if err != nil {
-				return fmt.Errorf("failed to create backup: %w", err)
+				return withExitCode(exitBackupFailed, fmt.Errorf("failed to create backup: %w", err))
+			}
+			// Housekeeping of older archives does not fail the backup just made
+			if opts.keepLastLocal > 0 && !opts.skipBackup {
+				if _, err := backup.PruneLocalBackups(backupPath, opts.keepLastLocal); err != nil {
+					sugar.Warnf("Failed to prune old local backups: %v", err)
+				}
+			}
+
+			// A split archive is uploaded as its parts followed by the manifest
+			parts, manifest, err := backup.ArchiveParts(backupPath)
+			if err != nil {
+				return err
+			}
+			summary.archive = backupPath
+			if summary.bytes, err = archiveSize(parts); err != nil {
+				return err
+			}
+
+			// The checksum files next to the archive are uploaded with it. The
+			// archiver wrote them while archiving; an existing or partial archive
+			// is hashed now.
+			uploadPaths := append([]string{}, parts...)
+			if opts.checksumAlgo != "" {
+				for _, part := range parts {
+					sidecarPath := checksum.SidecarPath(part, opts.checksumAlgo)
+					if _, err := os.Stat(sidecarPath); opts.skipBackup || err != nil {
+						if sidecarPath, err = checksum.WriteSidecar(part, opts.checksumAlgo); err != nil {
+							return withExitCode(exitBackupFailed, fmt.Errorf("failed to create checksum file: %w", err))
+						}
+						sugar.Infof("Checksum file: %s", sidecarPath)
+					}
+					uploadPaths = append(uploadPaths, sidecarPath)
+				}
+			}
+			if manifest != "" {
+				uploadPaths = append(uploadPaths, manifest)
+			}
+			// newContents is the manifest of an archive made by this run, which
+			// tells what changed since the last backup
+			var newContents string
+			if opts.manifest {
+				contentsPath := backupPath + backup.ContentsExtension
+				if _, err := os.Stat(contentsPath); err == nil {
+					uploadPaths = append(uploadPaths, contentsPath)
+					if !opts.skipBackup {
+						newContents = contentsPath
+						summarizeChanges(newContents)
+					}
+				} else {
+					sugar.Warnf("Contents manifest not found, uploading without it: %s", contentsPath)
+				}
 			}
 
 			// Handle upload based on mode
 			if opts.backupOnly {
 				sugar.Infof("Backup-only mode. Backup file is available at: %s", backupPath)
+				recordContents(newContents)
 			} else if !opts.skipUpload {
-				var uploadErr error
-				
-				if opts.useSSH {
-					// Upload via SSH
-					sshConfig := upload.SSHConfig{
-						Host:       opts.sshHost,
-						Port:       opts.sshPort,
-						User:       opts.sshUser,
-						Password:   opts.sshPassword,
-						KeyFile:    opts.sshKeyFile,
-						RemotePath: opts.sshRemotePath,
-					}
-					uploadErr = upload.UploadToSSH(backupPath, sshConfig, opts.verbose)
-				} else {
-					// Upload via rclone
-					uploadErr = upload.UploadToRclone(backupPath, opts.rclone, opts.verbose)
+				if err := checkBackupSize(parts, opts.minBackupSize); err != nil {
+					sugar.Infof("Backup file preserved at: %s", backupPath)
+					return withExitCode(exitBackupFailed, err)
 				}
 
-				if uploadErr != nil {
-					sugar.Errorf("Upload failed: %v", uploadErr)
+				// Cleanup waits until every destination has the backup
+				if failed := uploadToDestinations(cmd.Context(), uploadPaths, opts, &summary); len(failed) > 0 {
 					sugar.Infof("Backup file preserved at: %s", backupPath)
-					return fmt.Errorf("failed to upload backup: %w", uploadErr)
+					if err := cmd.Context().Err(); err != nil {
+						return fmt.Errorf("upload canceled: %w", err)
+					}
+					uploadErr := fmt.Errorf("failed to upload backup to %d of %d destinations: %s", len(failed), len(opts.destinations()), strings.Join(failed, "; "))
+					// An explicit --skip-errors tolerates some destinations failing, not all of them
+					if cmd.Flags().Changed("skip-errors") && opts.skipOnError && len(failed) < len(opts.destinations()) {
+						sugar.Warnf("Some destinations failed (tolerated by --skip-errors): %v", uploadErr)
+						recordBackupTime(startTime)
+						recordContents(newContents)
+						return nil
+					}
+					return withExitCode(exitUploadFailed, uploadErr)
+				}
+
+				if policy := opts.retentionPolicy(); policy != nil {
+					if err := pruneDestinations(opts, *policy, false); err != nil {
+						sugar.Warnf("Failed to delete old backups: %v", err)
+					}
 				}
 
+				// The manifest is kept for the next run before cleanup removes it
+				recordContents(newContents)
+
 				// Cleanup only after successful upload and if not keeping backup
 				if !opts.keepBackup {
-					if err := os.Remove(backupPath); err != nil {
-						sugar.Warnf("Failed to cleanup backup file after successful upload: %v", err)
+					var cleanupErr error
+					for _, uploadPath := range uploadPaths {
+						if err := os.Remove(uploadPath); err != nil {
+							cleanupErr = err
+						}
+					}
+					if cleanupErr != nil {
+						sugar.Warnf("Failed to cleanup backup file after successful upload: %v", cleanupErr)
 					} else {
 						sugar.Infof("Successfully uploaded and cleaned up backup file")
 					}
@@ -167,8 +712,12 @@ func main() {
 				}
 			} else {
 				sugar.Infof("Upload skipped. Backup file is available at: %s", backupPath)
+				recordContents(newContents)
 			}
 
+			if !opts.skipBackup {
+				recordBackupTime(startTime)
+			}
 			return nil
 		},
 	}
@@ -179,26 +728,115 @@ func main() {
 		log.Fatalf("failed to get home directory: %v", err)
 	}
 
-	rootCmd.Flags().StringVarP(&opts.source, "source", "s", homeDir, "Source directory to backup (defaults to home directory)")
-	rootCmd.Flags().StringVarP(&opts.rclone, "rclone", "r", "", "Rclone destination path (e.g., \"drive:\", \"gdrive:backup/home\")")
+	rootCmd.Flags().StringArrayVarP(&opts.sources, "source", "s", []string{homeDir}, "Source directory to backup (defaults to home directory), may be repeated to archive several directories, each under a top-level directory named after it")
 	rootCmd.Flags().StringVar(&opts.backupPath, "backup-path", "", "Custom path for temporary backup file (defaults to system temp directory)")
-	rootCmd.Flags().IntVarP(&opts.compression, "compression", "c", 6, "Compression level (0-9, default: 6)")
+	rootCmd.Flags().StringVar(&opts.tempDir, "temp-dir", "", "Directory for the automatically named backup file when --backup-path is not given (defaults to $"+platform.TempDirEnv+", then the system temp directory)")
+	rootCmd.Flags().IntVarP(&opts.compression, "compression", "c", 6, "Compression level: 0 (store without compression) to 9 (smallest), default: 6")
+	rootCmd.Flags().BoolVar(&opts.store, "store", false, "Store files without compression, the fastest option for sources of already compressed media (same as --compression 0)")
 	rootCmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "Enable verbose output")
-	rootCmd.Flags().Bool("preview", false, "Preview what would be done without actually doing it")
-	rootCmd.Flags().BoolVar(&opts.skipOnError, "skip-errors", true, "Skip files that can't be accessed instead of failing")
+	rootCmd.Flags().BoolVar(&opts.preview, "preview", false, "Preview what would be done without actually doing it (with --verbose, also list included and excluded files)")
+	rootCmd.Flags().BoolVar(&opts.checkDestination, "check-destination", false, "Connect to every upload destination and write and delete a small test file there to confirm credentials and write permission, without creating an archive (with --preview, after the preview summary)")
+	rootCmd.Flags().BoolVar(&opts.listExcluded, "list-excluded", false, "Walk the source and print every path that would be included (+) or excluded (-) with a total, without creating an archive")
+	rootCmd.Flags().BoolVar(&opts.skipOnError, "skip-errors", true, "Skip files that can't be accessed instead of failing; when given explicitly, also succeed if only some upload destinations fail")
 	rootCmd.Flags().BoolVar(&opts.skipUpload, "skip-upload", false, "Skip uploading the backup archive")
 	rootCmd.Flags().BoolVar(&opts.keepBackup, "keep-backup", false, "Keep the backup file after uploading")
+	rootCmd.Flags().IntVar(&opts.keepLastLocal, "keep-last-n-local", 0, "After creating a backup, delete all but the newest N archives named username.tar.gz or username.*.tar.gz (with the new archive's extension) in its directory, with their checksum and part files")
 	rootCmd.Flags().BoolVar(&opts.ignoreExcludes, "ignore-excludes", false, "Ignore exclude patterns and backup everything")
+	rootCmd.Flags().BoolVar(&opts.excludeCommon, "exclude-common", false, "Also exclude trash, cache and package manager cache directories (same as --preset common)")
+	rootCmd.Flags().StringVar(&opts.filesFrom, "files-from", "", "Archive only the files and directories listed in this file, one per line, relative to --source, instead of walking the whole source; listed paths bypass the excludes")
+	rootCmd.Flags().StringVar(&opts.excludesFile, "excludes-file", "", "File of exclude patterns, one per line, added to the defaults or replacing them with a leading @replace line, and !pattern lines re-including paths (defaults to ~/.config/backup-home/excludes.txt if it exists)")
+	rootCmd.Flags().StringArrayVar(&opts.excludes, "exclude", nil, "Pattern of paths to leave out for this run (e.g. '*.iso', Videos/raw), added to the defaults, may be repeated; like a line in the excludes file")
+	rootCmd.Flags().StringArrayVar(&opts.includes, "include", nil, "Pattern re-including paths the excludes leave out, even inside excluded directories (e.g. node_modules/.bin), may be repeated; like a !pattern line in the excludes file")
+	rootCmd.Flags().BoolVar(&opts.useIgnoreFiles, "use-ignore-files", false, "Apply the gitignore-style patterns of a "+backup.IgnoreFileName+" file in any directory to that directory's subtree")
+	rootCmd.Flags().BoolVar(&opts.gitDirtyOnly, "git-dirty-only", false, "Experimental: in each git repository found in the source, only archive the files git status reports as modified or untracked (needs git)")
+	rootCmd.Flags().StringSliceVar(&opts.presets, "preset", nil, "Named exclude presets to apply, may be repeated (see 'presets' command)")
+	rootCmd.Flags().BoolVar(&opts.verifyArchive, "verify-archive", false, "Re-read and decompress the archive after creating it to check it is not corrupt")
+	rootCmd.Flags().StringVar(&opts.format, "format", "", fmt.Sprintf("Archive format: %s (defaults to zip on Windows, tar.gz elsewhere; tar.xz is slowest but smallest)", strings.Join(backup.Formats(), ", ")))
+	rootCmd.Flags().StringVar(&opts.compressionFormat, "compression-format", "", fmt.Sprintf("Compressor of the tar archive: %s (shorthand for --format tar.gz, tar.zst, ...)", strings.Join(backup.CompressionFormats(), ", ")))
+	rootCmd.Flags().BoolVar(&opts.bestCompress, "best-compression", false, "Compress a sample of the source with each tar format and use the one giving the smallest output")
+	rootCmd.Flags().StringVar(&opts.archiveMode, "archive-mode", "0600", "Permission mode of the created archive file (octal)")
+	rootCmd.Flags().BoolVar(&opts.allowPartial, "allow-partial", false, "If archiving fails midway, keep what was written (marked .partial) and upload it anyway")
+	rootCmd.Flags().DurationVar(&opts.waitOnENOSPC, "wait-on-enospc", 0, "When the output disk fills up, pause and retry writes for up to this long (e.g. 30m) instead of failing")
+	rootCmd.Flags().Var(&opts.minBackupSize, "min-backup-size", "Abort before uploading if the archive is smaller than this (e.g. 100M), guarding against an empty or unmounted source")
+	rootCmd.Flags().Var(&opts.splitSize, "split-size", "Write the archive as numbered parts of at most this size (e.g. 2G) plus a .parts manifest, and upload each part")
+	rootCmd.Flags().BoolVar(&opts.checksum, "checksum", false, "Write a SHA-256 checksum file (.sha256) next to the archive and upload it too (same as --checksum-algo sha256)")
+	rootCmd.Flags().BoolVar(&opts.skipUnchanged, "skip-unchanged", false, "Skip uploading to a destination whose newest dated backup has the same checksum as the new archive (implies --checksum)")
+	rootCmd.Flags().StringVar(&opts.checksumAlgo, "checksum-algo", "", fmt.Sprintf("Write a checksum file next to the archive (named after the algorithm) and upload it too: %s", strings.Join(checksum.Algorithms(), ", ")))
+	rootCmd.Flags().Var(&opts.maxFileSize, "max-file-size", "Leave out regular files larger than this (e.g. 1G), such as VM disk images; each one is logged")
+	rootCmd.Flags().Var(&opts.minFileSize, "min-file-size", "Leave out regular files smaller than this (e.g. 1K)")
+	rootCmd.Flags().BoolVar(&opts.followSymlinks, "follow-symlinks", false, "Archive the files and directories symlinks point to instead of the links themselves (links leading back into the source are kept as links)")
+	rootCmd.Flags().BoolVar(&opts.noSpaceCheck, "no-space-check", false, "Do not check before archiving that the backup path has room for the source (its size plus 10%); with an explicit --skip-errors a shortfall is only a warning")
+	rootCmd.Flags().BoolVar(&opts.skippedList, "skipped-list", false, "Write the paths skipped because of errors next to the archive (<archive>"+backup.SkippedExtension+")")
+	rootCmd.Flags().BoolVar(&opts.preserveXattrs, "preserve-xattrs", false, "Store extended attributes in the tar archive (only user.* on Linux) and reapply them on restore")
+	rootCmd.Flags().BoolVar(&opts.reproducible, "reproducible", false, "Write byte-identical archives for unchanged content, for deduplicating storage: entry times are fixed to 1980-01-01 and owner IDs and names left out")
+	rootCmd.Flags().BoolVar(&opts.manifest, "manifest", false, "Write a JSON list of every archived path with its size, mode and modification time next to the archive (<archive>"+backup.ContentsExtension+", not encrypted) and upload it too")
 	rootCmd.Flags().BoolVar(&opts.backupOnly, "backup-only", false, "Create backup archive only, skip all uploads")
 	rootCmd.Flags().BoolVar(&opts.skipBackup, "skip-backup", false, "Skip backup creation and upload existing backup file (requires --backup-path)")
+	rootCmd.Flags().IntVar(&opts.concurrency, "concurrency", 0, "Goroutines reading and compressing files, and SFTP requests in flight per file (defaults to one per CPU and 32)")
+	rootCmd.Flags().StringVar(&opts.notifyURL, "notify-url", "", "POST a JSON summary of the run (status, host, archive size, duration, error) to this URL when it finishes, successful or not")
+	rootCmd.Flags().StringVar(&opts.notifyCommand, "notify-command", "", "Run this shell command when the run finishes, successful or not, with BACKUP_HOME_STATUS, _HOST, _SOURCE, _ARCHIVE, _BYTES, _DURATION and _ERROR set")
+	rootCmd.Flags().StringVar(&opts.resultFile, "result-file", "", "Write a JSON result of the run (times, source, archive size, per-destination bytes, duration and throughput, error) to this file when it finishes, successful or not")
+	rootCmd.Flags().StringVar(&opts.healthcheckURL, "healthcheck-url", "", "Ping this healthchecks.io style check URL: <url>/start before the run, <url> on success and <url>/fail with the error on failure")
+	rootCmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "Abort the backup and upload if they take longer than this (e.g. 2h), removing the incomplete archive and failing the run (0 disables)")
+	rootCmd.Flags().IntVar(&opts.uploadRetries, "upload-retries", upload.DefaultRetries, "Times to retry an upload after a network failure, waiting longer before each retry (not with --stream)")
+	rootCmd.Flags().StringVar(&opts.bwLimitSchedule, "bwlimit-schedule", "", "Upload bandwidth limit by time of day as HH:MM-HH:MM:rate ranges, e.g. \"08:00-23:00:2M,23:00-08:00:off\" (unlimited outside the ranges), or a single rate such as 2M; checked every minute during an upload")
+	// Remote flags shared with the prune command
+	addRemoteFlags(rootCmd, &opts)
 	// SSH upload flags
-	rootCmd.Flags().BoolVar(&opts.useSSH, "ssh", false, "Use SSH/SCP upload instead of rclone")
-	rootCmd.Flags().StringVar(&opts.sshHost, "ssh-host", upload.DefaultTargetMachine, "SSH host to upload to")
-	rootCmd.Flags().StringVar(&opts.sshPort, "ssh-port", upload.DefaultSSHPort, "SSH port")
-	rootCmd.Flags().StringVar(&opts.sshUser, "ssh-user", upload.DefaultSSHUser, "SSH username")
-	rootCmd.Flags().StringVar(&opts.sshPassword, "ssh-password", "", "SSH password (not recommended, use key file instead)")
-	rootCmd.Flags().StringVar(&opts.sshKeyFile, "ssh-key", "", "SSH private key file path (defaults to SSH agent)")
-	rootCmd.Flags().StringVar(&opts.sshRemotePath, "ssh-remote-path", upload.DefaultBackupPath, "Remote base path for backups")
+	rootCmd.Flags().StringVar(&opts.sshMethod, "ssh-method", upload.DefaultSSHMethod, "SSH upload implementation: binary (system scp, fastest, honors ~/.ssh/config, no password auth), sftp (pure Go SFTP with --concurrency requests in flight), scp (pure Go SCP, one stream, for servers without SFTP or that misbehave with concurrent requests) or goph (SFTP through the goph client)")
+	rootCmd.Flags().BoolVar(&opts.verifyUpload, "verify-upload", false, "After each SSH upload, compare the SHA-256 of the remote file (computed on the server, or read back over SFTP) with the local archive and fail on a mismatch")
+	rootCmd.Flags().Var(&opts.minRemoteFree, "min-remote-free", "Before each SSH upload, check the free space of --ssh-remote-path (SFTP statvfs or df) and abort if it cannot hold the archive plus this margin (e.g. 5G, 0 disables)")
+	rootCmd.Flags().IntVar(&opts.sshParallel, "ssh-parallel", 1, "Number of SSH hosts to upload to at the same time")
+	rootCmd.Flags().BoolVar(&opts.parallelUploads, "parallel-uploads", false, "Upload to all destinations (--ssh and each --rclone) at the same time instead of one after another")
+	rootCmd.Flags().BoolVar(&opts.sshFlat, "ssh-flat", false, "Upload directly into --ssh-remote-path without hostname/Users/date subdirectories")
+	rootCmd.Flags().StringVar(&opts.sshChmod, "ssh-chmod", "", "Octal mode to set on the uploaded file and its date directory after upload (e.g. 0640)")
+	rootCmd.Flags().StringVar(&opts.sshChown, "ssh-chown", "", "Owner to set on the uploaded file and its date directory after upload (user:group or :group)")
+
+	rootCmd.Flags().IntVar(&opts.keep, "keep", 0, "After a successful upload, delete all but the newest N dated backup directories of this host on each destination")
+	rootCmd.Flags().Var(&opts.retention, "retention", "After a successful upload, delete dated backup directories of this host older than this (e.g. 7d, 4w) on each destination")
+	rootCmd.Flags().BoolVar(&opts.rcloneSync, "rclone-sync", false, "Mirror the source file by file to the --rclone destinations with rclone sync instead of uploading an archive, applying the exclude and include patterns. DELETES every file at the destination that is not in the source, so the destination must be a dedicated subdirectory, never the root of a remote or bucket")
+	rootCmd.Flags().BoolVar(&opts.rcloneDated, "rclone-dated", false, "Upload into hostname/Users/date subdirectories of the rclone destination")
+	rootCmd.Flags().StringVar(&opts.remoteTemplate, "remote-template", upload.DefaultRemoteTemplate, "Go template of the dated subdirectory for SSH and dated rclone uploads, with {{.Host}}, {{.User}}, {{.Date}} (per --date-format), {{.Year}}, {{.Month}}, {{.Day}} and {{.Time}}")
+
+	rootCmd.Flags().BoolVar(&opts.incremental, "incremental", false, "Only archive files modified since the last successful backup (or --since); directories are always recorded")
+	rootCmd.Flags().StringVar(&opts.since, "since", "", "Reference for --incremental: a timestamp like 2024-01-31 or 2024-01-31T15:04:05, or a file whose modification time is used")
+	rootCmd.Flags().BoolVar(&opts.snapshot, "snapshot", false, "Back up from a filesystem snapshot of the source (APFS on macOS, Btrfs on Linux, VSS on Windows) for a consistent point-in-time archive")
+	rootCmd.Flags().BoolVar(&opts.snapshot, "vss", false, "Same as --snapshot; on Windows, back up from a Volume Shadow Copy so files locked by running applications can be read")
+	rootCmd.Flags().BoolVar(&opts.atTime, "at-time", false, "After archiving, re-check archived files and warn about any that changed during the backup")
+	rootCmd.Flags().BoolVar(&opts.encrypt, "encrypt", false, "Encrypt the archive with AES-256-GCM (adds .enc to the file name)")
+	rootCmd.Flags().StringVar(&opts.passphrase, "encrypt-passphrase", "", "Passphrase for --encrypt (defaults to $"+crypt.PassphraseEnv+")")
+	rootCmd.Flags().BoolVar(&opts.stream, "stream", false, "Stream the archive straight to the remote without creating a local temp file")
+
+	// The log settings apply to every subcommand, so they are set before any of them runs
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatConsole, fmt.Sprintf("Log output format: %s or %s (one JSON object per line)", logging.FormatConsole, logging.FormatJSON))
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Also write logs to this file, rotated at 10 MB keeping 5 old files (for unattended runs)")
+	rootCmd.PersistentFlags().StringVar(&logFileFormat, "log-file-format", logging.FormatConsole, fmt.Sprintf("Format of --log-file: %s (plain text) or %s", logging.FormatConsole, logging.FormatJSON))
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "YAML or TOML file (by .toml extension) of default flag values keyed by flag name, e.g. source, rclone, ssh-host; command line flags override it")
+	rootCmd.PersistentFlags().IntVar(&progressFD, "progress-fd", 0, "Write progress events as JSON Lines to this already open file descriptor, e.g. 3 with 3>progress.jsonl, for wrapping programs")
+	rootCmd.PersistentFlags().DurationVar(&progressInterval, "progress-interval", progress.DefaultInterval, "How often archiving and transfers report progress, e.g. 30s for quieter overnight runs")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if configFile != "" {
+			if err := applyConfigFile(cmd, configFile); err != nil {
+				return err
+			}
+		}
+		if progressInterval <= 0 {
+			return fmt.Errorf("--progress-interval must be positive")
+		}
+		progress.SetInterval(progressInterval)
+		if progressFD != 0 {
+			if err := setProgressFD(progressFD); err != nil {
+				return err
+			}
+		}
+		if err := logging.SetFormat(logFormat); err != nil {
+			return err
+		}
+		if logFile != "" {
+			return logging.SetFile(logFile, logFileFormat)
+		}
+		return nil
+	}
 
 	// Update logger and validate flags before running
 	rootCmd.PreRunE = func(cmd *cobra.Command, args []string) error {
@@ -207,30 +845,308 @@ func main() {
 			return fmt.Errorf("failed to reinitialize logger: %w", err)
 		}
 
+		if opts.excludeCommon {
+			opts.presets = append(opts.presets, platform.CommonPreset)
+		}
+		if opts.preview && opts.verbose {
+			opts.listExcluded = true
+		}
+
+		if !cmd.Flags().Changed("excludes-file") {
+			if path, err := config.DefaultExcludesPath(); err == nil {
+				if _, err := os.Stat(path); err == nil {
+					opts.excludesFile = path
+				}
+			}
+		}
+
+		if err := opts.loadCredentials(); err != nil {
+			return err
+		}
+		if err := opts.addS3Destination(); err != nil {
+			return err
+		}
+
 		// Set default upload mode to SSH if no mode is specified
 		skipUpload, _ := cmd.Flags().GetBool("skip-upload")
-		if !skipUpload && !opts.backupOnly && opts.rclone == "" && !opts.useSSH {
+		if !skipUpload && !opts.backupOnly && len(opts.rclone) == 0 && !opts.useSSH {
 			opts.useSSH = true
 		}
-		
+
+		if opts.compressionFormat != "" {
+			if opts.format != "" {
+				return fmt.Errorf("--compression-format cannot be combined with --format")
+			}
+			format, err := backup.TarFormatFor(opts.compressionFormat)
+			if err != nil {
+				return err
+			}
+			opts.format = format
+		}
+		if opts.bestCompress && opts.format != "" {
+			return fmt.Errorf("--best-compression chooses the format itself and cannot be combined with --format")
+		}
+		if opts.store {
+			if cmd.Flags().Changed("compression") || opts.bestCompress {
+				return fmt.Errorf("--store cannot be combined with --compression or --best-compression")
+			}
+			opts.compression = backup.StoreCompressionLevel
+		}
+		if err := backup.CheckCompressionLevel(opts.compression); err != nil {
+			return err
+		}
+
+		if len(opts.sources) > 1 && (opts.snapshot || opts.rcloneSync) {
+			return fmt.Errorf("--snapshot and --rclone-sync only work with a single --source")
+		}
+		if opts.rcloneSync {
+			if len(opts.rclone) == 0 || opts.useSSH {
+				return fmt.Errorf("--rclone-sync mirrors to --rclone or --s3 destinations and cannot be combined with --ssh")
+			}
+			if opts.stream || opts.skipBackup || opts.backupOnly || skipUpload || opts.encrypt || opts.splitSize > 0 || opts.manifest || opts.incremental {
+				return fmt.Errorf("--rclone-sync creates no archive and cannot be combined with --stream, --skip-backup, --backup-only, --skip-upload, --encrypt, --split-size, --manifest or --incremental")
+			}
+			if opts.useIgnoreFiles || opts.gitDirtyOnly || opts.filesFrom != "" {
+				return fmt.Errorf("--rclone-sync only applies exclude and include patterns, not --use-ignore-files, --git-dirty-only or --files-from")
+			}
+			if !opts.rcloneDated {
+				for _, destination := range opts.rclone {
+					if err := upload.CheckSyncDestination(destination); err != nil {
+						return fmt.Errorf("--rclone-sync: %w (or use --rclone-dated)", err)
+					}
+				}
+			}
+		}
+		if opts.filesFrom != "" && len(opts.sources) > 1 {
+			return fmt.Errorf("--files-from lists paths relative to a single --source")
+		}
+
+		if opts.keepLastLocal < 0 {
+			return fmt.Errorf("--keep-last-n-local must not be negative")
+		}
+		if opts.keepLastLocal > 0 && opts.skipBackup {
+			return fmt.Errorf("--keep-last-n-local prunes older archives after creating one, so it cannot be combined with --skip-backup")
+		}
+
+		if opts.stream {
+			if opts.skipBackup || opts.backupOnly || skipUpload {
+				return fmt.Errorf("--stream cannot be combined with --skip-backup, --backup-only or --skip-upload")
+			}
+			if len(opts.destinations()) > 1 || (len(opts.sshHosts) > 1 && opts.useSSH) {
+				return fmt.Errorf("--stream uploads a single stream and cannot be combined with several --ssh-host or --rclone destinations")
+			}
+			if opts.keepBackup || opts.verifyArchive || opts.allowPartial || opts.waitOnENOSPC > 0 || opts.verifyUpload || opts.minRemoteFree > 0 || opts.keepLastLocal > 0 {
+				return fmt.Errorf("--stream does not create a local file, so --keep-backup, --verify-archive, --allow-partial, --wait-on-enospc, --verify-upload, --min-remote-free and --keep-last-n-local do not apply")
+			}
+			if opts.minBackupSize > 0 {
+				return fmt.Errorf("--stream uploads while archiving, so --min-backup-size cannot be checked before upload")
+			}
+			if opts.splitSize > 0 {
+				return fmt.Errorf("--stream uploads a single file and cannot be combined with --split-size")
+			}
+			if opts.manifest || opts.skippedList {
+				return fmt.Errorf("--stream does not create a local file, so --manifest and --skipped-list do not apply")
+			}
+		}
+		if opts.preserveXattrs {
+			if runtime.GOOS == "windows" {
+				return fmt.Errorf("--preserve-xattrs is not supported on Windows")
+			}
+			if opts.format == backup.FormatZip {
+				return fmt.Errorf("--preserve-xattrs needs a tar format: zip archives cannot store extended attributes")
+			}
+		}
+		if opts.reproducible && opts.encrypt {
+			return fmt.Errorf("--reproducible cannot be combined with --encrypt: encrypted archives differ on every run")
+		}
+		if opts.maxFileSize < 0 || opts.minFileSize < 0 {
+			return fmt.Errorf("--max-file-size and --min-file-size must not be negative")
+		}
+		if opts.maxFileSize > 0 && opts.minFileSize > opts.maxFileSize {
+			return fmt.Errorf("--min-file-size must not be larger than --max-file-size")
+		}
+		if opts.splitSize < 0 {
+			return fmt.Errorf("--split-size must not be negative")
+		}
+		if opts.splitSize > 0 && opts.allowPartial {
+			return fmt.Errorf("--allow-partial cannot be combined with --split-size")
+		}
+
+		if opts.sshChmod != "" {
+			mode, err := strconv.ParseUint(opts.sshChmod, 8, 32)
+			if err != nil || mode == 0 || mode > 0777 {
+				return fmt.Errorf("invalid --ssh-chmod %q: must be an octal permission like 0640", opts.sshChmod)
+			}
+			opts.sshChmodMode = os.FileMode(mode)
+		}
+		if (opts.sshChmod != "" || opts.sshChown != "") && !opts.useSSH {
+			return fmt.Errorf("--ssh-chmod and --ssh-chown only apply to SSH uploads")
+		}
+		if opts.verifyUpload && !opts.useSSH {
+			return fmt.Errorf("--verify-upload only applies to SSH uploads")
+		}
+		if opts.minRemoteFree > 0 && !opts.useSSH {
+			return fmt.Errorf("--min-remote-free only applies to SSH uploads")
+		}
+		if opts.checkDestination && (opts.backupOnly || skipUpload) {
+			return fmt.Errorf("--check-destination checks the upload destinations, so it cannot be combined with --backup-only or --skip-upload")
+		}
+
+		if opts.keep < 0 || opts.retention < 0 {
+			return fmt.Errorf("--keep and --retention must not be negative")
+		}
+		if opts.retentionPolicy() != nil {
+			if opts.stream || opts.backupOnly || skipUpload {
+				return fmt.Errorf("--keep and --retention apply after an upload, so they cannot be combined with --stream, --backup-only or --skip-upload")
+			}
+			if opts.useSSH && opts.sshFlat {
+				return fmt.Errorf("--keep and --retention need dated directories, so they cannot be combined with --ssh-flat")
+			}
+			if len(opts.rclone) > 0 && !opts.rcloneDated {
+				return fmt.Errorf("--keep and --retention need dated directories on rclone destinations: add --rclone-dated")
+			}
+			if opts.remoteTemplate != upload.DefaultRemoteTemplate {
+				return fmt.Errorf("--keep and --retention prune the default hostname/Users/date layout and cannot be combined with --remote-template")
+			}
+		}
+
+		// Rendered once so every destination gets the same directory, even if
+		// the run crosses midnight
+		remoteSubdir, err := upload.RenderRemoteTemplate(opts.remoteTemplate, opts.dateFormat, time.Now())
+		if err != nil {
+			return err
+		}
+		opts.remoteSubdir = remoteSubdir
+
+		if err := checkHTTPURL("notify-url", opts.notifyURL); err != nil {
+			return err
+		}
+		if err := checkHTTPURL("healthcheck-url", opts.healthcheckURL); err != nil {
+			return err
+		}
+
+		if opts.tempDir != "" {
+			if opts.backupPath != "" {
+				return fmt.Errorf("--temp-dir only places the automatically named backup file and cannot be combined with --backup-path")
+			}
+			if err := platform.CheckTempDir(opts.tempDir); err != nil {
+				return fmt.Errorf("invalid --temp-dir: %w", err)
+			}
+		}
+
+		if opts.since != "" && !opts.incremental {
+			return fmt.Errorf("--since requires --incremental")
+		}
+
+		if opts.skipUnchanged {
+			if opts.stream || opts.backupOnly || skipUpload {
+				return fmt.Errorf("--skip-unchanged compares the archive with the remote before uploading, so it cannot be combined with --stream, --backup-only or --skip-upload")
+			}
+			if (opts.useSSH && opts.sshFlat) || (len(opts.rclone) > 0 && !opts.rcloneDated) || opts.remoteTemplate != upload.DefaultRemoteTemplate {
+				return fmt.Errorf("--skip-unchanged looks for the newest backup in the default hostname/Users/date layout: it cannot be combined with --ssh-flat or --remote-template, and rclone destinations need --rclone-dated")
+			}
+			if opts.encrypt {
+				return fmt.Errorf("--skip-unchanged cannot be combined with --encrypt: encrypted archives differ on every run")
+			}
+			if opts.splitSize > 0 {
+				return fmt.Errorf("--skip-unchanged compares a single archive file and cannot be combined with --split-size")
+			}
+			// The checksum file uploaded with the archive is what the next run compares with
+			opts.checksum = true
+		}
+		if opts.checksum && opts.checksumAlgo == "" {
+			opts.checksumAlgo = checksum.DefaultAlgorithm
+		}
+		if opts.checksumAlgo != "" {
+			if _, err := checksum.New(opts.checksumAlgo); err != nil {
+				return err
+			}
+		}
+
+		if opts.encrypt && opts.passphrase == "" {
+			opts.passphrase = os.Getenv(crypt.PassphraseEnv)
+			if opts.passphrase == "" {
+				return fmt.Errorf("--encrypt requires --encrypt-passphrase or $%s", crypt.PassphraseEnv)
+			}
+		}
+
+		if opts.waitOnENOSPC < 0 {
+			return fmt.Errorf("--wait-on-enospc must not be negative")
+		}
+		if opts.timeout < 0 {
+			return fmt.Errorf("--timeout must not be negative")
+		}
+		if opts.uploadRetries < 0 {
+			return fmt.Errorf("--upload-retries must not be negative")
+		}
+		if opts.concurrency < 0 {
+			return fmt.Errorf("--concurrency must not be negative")
+		}
+		if err := opts.parseRcloneFlags(); err != nil {
+			return err
+		}
+		if opts.bwLimitSchedule != "" {
+			if opts.bwLimit, err = upload.ParseBwSchedule(opts.bwLimitSchedule); err != nil {
+				return err
+			}
+		}
+
 		// Validate configuration based on selected mode
 		if !skipUpload && !opts.backupOnly {
+			for _, remote := range opts.rclone {
+				if remote == "" {
+					return fmt.Errorf("rclone destination must not be empty")
+				}
+			}
 			if opts.useSSH {
 				// Validate SSH configuration
-				if opts.sshHost == "" {
+				if len(opts.sshHosts) == 0 {
 					return fmt.Errorf("SSH host is required when using SSH upload")
 				}
-			} else if opts.rclone != "" {
-				// rclone mode - no additional validation needed
-			} else {
+				for _, host := range opts.sshHosts {
+					if host == "" {
+						return fmt.Errorf("SSH host must not be empty")
+					}
+				}
+				if !slices.Contains(upload.SSHMethods(), opts.sshMethod) {
+					return fmt.Errorf("invalid --ssh-method %q: must be one of %s", opts.sshMethod, strings.Join(upload.SSHMethods(), ", "))
+				}
+				if opts.sshMethod == upload.SSHMethodBinary && cmd.Flags().Changed("ssh-password") {
+					return fmt.Errorf("--ssh-password is not supported by the binary SSH method: use --ssh-method sftp, scp or goph")
+				}
+				if opts.sshCertFile != "" && opts.sshKeyFile == "" {
+					return fmt.Errorf("--ssh-cert requires --ssh-key; certificates in the SSH agent are used automatically")
+				}
+				if opts.stream && cmd.Flags().Changed("ssh-method") {
+					return fmt.Errorf("--stream always writes over SFTP and cannot be combined with --ssh-method")
+				}
+			} else if len(opts.rclone) == 0 {
 				return fmt.Errorf("must specify upload mode: --rclone (rclone upload), --ssh (SSH upload), or --backup-only (local only)")
 			}
 		}
 		return nil
 	}
 
-	if err := rootCmd.Execute(); err != nil {
+	rootCmd.RunE = withHealthcheck(withNotifications(withTimeout(rootCmd.RunE, &opts), &opts, &summary), &opts)
+
+	rootCmd.AddCommand(newPresetsCmd())
+	rootCmd.AddCommand(newCredentialsCmd())
+	rootCmd.AddCommand(newPruneCmd())
+	rootCmd.AddCommand(newListCmd())
+	rootCmd.AddCommand(newDecryptCmd())
+	rootCmd.AddCommand(newRestoreCmd())
+	rootCmd.AddCommand(newVerifyCmd())
+	rootCmd.AddCommand(newDiffCmd())
+	rootCmd.AddCommand(newBenchmarkCmd())
+
+	ctx, stop := interruptContext()
+	defer stop()
+	err = rootCmd.ExecuteContext(ctx)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	}
+	if code := exitCode(err, summary); code != exitSuccess {
+		stop()
+		os.Exit(code)
 	}
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"backup-home/internal/logging"
+	"backup-home/internal/notify"
+
+	"github.com/spf13/cobra"
+)
+
+// runSummary collects what a backup run produced, for notifications and the
+// result file
+type runSummary struct {
+	archive      string
+	bytes        int64
+	destinations []notify.Destination
+	// skipped is set when the archive leaves out files it could not read
+	skipped bool
+}
+
+// withNotifications wraps the backup command so the notification hooks and
+// result file in opts run after it, whether it succeeded or not. Preview,
+// listing and destination check runs do not notify.
+func withNotifications(run func(*cobra.Command, []string) error, opts *options, summary *runSummary) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		started := time.Now()
+		err := run(cmd, args)
+		if (opts.notifyURL == "" && opts.notifyCommand == "" && opts.resultFile == "") || opts.preview || opts.listExcluded || opts.checkDestination {
+			return err
+		}
+
+		result := notify.NewResult(strings.Join(opts.sources, ", "), started, err)
+		result.Archive = summary.archive
+		result.Bytes = summary.bytes
+		result.Destinations = summary.destinations
+		if opts.resultFile != "" {
+			if err := notify.WriteFile(opts.resultFile, result); err != nil {
+				logging.GetSugar().Warnf("Failed to write result file: %v", err)
+			} else {
+				logging.GetSugar().Infof("Wrote run result to %s", opts.resultFile)
+			}
+		}
+		sendNotifications(*opts, result)
+		return err
+	}
+}
+
+// withHealthcheck wraps the backup command so the healthcheck in opts is
+// pinged when it starts and again with its outcome. Preview, listing and
+// destination check runs are not reported.
+func withHealthcheck(run func(*cobra.Command, []string) error, opts *options) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if opts.healthcheckURL == "" || opts.preview || opts.listExcluded || opts.checkDestination {
+			return run(cmd, args)
+		}
+		sugar := logging.GetSugar()
+
+		if err := notify.PingStart(opts.healthcheckURL); err != nil {
+			sugar.Warnf("Failed to ping healthcheck start: %v", err)
+		}
+		err := run(cmd, args)
+		if pingErr := notify.PingResult(opts.healthcheckURL, err); pingErr != nil {
+			sugar.Warnf("Failed to ping healthcheck: %v", pingErr)
+		} else {
+			sugar.Debugf("Pinged healthcheck with the outcome of the run")
+		}
+		return err
+	}
+}
+
+// checkHTTPURL rejects a value of flag that is neither empty nor an http or
+// https URL
+func checkHTTPURL(flag, value string) error {
+	if value == "" {
+		return nil
+	}
+	if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --%s %q: must be an http or https URL", flag, value)
+	}
+	return nil
+}
+
+// sendNotifications reports result to the webhook and command in opts. A
+// failed notification is logged without failing the run.
+func sendNotifications(opts options, result notify.Result) {
+	sugar := logging.GetSugar()
+
+	if opts.notifyURL != "" {
+		if err := notify.Webhook(opts.notifyURL, result); err != nil {
+			sugar.Warnf("Failed to send notification: %v", err)
+		} else {
+			sugar.Infof("Sent %s notification to %s", result.Status, opts.notifyURL)
+		}
+	}
+	if opts.notifyCommand != "" {
+		if err := notify.Command(opts.notifyCommand, result); err != nil {
+			sugar.Warnf("Failed to run notification command: %v", err)
+		} else {
+			sugar.Debugf("Ran notification command for %s run", result.Status)
+		}
+	}
+}
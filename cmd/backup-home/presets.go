@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"backup-home/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+// newPresetsCmd creates the command that prints built-in and config-defined exclude presets
+func newPresetsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "presets [name...]",
+		Short: "Show exclude presets for this platform",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadDefault()
+			if err != nil {
+				return err
+			}
+
+			names := args
+			if len(names) == 0 {
+				names = cfg.PresetNames()
+			}
+
+			for i, name := range names {
+				patterns, err := cfg.PresetPatterns(name)
+				if err != nil {
+					return err
+				}
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("%s:\n", name)
+				for _, pattern := range patterns {
+					fmt.Printf("  %s\n", pattern)
+				}
+			}
+			return nil
+		},
+	}
+}
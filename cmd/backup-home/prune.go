@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"backup-home/internal/logging"
+	"backup-home/internal/retention"
+	"backup-home/internal/upload"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/cobra"
+)
+
+// newPruneCmd creates the command that deletes old dated backups on the
+// remote according to a grandfather-father-son retention policy
+func newPruneCmd() *cobra.Command {
+	var opts options
+	var policy retention.Policy
+	var dryRun bool
+	var keepWithin fs.Duration
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete old dated backups on the remote, keeping daily, weekly, monthly and yearly ones",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := logging.InitLogger(opts.verbose); err != nil {
+				return fmt.Errorf("failed to initialize logger: %w", err)
+			}
+			defer logging.SyncLogger()
+
+			policy.Within = time.Duration(keepWithin)
+			if err := policy.Validate(); err != nil {
+				return err
+			}
+			if err := opts.loadCredentials(); err != nil {
+				return err
+			}
+			if err := opts.addS3Destination(); err != nil {
+				return err
+			}
+			if err := opts.parseRcloneFlags(); err != nil {
+				return err
+			}
+
+			// SSH is the default remote, as for uploads
+			if len(opts.rclone) == 0 {
+				opts.useSSH = true
+			}
+			return pruneDestinations(opts, policy, dryRun)
+		},
+	}
+
+	addRemoteFlags(cmd, &opts)
+	cmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "Enable verbose output")
+	cmd.Flags().IntVar(&policy.Daily, "keep-daily", 7, "Number of most recent days to keep the newest backup of")
+	cmd.Flags().IntVar(&policy.Weekly, "keep-weekly", 4, "Number of most recent weeks to keep the newest backup of")
+	cmd.Flags().IntVar(&policy.Monthly, "keep-monthly", 12, "Number of most recent months to keep the newest backup of")
+	cmd.Flags().IntVar(&policy.Yearly, "keep-yearly", 0, "Number of most recent years to keep the newest backup of")
+	cmd.Flags().IntVar(&policy.Last, "keep-last", 0, "Also keep this many of the newest backups")
+	cmd.Flags().Var(&keepWithin, "keep-within", "Also keep every backup newer than this (e.g. 7d, 2w)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be deleted without deleting anything")
+
+	return cmd
+}
+
+// pruneDestinations applies the retention policy on every destination in opts
+func pruneDestinations(opts options, policy retention.Policy, dryRun bool) error {
+	for _, dest := range opts.destinations() {
+		if dest.ssh {
+			for _, host := range dest.hosts {
+				config := opts.sshConfig()
+				config.Host = host
+				dirs, err := upload.NewSSHBackupDirs(config)
+				if err != nil {
+					return err
+				}
+				err = pruneBackupDirs(dirs, policy, opts.dateFormat, dryRun)
+				dirs.Close()
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		dirs, err := upload.NewRcloneBackupDirs(opts.rcloneConfig(dest.rclone))
+		if err != nil {
+			return err
+		}
+		err = pruneBackupDirs(dirs, policy, opts.dateFormat, dryRun)
+		dirs.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneBackupDirs applies the retention policy to the dated backup directories
+// in dirs, deleting those it does not keep unless dryRun is set
+func pruneBackupDirs(dirs upload.BackupDirs, policy retention.Policy, dateFormat string, dryRun bool) error {
+	sugar := logging.GetSugar()
+
+	names, err := dirs.List()
+	if err != nil {
+		return err
+	}
+
+	backups, unmatched := retention.ParseBackups(names, dateFormat)
+	for _, name := range unmatched {
+		sugar.Debugf("Ignoring directory not matching date format %q: %s", dateFormat, name)
+	}
+
+	keep, remove := policy.Apply(backups)
+	sugar.Infof("Pruning %s: %d backups, keeping %d, removing %d", dirs.Location(), len(backups), len(keep), len(remove))
+	for _, backup := range keep {
+		sugar.Infof("Keep:   %s", backup.Name)
+	}
+
+	for _, backup := range remove {
+		if dryRun {
+			sugar.Infof("Would remove: %s", backup.Name)
+			continue
+		}
+		sugar.Infof("Remove: %s", backup.Name)
+		if err := dirs.Remove(backup.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
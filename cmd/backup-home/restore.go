@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"backup-home/internal/backup"
+	"backup-home/internal/crypt"
+	"backup-home/internal/logging"
+	"backup-home/internal/platform"
+	"backup-home/internal/upload"
+
+	"github.com/spf13/cobra"
+)
+
+// newRestoreCmd creates the command that downloads a backup archive from the
+// remote and extracts it
+func newRestoreCmd() *cobra.Command {
+	var opts options
+	var target, passphrase string
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "restore <remote-archive>",
+		Short: "Download a backup archive from the remote and extract it",
+		Long: `Download a backup archive from the remote and extract it into --target.
+
+The archive path is relative to --ssh-remote-path for SSH (unless absolute)
+or to the --rclone destination, e.g. host/Users/2024-01-31/user.tar.gz.
+A split archive is restored by giving its .parts manifest.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := logging.InitLogger(opts.verbose); err != nil {
+				return fmt.Errorf("failed to initialize logger: %w", err)
+			}
+			defer logging.SyncLogger()
+			sugar := logging.GetSugar()
+
+			remotePath := args[0]
+			if target == "" && !dryRun {
+				return fmt.Errorf("--target is required unless --dry-run is given")
+			}
+			if err := opts.loadCredentials(); err != nil {
+				return err
+			}
+			if err := opts.addS3Destination(); err != nil {
+				return err
+			}
+			if len(opts.rclone) > 1 {
+				return fmt.Errorf("restore downloads from a single --rclone destination")
+			}
+			if err := opts.parseRcloneFlags(); err != nil {
+				return err
+			}
+			if passphrase == "" {
+				passphrase = os.Getenv(crypt.PassphraseEnv)
+			}
+
+			parentDir, err := platform.GetTempDir()
+			if err != nil {
+				return err
+			}
+			tempDir, err := os.MkdirTemp(parentDir, "backup-home-restore-")
+			if err != nil {
+				return fmt.Errorf("failed to create temp directory: %w", err)
+			}
+			defer os.RemoveAll(tempDir)
+			localPath := filepath.Join(tempDir, path.Base(remotePath))
+			if err := download(remotePath, localPath, opts); err != nil {
+				return fmt.Errorf("failed to download backup: %w", err)
+			}
+
+			// A split archive is given by its manifest, and its parts are
+			// downloaded next to it
+			if strings.HasSuffix(localPath, backup.ManifestExtension) {
+				parts, err := backup.ManifestParts(localPath)
+				if err != nil {
+					return err
+				}
+				for _, part := range parts {
+					if err := download(path.Join(path.Dir(remotePath), part), filepath.Join(tempDir, part), opts); err != nil {
+						return fmt.Errorf("failed to download archive part: %w", err)
+					}
+				}
+				localPath = strings.TrimSuffix(localPath, backup.ManifestExtension)
+			}
+
+			stats, err := backup.RestoreArchive(localPath, backup.RestoreOptions{
+				Target:     target,
+				List:       dryRun,
+				Passphrase: passphrase,
+				Entry: func(entry backup.ArchiveEntry) {
+					if dryRun {
+						fmt.Printf("%s %12d %s %s\n", entry.Mode, entry.Size, entry.ModTime.Format("2006-01-02 15:04"), entry.Name)
+					} else {
+						sugar.Debugf("Restoring: %s", entry.Name)
+					}
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to restore backup: %w", err)
+			}
+
+			sizeMB := float64(stats.Bytes) / 1024 / 1024
+			if dryRun {
+				sugar.Infof("Archive holds %d entries (%.2f MB); nothing was written", stats.Entries, sizeMB)
+			} else {
+				sugar.Infof("Restored %d entries (%.2f MB) to %s", stats.Entries, sizeMB, target)
+			}
+			return nil
+		},
+	}
+
+	addRemoteFlags(cmd, &opts)
+	cmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "Enable verbose output")
+	cmd.Flags().StringVarP(&target, "target", "t", "", "Directory to extract the archive into (created if missing)")
+	cmd.Flags().StringVar(&passphrase, "passphrase", "", "Passphrase of an encrypted archive (defaults to $"+crypt.PassphraseEnv+")")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Download the archive and list its contents without extracting anything")
+
+	return cmd
+}
+
+// download copies one file from the remote selected by opts to localPath
+func download(remotePath, localPath string, opts options) error {
+	// SSH is the default remote, as for uploads
+	if opts.useSSH || len(opts.rclone) == 0 {
+		return upload.DownloadFromSSH(remotePath, localPath, opts.sshConfig())
+	}
+	return upload.DownloadFromRclone(remotePath, localPath, opts.rcloneConfig(opts.rclone[0]))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"backup-home/internal/upload"
+
+	"golang.org/x/term"
+)
+
+// keyPassphrases caches the passphrases typed for encrypted SSH keys, so
+// retries and several hosts do not prompt again
+var keyPassphrases = struct {
+	sync.Mutex
+	byKey map[string]string
+}{byKey: make(map[string]string)}
+
+// promptKeyPassphrase asks for the passphrase of the encrypted SSH key
+// keyFile on the terminal without echoing it
+func promptKeyPassphrase(keyFile string) (string, error) {
+	keyPassphrases.Lock()
+	defer keyPassphrases.Unlock()
+	if passphrase, ok := keyPassphrases.byKey[keyFile]; ok {
+		return passphrase, nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("not a terminal: use --ssh-key-passphrase or $%s", upload.KeyPassphraseEnv)
+	}
+	passphrase, err := promptSecret(fmt.Sprintf("Enter passphrase for key %s: ", keyFile))
+	if err != nil {
+		return "", err
+	}
+	keyPassphrases.byKey[keyFile] = passphrase
+	return passphrase, nil
+}
+
+// promptSecret prints prompt to stderr and reads a line from the terminal on
+// stdin without echoing it
+func promptSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
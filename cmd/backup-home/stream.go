@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"backup-home/internal/backup"
+	"backup-home/internal/checksum"
+	"backup-home/internal/logging"
+	"backup-home/internal/upload"
+)
+
+// streamBackup pipes the archive straight into the selected uploader so no
+// local temp file is created
+func streamBackup(ctx context.Context, opts options, backupOpts backup.Options) error {
+	sugar := logging.GetSugar()
+
+	fileName, err := backup.DefaultArchiveName(opts.format, opts.encrypt)
+	if err != nil {
+		return err
+	}
+	if opts.backupPath != "" {
+		fileName = filepath.Base(opts.backupPath)
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	archiveErr := make(chan error, 1)
+	go func() {
+		err := backup.StreamBackup(ctx, pipeWriter, backupOpts)
+		pipeWriter.CloseWithError(err)
+		archiveErr <- err
+	}()
+
+	// Hash the archive as it streams past so the checksum file needs no second read
+	var reader io.Reader = pipeReader
+	var hasher hash.Hash
+	if opts.checksumAlgo != "" {
+		hasher, err = checksum.New(opts.checksumAlgo)
+		if err != nil {
+			return err
+		}
+		reader = io.TeeReader(pipeReader, hasher)
+	}
+
+	uploadErr := streamUpload(ctx, reader, fileName, opts)
+	// Unblock the archiver if the upload stopped reading early
+	pipeReader.CloseWithError(uploadErr)
+
+	if err := <-archiveErr; err != nil {
+		// A failed upload stops the archiver too, with the upload's error
+		code := exitBackupFailed
+		if uploadErr != nil && errors.Is(err, uploadErr) {
+			code = exitUploadFailed
+		}
+		return withExitCode(code, fmt.Errorf("failed to create backup: %w", err))
+	}
+	if uploadErr != nil {
+		return withExitCode(exitUploadFailed, fmt.Errorf("failed to upload backup: %w", uploadErr))
+	}
+
+	if hasher != nil {
+		line := checksum.SidecarLine(hex.EncodeToString(hasher.Sum(nil)), fileName)
+		sidecarName := checksum.SidecarPath(fileName, opts.checksumAlgo)
+		if err := streamUpload(ctx, strings.NewReader(line), sidecarName, opts); err != nil {
+			return withExitCode(exitUploadFailed, fmt.Errorf("failed to upload checksum file: %w", err))
+		}
+	}
+
+	sugar.Infof("Successfully streamed backup to remote")
+	return nil
+}
+
+// streamUpload uploads data read from r as fileName with the selected upload mode
+func streamUpload(ctx context.Context, r io.Reader, fileName string, opts options) error {
+	if opts.useSSH {
+		return upload.StreamToSSH(ctx, r, fileName, opts.sshConfig(), opts.verbose)
+	}
+	return upload.StreamToRclone(ctx, r, fileName, opts.rcloneConfig(opts.rclone[0]), opts.verbose)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"backup-home/internal/backup"
+	"backup-home/internal/logging"
+	"backup-home/internal/notify"
+	"backup-home/internal/upload"
+)
+
+// syncDestinations mirrors the source file by file to every rclone
+// destination instead of creating and uploading an archive, recording each
+// in summary
+func syncDestinations(ctx context.Context, opts options, backupOpts backup.Options, summary *runSummary) error {
+	sugar := logging.GetSugar()
+
+	filter := upload.SyncFilter{
+		Rules:      backup.SyncFilterRules(backupOpts),
+		MinSize:    backupOpts.MinFileSize,
+		MaxSize:    backupOpts.MaxFileSize,
+		IgnoreCase: runtime.GOOS == "windows",
+	}
+	sugar.Debugf("Rclone filter rules: %s", strings.Join(filter.Rules, ", "))
+
+	var failed []string
+	for _, remote := range opts.rclone {
+		startTime := time.Now()
+		err := upload.Retry(ctx, opts.uploadRetries, func() error {
+			return upload.SyncToRclone(ctx, backupOpts.Source, opts.rcloneConfig(remote), filter, opts.verbose)
+		})
+		// rclone does not report how much a sync transferred
+		summary.destinations = append(summary.destinations, notify.NewDestination(remote, 0, time.Since(startTime), err))
+		if err != nil {
+			sugar.Errorf("Sync to %s failed: %v", remote, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", remote, err))
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("sync canceled: %w", ctx.Err())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to sync to %d of %d destinations: %s", len(failed), len(opts.rclone), strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"backup-home/internal/checksum"
+	"backup-home/internal/logging"
+	"backup-home/internal/retention"
+	"backup-home/internal/upload"
+)
+
+// unchangedOn reports whether the newest dated backup on every host of dest
+// holds an archive with the same name and checksum as archivePath, whose
+// checksum file has been written. A destination that cannot be checked is
+// uploaded to.
+func unchangedOn(dest destination, archivePath string, opts options) bool {
+	sugar := logging.GetSugar()
+
+	sum, _, err := checksum.ReadSidecar(checksum.SidecarPath(archivePath, opts.checksumAlgo))
+	if err != nil {
+		sugar.Infof("Could not read the archive checksum, uploading: %v", err)
+		return false
+	}
+	name := filepath.Base(archivePath)
+
+	var all []upload.BackupDirs
+	if dest.ssh {
+		for _, host := range dest.hosts {
+			config := opts.sshConfig()
+			config.Host = host
+			dirs, err := upload.NewSSHBackupDirs(config)
+			if err != nil {
+				sugar.Infof("Could not check %s for an identical backup, uploading: %v", host, err)
+				return false
+			}
+			defer dirs.Close()
+			all = append(all, dirs)
+		}
+	} else {
+		dirs, err := upload.NewRcloneBackupDirs(opts.rcloneConfig(dest.rclone))
+		if err != nil {
+			sugar.Infof("Could not check %s for an identical backup, uploading: %v", dest, err)
+			return false
+		}
+		defer dirs.Close()
+		all = append(all, dirs)
+	}
+
+	for _, dirs := range all {
+		newest, remoteSum, err := newestChecksum(dirs, name, opts)
+		if err != nil {
+			sugar.Infof("Could not check %s for an identical backup, uploading: %v", dirs.Location(), err)
+			return false
+		}
+		if remoteSum == "" {
+			sugar.Debugf("No checksum of %s found in the newest backup on %s", name, dirs.Location())
+			return false
+		}
+		if !strings.EqualFold(remoteSum, sum) {
+			sugar.Debugf("The newest backup on %s (%s) differs from the new archive", dirs.Location(), newest)
+			return false
+		}
+		sugar.Infof("The newest backup on %s (%s) is identical to the new archive (%s %s)", dirs.Location(), newest, opts.checksumAlgo, sum)
+	}
+	return true
+}
+
+// newestChecksum returns the name of the newest dated backup directory in
+// dirs and the checksum of the archive name in it, "" if there is none
+func newestChecksum(dirs upload.BackupDirs, name string, opts options) (string, string, error) {
+	names, err := dirs.List()
+	if err != nil {
+		return "", "", err
+	}
+	backups, _ := retention.ParseBackups(names, opts.dateFormat)
+	if len(backups) == 0 {
+		return "", "", nil
+	}
+	newest := backups[0]
+	for _, backup := range backups[1:] {
+		if backup.Time.After(newest.Time) {
+			newest = backup
+		}
+	}
+
+	sum, err := dirs.Checksum(newest.Name, name, opts.checksumAlgo)
+	return newest.Name, sum, err
+}
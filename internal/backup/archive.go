@@ -1,22 +1,303 @@
 package backup
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
+	"os"
 	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"backup-home/internal/checksum"
+	"backup-home/internal/crypt"
 )
 
 const defaultCompressionLevel = 6
 
-// createArchive delegates to the appropriate platform-specific implementation
-func createArchive(source, backupPath string, compressionLevel int, verbose bool, ignoreExcludes bool, skipOnError bool) error {
+// StoreCompressionLevel stores file contents without compressing them, which
+// is fastest for sources that are mostly already compressed media
+const StoreCompressionLevel = 0
+
+// CheckCompressionLevel rejects a compression level outside 0-9
+func CheckCompressionLevel(level int) error {
+	if level < StoreCompressionLevel || level > 9 {
+		return fmt.Errorf("invalid compression level %d: must be 0 (store without compression) to 9 (smallest)", level)
+	}
+	return nil
+}
+
+// Archive formats, named after their file extension
+const (
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+	FormatTarXz  = "tar.xz"
+	FormatTarBz2 = "tar.bz2"
+	FormatZip    = "zip"
+)
+
+// defaultArchiveMode keeps the archive readable by its owner only
+const defaultArchiveMode os.FileMode = 0600
+
+// archiveStats summarizes what an archiver wrote
+type archiveStats struct {
+	// Entries is the number of entries written to the archive
+	Entries int
+	// RegularFiles is the number of those entries that are regular files
+	RegularFiles int
+	// Bytes is the uncompressed size of file content read from the source
+	Bytes int64
+	// Files records each archived file when Options.CheckChanges is set
+	Files []fileRecord
+	// Contents records every archived entry when Options.Manifest is set
+	Contents []ContentsEntry
+	// Skipped records every source path left out because of an error
+	Skipped []SkippedFile
+	// Excluded totals what each exclude pattern left out when Options.Verbose
+	// is set
+	Excluded map[string]excludedTotal
+}
+
+// incompleteSuffix is appended to the archive name while it is written
+const incompleteSuffix = ".tmp"
+
+// incompletePath returns where the archive for backupPath is written until it
+// is complete
+func incompletePath(backupPath string) string {
+	return backupPath + incompleteSuffix
+}
+
+// createArchive writes the archive to a new file at backupPath, or to
+// numbered parts of it when opts.SplitSize is set. A single archive file is
+// written under its incomplete path and only renamed to backupPath once it is
+// finished, and a split archive is only complete once its manifest is
+// written, so a failed or killed run never leaves something a later run would
+// reuse as a finished backup.
+func createArchive(ctx context.Context, backupPath string, opts Options) (archiveStats, error) {
+	var output io.WriteCloser
+	var split *splitWriter
+	if opts.SplitSize > 0 {
+		split = newSplitWriter(backupPath, opts.SplitSize, opts.ArchiveMode, opts.ChecksumAlgo)
+		output = split
+	} else {
+		outFile, err := createOutputFile(incompletePath(backupPath), opts.ArchiveMode)
+		if err != nil {
+			return archiveStats{}, fmt.Errorf("failed to create output file: %w", err)
+		}
+		output = outFile
+	}
+
+	var out io.Writer = output
+	if opts.WaitOnDiskFull > 0 {
+		out = &diskFullWriter{writer: output, timeout: opts.WaitOnDiskFull}
+	}
+	// A split archive hashes each part itself; a single file is hashed here,
+	// after any retries on a full disk so every byte is hashed once
+	var hasher hash.Hash
+	if opts.ChecksumAlgo != "" && split == nil {
+		hasher, _ = checksum.New(opts.ChecksumAlgo)
+		out = io.MultiWriter(out, hasher)
+	}
+	stats, err := writeArchive(ctx, out, opts)
+	if closeErr := output.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to finish archive: %w", closeErr)
+	}
+	if err == nil && opts.SplitSize == 0 && ctx.Err() == nil {
+		if renameErr := os.Rename(incompletePath(backupPath), backupPath); renameErr != nil {
+			err = fmt.Errorf("failed to finish archive: %w", renameErr)
+		}
+	}
+	if err != nil || ctx.Err() != nil || opts.ChecksumAlgo == "" {
+		return stats, err
+	}
+
+	if split != nil {
+		for _, part := range split.parts {
+			if err := writeChecksumFile(part.path, opts.ChecksumAlgo, part.sum); err != nil {
+				return stats, err
+			}
+		}
+		return stats, nil
+	}
+	return stats, writeChecksumFile(backupPath, opts.ChecksumAlgo, hex.EncodeToString(hasher.Sum(nil)))
+}
+
+// writeChecksumFile writes the checksum file of an archive hashed while it
+// was written
+func writeChecksumFile(archivePath, algo, sum string) error {
+	sidecarPath, err := checksum.WriteSidecarSum(archivePath, algo, sum)
+	if err != nil {
+		return err
+	}
+	sugar.Infof("Checksum file: %s", sidecarPath)
+	return nil
+}
+
+// closeArchiveWriters closes the writers of an archive in order, the tar or
+// zip writer before the compressor or buffer it writes its trailer into, and
+// returns the first error. An archive is only complete once all of them
+// wrote their final bytes.
+func closeArchiveWriters(closers ...func() error) error {
+	var err error
+	for _, closeWriter := range closers {
+		if closeErr := closeWriter(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// writeArchive writes the archive to out, encrypting it if requested. Once
+// ctx is canceled writes to out fail, stopping the archiver.
+func writeArchive(ctx context.Context, out io.Writer, opts Options) (archiveStats, error) {
+	out = &contextWriter{ctx: ctx, writer: out}
+	if !opts.Encrypt {
+		return writePlatformArchive(ctx, out, opts)
+	}
+
+	encrypter, err := crypt.NewWriter(out, opts.Passphrase)
+	if err != nil {
+		return archiveStats{}, fmt.Errorf("failed to create encrypted writer: %w", err)
+	}
+	stats, err := writePlatformArchive(ctx, encrypter, opts)
+	if closeErr := encrypter.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to finish encrypted archive: %w", closeErr)
+	}
+	return stats, err
+}
+
+// writePlatformArchive delegates to the zip archiver or the appropriate
+// platform-specific tar implementation
+func writePlatformArchive(ctx context.Context, out io.Writer, opts Options) (archiveStats, error) {
+	if opts.Format == FormatZip {
+		return createZipArchive(ctx, out, opts)
+	}
 	switch runtime.GOOS {
 	case "darwin":
-		return createMacOSArchive(source, backupPath, compressionLevel, verbose, ignoreExcludes, skipOnError)
-	case "linux":
-		return createLinuxArchive(source, backupPath, compressionLevel, verbose, ignoreExcludes, skipOnError)
+		return createMacOSArchive(ctx, out, opts)
+	case "linux", "freebsd":
+		// FreeBSD has the same tar semantics as Linux
+		return createLinuxArchive(ctx, out, opts)
 	case "windows":
-		return createWindowsArchive(source, backupPath, compressionLevel, verbose, ignoreExcludes, skipOnError)
+		return createWindowsTarArchive(ctx, out, opts)
 	default:
-		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+		return archiveStats{}, fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+// archiveExtension returns the file extension, including the leading dot, of
+// an archive in format
+func archiveExtension(format string, encrypted bool) string {
+	if encrypted {
+		return "." + format + crypt.Extension
+	}
+	return "." + format
+}
+
+// defaultFormat returns the archive format used when none is requested
+func defaultFormat() string {
+	if runtime.GOOS == "windows" {
+		return FormatZip
+	}
+	return FormatTarGz
+}
+
+// resolveFormat validates the requested archive format, falling back to the
+// platform default when none is given
+func resolveFormat(format string) (string, error) {
+	switch {
+	case format == "":
+		return defaultFormat(), nil
+	case isTarFormat(format), format == FormatZip:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format: %s (supported: %s)", format, strings.Join(Formats(), ", "))
+	}
+}
+
+// Formats returns the supported archive formats
+func Formats() []string {
+	formats := make([]string, 0, len(tarCodecs)+1)
+	for format := range tarCodecs {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return append(formats, FormatZip)
+}
+
+// createOutputFile creates the archive file with the given permissions,
+// independent of the process umask
+func createOutputFile(path string, mode os.FileMode) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to set archive permissions: %w", err)
+	}
+	return file, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	atomic.AddInt64(&w.count, int64(n))
+	return n, err
+}
+
+// Count returns the number of bytes written so far
+func (w *countingWriter) Count() int64 {
+	return atomic.LoadInt64(&w.count)
+}
+
+// contextWriter fails every write with ctx's error once ctx is canceled
+type contextWriter struct {
+	ctx    context.Context
+	writer io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(p)
+}
+
+// logArchiveSummary reports, the same way for every archiver, how many files
+// went into the archive and were skipped, how long archiving took and how
+// much the source content shrank in the archive
+func logArchiveSummary(stats archiveStats, archiveBytes int64, elapsed time.Duration) {
+	sugar.Infof("Archived %d files (%d entries) in %s (%.2f MB/s), %d skipped because of errors",
+		stats.RegularFiles, stats.Entries, elapsed.Round(time.Second),
+		float64(archiveBytes)/1024/1024/elapsed.Seconds(), len(stats.Skipped))
+
+	sourceMB := float64(stats.Bytes) / 1024 / 1024
+	archiveMB := float64(archiveBytes) / 1024 / 1024
+	if stats.Bytes == 0 || archiveBytes == 0 {
+		sugar.Infof("Compression: %.2f MB source -> %.2f MB archive", sourceMB, archiveMB)
+		return
+	}
+
+	ratio := compressionRatio(stats.Bytes, archiveBytes)
+	saved := 100 - float64(archiveBytes)*100/float64(stats.Bytes)
+	sugar.Infof("Compression: %.2f MB source -> %.2f MB archive (ratio %.2f:1, %.1f%% saved)", sourceMB, archiveMB, ratio, saved)
+}
+
+// compressionRatio is how many times larger the source content is than the
+// archive, or zero when either size is unknown
+func compressionRatio(sourceBytes, archiveBytes int64) float64 {
+	if sourceBytes == 0 || archiveBytes == 0 {
+		return 0
 	}
+	return float64(sourceBytes) / float64(archiveBytes)
 }
@@ -1,12 +1,19 @@
 package backup
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
+	"backup-home/internal/checksum"
 	"backup-home/internal/logging"
+	"backup-home/internal/platform"
+	"backup-home/internal/progress"
 
 	"github.com/mitchellh/go-homedir"
 	"go.uber.org/zap"
@@ -15,56 +22,402 @@ import (
 // Initialize sugar variable at package level for convenience
 var sugar *zap.SugaredLogger
 
-// CreateBackup creates a backup of the specified source directory
-func CreateBackup(source string, backupPath string, compressionLevel int, verbose bool, ignoreExcludes bool, skipOnError bool) (string, error) {
+// Options holds the settings for a single backup run
+type Options struct {
+	Source           string
+	BackupPath       string
+	CompressionLevel int
+	Verbose          bool
+	IgnoreExcludes   bool
+	SkipOnError      bool
+	VerifyArchive    bool
+	// Sources lists several directories to archive instead of Source, each
+	// under a top-level directory named after it
+	Sources []string
+	// Format is the archive format (tar.gz or zip); empty means the platform default
+	Format string
+	// AllowPartial keeps an archive that failed midway so it can still be uploaded
+	AllowPartial bool
+	// ArchiveMode is the permission mode of the created archive (default 0600)
+	ArchiveMode os.FileMode
+	// WaitOnDiskFull is how long to keep retrying writes when the output disk
+	// is full before failing; zero fails immediately
+	WaitOnDiskFull time.Duration
+	// CheckChanges re-stats archived files afterwards and reports those that
+	// changed while the backup was running
+	CheckChanges bool
+	// Encrypt encrypts the archive with AES-256-GCM using a key derived from
+	// Passphrase, adding .enc to the file name
+	Encrypt    bool
+	Passphrase string
+	// BaseExcludes replaces the built-in platform patterns when not nil
+	BaseExcludes []string
+	// Excludes are extra patterns (e.g. from presets) added to the platform defaults
+	Excludes []string
+	// Includes are patterns re-including paths the excludes leave out,
+	// including paths inside excluded directories
+	Includes []string
+	// Since makes the backup incremental: files not modified after it are
+	// left out, while directories are still recorded. Zero means a full backup.
+	Since time.Time
+	// Manifest writes a JSON manifest of every archived entry next to the
+	// archive, named after it plus ContentsExtension
+	Manifest bool
+	// Concurrency caps the goroutines reading files and compressing; zero
+	// uses one per CPU
+	Concurrency int
+	// UseIgnoreFiles applies the patterns of a .backupignore file in any
+	// directory of the source to that directory's subtree
+	UseIgnoreFiles bool
+	// GitDirtyOnly archives only the files git reports as modified or
+	// untracked in the repositories found in the source; the rest of each
+	// repository is left out
+	GitDirtyOnly bool
+	// FollowSymlinks archives the targets of symlinks, descending into
+	// symlinked directories, instead of storing the links
+	FollowSymlinks bool
+	// MaxFileSize and MinFileSize leave out regular files larger or smaller
+	// than this many bytes; zero disables the limit
+	MaxFileSize int64
+	MinFileSize int64
+	// NoSpaceCheck skips comparing the estimated source size with the free
+	// space at the backup path before archiving
+	NoSpaceCheck bool
+	// LowSpaceWarning only warns when the free space check fails
+	LowSpaceWarning bool
+	// SkippedList writes the paths skipped because of errors next to the
+	// archive, named after it plus SkippedExtension
+	SkippedList bool
+	// OnSkipped, when set, is called once the archive is written if it leaves
+	// source files out: with the files skipped because of errors, or for a
+	// partial archive kept by AllowPartial, with those skipped before it failed
+	OnSkipped func(skipped []SkippedFile)
+	// PreserveXattrs stores extended attributes in PAX headers of tar
+	// archives; on Linux only the user.* namespace is kept
+	PreserveXattrs bool
+	// TempDir is where the archive is written, under its default name, when
+	// BackupPath is empty; empty uses platform.GetTempDir
+	TempDir string
+	// SplitSize writes the archive as numbered parts of at most this many
+	// bytes plus a manifest, instead of a single file; zero disables splitting
+	SplitSize int64
+	// Reproducible writes byte-identical archives for unchanged content:
+	// entry times are fixed and owner IDs and names left out
+	Reproducible bool
+	// FilesFrom archives only these paths, relative to Source, instead of
+	// walking all of it; a listed directory is archived with its contents.
+	// Listed paths are archived even when an exclude pattern matches them,
+	// while the contents of listed directories are still filtered.
+	FilesFrom []string
+	// ChecksumAlgo writes a checksum file with this algorithm next to the
+	// archive, or each part of a split archive, hashing the output while it
+	// is written rather than reading the archive again afterwards
+	ChecksumAlgo string
+
+	// rootIgnores maps each source to the patterns of the ignore file at its
+	// root, read by prepareOptions
+	rootIgnores map[string]rootIgnore
+}
+
+// sourceDirs returns the directories archived: Sources, or else Source
+func (o Options) sourceDirs() []string {
+	if len(o.Sources) > 0 {
+		return o.Sources
+	}
+	return []string{o.Source}
+}
+
+// workers returns how many goroutines read and compress in parallel
+func (o Options) workers() int {
+	if o.Concurrency > 0 {
+		return o.Concurrency
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// prepareOptions initializes logging, validates the source and fills in defaults
+func prepareOptions(opts Options) (Options, error) {
 	// Initialize logger
-	if err := logging.InitLogger(verbose); err != nil {
-		return "", fmt.Errorf("failed to initialize logger: %w", err)
+	if err := logging.InitLogger(opts.Verbose); err != nil {
+		return opts, fmt.Errorf("failed to initialize logger: %w", err)
 	}
-	defer logging.SyncLogger()
 
 	// Get the sugar reference for this package
 	sugar = logging.GetSugar()
 
-	if _, err := os.Stat(source); os.IsNotExist(err) {
-		return "", fmt.Errorf("source directory does not exist: %s", source)
+	if len(opts.Sources) == 1 {
+		opts.Source, opts.Sources = opts.Sources[0], nil
+	}
+	for _, source := range opts.sourceDirs() {
+		if _, err := os.Stat(source); os.IsNotExist(err) {
+			return opts, fmt.Errorf("source directory does not exist: %s", source)
+		}
+	}
+	names := make(map[string]string)
+	for _, source := range opts.Sources {
+		name := sourceName(source)
+		if name == "" {
+			return opts, fmt.Errorf("source %s has no name to archive it under next to other sources", source)
+		}
+		if other, ok := names[name]; ok {
+			return opts, fmt.Errorf("sources %s and %s would both be archived as %s", other, source, name)
+		}
+		names[name] = source
+	}
+
+	if len(opts.FilesFrom) > 0 {
+		if len(opts.Sources) > 0 {
+			return opts, fmt.Errorf("a file list cannot be combined with several sources")
+		}
+		var err error
+		if opts.FilesFrom, err = resolveFileList(opts.Source, opts.FilesFrom); err != nil {
+			return opts, err
+		}
+	}
+
+	if err := CheckCompressionLevel(opts.CompressionLevel); err != nil {
+		return opts, err
+	}
+
+	opts = withRootIgnores(opts)
+
+	if opts.ArchiveMode == 0 {
+		opts.ArchiveMode = defaultArchiveMode
+	}
+
+	format, err := resolveFormat(opts.Format)
+	if err != nil {
+		return opts, err
+	}
+	opts.Format = format
+
+	if opts.Encrypt && opts.Passphrase == "" {
+		return opts, fmt.Errorf("encryption requires a passphrase")
 	}
 
-	if compressionLevel < 0 || compressionLevel > 9 {
-		compressionLevel = defaultCompressionLevel
+	if opts.SplitSize > 0 && opts.AllowPartial {
+		return opts, fmt.Errorf("partial archives cannot be kept when splitting the archive")
+	}
+
+	if opts.ChecksumAlgo != "" {
+		if _, err := checksum.New(opts.ChecksumAlgo); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+// DefaultArchiveName returns the archive file name used when no backup path is
+// given. An empty format means the platform default.
+func DefaultArchiveName(format string, encrypted bool) (string, error) {
+	format, err := resolveFormat(format)
+	if err != nil {
+		return "", err
+	}
+	username, err := getUsername()
+	if err != nil {
+		return "", fmt.Errorf("failed to get username: %w", err)
+	}
+	return username + archiveExtension(format, encrypted), nil
+}
+
+// CreateBackup creates a backup of the specified source directory. When ctx
+// is canceled the backup stops and the incomplete archive is removed.
+func CreateBackup(ctx context.Context, opts Options) (string, error) {
+	defer logging.SyncLogger()
+
+	opts, err := prepareOptions(opts)
+	if err != nil {
+		return "", err
 	}
 
 	// Use provided backup path or create default one
+	backupPath := opts.BackupPath
 	if backupPath == "" {
-		tempDir := os.TempDir()
-		username, err := getUsername()
+		archiveName, err := DefaultArchiveName(opts.Format, opts.Encrypt)
 		if err != nil {
-			return "", fmt.Errorf("failed to get username: %w", err)
+			return "", err
+		}
+		tempDir := opts.TempDir
+		if tempDir == "" {
+			tempDir, err = platform.GetTempDir()
+			if err != nil {
+				return "", err
+			}
 		}
-		backupPath = filepath.Join(tempDir, fmt.Sprintf("%s.%s", username, getArchiveExtension()))
+		backupPath = filepath.Join(tempDir, archiveName)
 	}
 
-	// Check if backup file already exists
-	if _, err := os.Stat(backupPath); err == nil {
+	// Check if backup file, or a split archive of it, already exists
+	if _, _, err := ArchiveParts(backupPath); err == nil {
 		sugar.Infof("Backup file already exists: %s", backupPath)
 		sugar.Infof("Skipping backup creation and using existing file")
 		return backupPath, nil
 	}
 
-	sugar.Infof("Creating backup of: %s", source)
+	sugar.Infof("Creating backup of: %s", strings.Join(opts.sourceDirs(), ", "))
 	sugar.Infof("Backup file: %s", backupPath)
-	sugar.Infof("Using compression level: %d", compressionLevel)
-	if ignoreExcludes {
+	sugar.Infof("Using compression level: %d", opts.CompressionLevel)
+	sugar.Infof("Archive format: %s", opts.Format)
+	if opts.Encrypt {
+		sugar.Infof("Encrypting archive with AES-256-GCM")
+	}
+	if opts.IgnoreExcludes {
 		sugar.Infof("Ignoring exclude patterns - backing up everything")
 	}
+	if !opts.Since.IsZero() {
+		sugar.Infof("Incremental backup: only files modified after %s", opts.Since.Format(time.RFC3339))
+	}
+	if opts.UseIgnoreFiles {
+		sugar.Infof("Applying %s files found in the source", IgnoreFileName)
+	}
+	if opts.GitDirtyOnly {
+		sugar.Infof("Only archiving uncommitted changes of git repositories (experimental)")
+	}
+
+	if !opts.NoSpaceCheck {
+		if err := checkFreeSpace(ctx, backupPath, opts); err != nil {
+			return "", err
+		}
+	}
 
-	if err := createArchive(source, backupPath, compressionLevel, verbose, ignoreExcludes, skipOnError); err != nil {
-		return "", fmt.Errorf("failed to create archive: %w", err)
+	archiveStart := time.Now()
+	stats, err := createArchive(ctx, backupPath, opts)
+	if ctx.Err() != nil {
+		removeIncompleteArchive(backupPath)
+		return "", fmt.Errorf("backup canceled: %w", ctx.Err())
+	}
+	if err != nil {
+		if !opts.AllowPartial || stats.Entries == 0 {
+			removeIncompleteArchive(backupPath)
+			return "", fmt.Errorf("failed to create archive: %w", err)
+		}
+		if opts.OnSkipped != nil {
+			opts.OnSkipped(stats.Skipped)
+		}
+		return keepPartialArchive(backupPath, archiveExtension(opts.Format, opts.Encrypt), stats, err)
+	}
+
+	var archiveBytes int64
+	if archive, err := openArchive(backupPath); err == nil {
+		archiveBytes = archive.Size()
+		logArchiveSummary(stats, archiveBytes, time.Since(archiveStart))
+		progress.Report(progress.TypeDone, progress.PhaseArchive, archiveBytes, 0, archiveStart)
+		archive.Close()
+	}
+
+	reportExcludedSizes(stats.Excluded)
+	if opts.CheckChanges {
+		reportChangedFiles(stats.Files)
+	}
+	if err := reportSkippedFiles(backupPath, opts, stats.Skipped); err != nil {
+		return "", err
+	}
+
+	if opts.Manifest {
+		manifestPath, err := writeContents(backupPath, opts, stats, archiveBytes)
+		if err != nil {
+			return "", err
+		}
+		sugar.Infof("Contents manifest: %s (%d entries)", manifestPath, len(stats.Contents))
+	}
+
+	if opts.VerifyArchive {
+		if err := verifyArchive(backupPath, opts, stats.Entries); err != nil {
+			return "", err
+		}
 	}
 
 	return backupPath, nil
 }
 
+// StreamBackup writes a backup archive of the source directory to w without
+// creating a local file. When ctx is canceled the archive is left unfinished.
+func StreamBackup(ctx context.Context, w io.Writer, opts Options) error {
+	defer logging.SyncLogger()
+
+	opts, err := prepareOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	sugar.Infof("Streaming backup of: %s", strings.Join(opts.sourceDirs(), ", "))
+	sugar.Infof("Using compression level: %d", opts.CompressionLevel)
+	sugar.Infof("Archive format: %s", opts.Format)
+	if opts.IgnoreExcludes {
+		sugar.Infof("Ignoring exclude patterns - backing up everything")
+	}
+	if !opts.Since.IsZero() {
+		sugar.Infof("Incremental backup: only files modified after %s", opts.Since.Format(time.RFC3339))
+	}
+	if opts.UseIgnoreFiles {
+		sugar.Infof("Applying %s files found in the source", IgnoreFileName)
+	}
+	if opts.GitDirtyOnly {
+		sugar.Infof("Only archiving uncommitted changes of git repositories (experimental)")
+	}
+
+	counter := &countingWriter{writer: w}
+	archiveStart := time.Now()
+	stats, err := writeArchive(ctx, counter, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	logArchiveSummary(stats, counter.Count(), time.Since(archiveStart))
+	progress.Report(progress.TypeDone, progress.PhaseArchive, counter.Count(), 0, archiveStart)
+	reportExcludedSizes(stats.Excluded)
+	if opts.CheckChanges {
+		reportChangedFiles(stats.Files)
+	}
+	return reportSkippedFiles("", opts, stats.Skipped)
+}
+
+// keepPartialArchive renames an archive whose creation failed midway so it is
+// clearly marked as incomplete, and returns the new path
+func keepPartialArchive(backupPath, ext string, stats archiveStats, archiveErr error) (string, error) {
+	partialPath := partialArchivePath(backupPath, ext)
+	if err := os.Rename(incompletePath(backupPath), partialPath); err != nil {
+		return "", fmt.Errorf("failed to create archive: %w (and failed to mark partial archive: %v)", archiveErr, err)
+	}
+
+	sugar.Warnf("!!! ARCHIVE IS INCOMPLETE !!! Archive creation failed: %v", archiveErr)
+	sugar.Warnf("!!! Continuing with partial archive (%d entries written): %s", stats.Entries, partialPath)
+	return partialPath, nil
+}
+
+// removeIncompleteArchive deletes what a canceled or failed backup wrote at
+// backupPath, the archive or the parts and manifest of a split archive, so a
+// later run does not mistake it for a finished backup
+func removeIncompleteArchive(backupPath string) {
+	paths := []string{incompletePath(backupPath), backupPath, backupPath + ManifestExtension}
+	for n := 1; ; n++ {
+		part := partPath(backupPath, n)
+		if _, err := os.Lstat(part); err != nil {
+			break
+		}
+		paths = append(paths, part)
+	}
+
+	for _, path := range paths {
+		err := os.Remove(path)
+		if err == nil {
+			sugar.Infof("Removed incomplete archive: %s", path)
+		} else if !os.IsNotExist(err) {
+			sugar.Warnf("Failed to remove incomplete archive %s: %v", path, err)
+		}
+	}
+}
+
+// partialArchivePath inserts a .partial marker before the archive extension
+func partialArchivePath(backupPath, ext string) string {
+	if strings.HasSuffix(backupPath, ext) {
+		return strings.TrimSuffix(backupPath, ext) + ".partial" + ext
+	}
+	return backupPath + ".partial"
+}
+
 func getUsername() (string, error) {
 	username := os.Getenv("USER")
 	if username == "" {
@@ -76,10 +429,3 @@ func getUsername() (string, error) {
 	}
 	return username, nil
 }
-
-func getArchiveExtension() string {
-	if runtime.GOOS == "windows" {
-		return "zip"
-	}
-	return "tar.gz"
-}
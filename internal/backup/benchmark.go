@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// benchmarkTolerance is how much larger than the smallest output the
+// recommended setting may compress to, in exchange for being faster
+const benchmarkTolerance = 1.02
+
+// BenchmarkResult is the outcome of compressing the sample with one format
+// and level
+type BenchmarkResult struct {
+	Format   string
+	Level    int
+	Size     int64
+	Duration time.Duration
+}
+
+// CompressionBenchmark holds the results of BenchmarkCompression
+type CompressionBenchmark struct {
+	// SampleSize is the number of source bytes compressed per result
+	SampleSize int64
+	Results    []BenchmarkResult
+}
+
+// BenchmarkCompression compresses a sample of up to sampleSize bytes of the
+// source, taken from the beginning of the included files as for
+// ChooseBestFormat, with every combination of formats and levels, using the
+// compressors and worker count of a real backup
+func BenchmarkCompression(ctx context.Context, opts Options, formats []string, levels []int, sampleSize int) (CompressionBenchmark, error) {
+	var benchmark CompressionBenchmark
+	opts, err := prepareOptions(opts)
+	if err != nil {
+		return benchmark, err
+	}
+	for _, level := range levels {
+		if err := CheckCompressionLevel(level); err != nil {
+			return benchmark, err
+		}
+	}
+	for i, format := range formats {
+		if formats[i], err = resolveFormat(format); err != nil {
+			return benchmark, err
+		}
+	}
+
+	sample, err := collectSample(ctx, opts, sampleSize)
+	if err != nil {
+		return benchmark, err
+	}
+	benchmark.SampleSize = int64(len(sample))
+	if len(sample) == 0 {
+		return benchmark, nil
+	}
+	sugar.Infof("Compressing a %.2f MB sample of %s", float64(len(sample))/1024/1024, strings.Join(opts.sourceDirs(), ", "))
+
+	for _, format := range formats {
+		for _, level := range levels {
+			if err := ctx.Err(); err != nil {
+				return benchmark, err
+			}
+			counter := &countingWriter{writer: io.Discard}
+			compressor, err := newBenchmarkCompressor(counter, format, level, opts.workers())
+			if err != nil {
+				return benchmark, fmt.Errorf("failed to create %s writer: %w", format, err)
+			}
+			start := time.Now()
+			if _, err := compressor.Write(sample); err != nil {
+				compressor.Close()
+				return benchmark, fmt.Errorf("failed to compress sample with %s: %w", format, err)
+			}
+			if err := compressor.Close(); err != nil {
+				return benchmark, fmt.Errorf("failed to compress sample with %s: %w", format, err)
+			}
+			benchmark.Results = append(benchmark.Results, BenchmarkResult{
+				Format:   format,
+				Level:    level,
+				Size:     counter.Count(),
+				Duration: time.Since(start),
+			})
+		}
+	}
+	return benchmark, nil
+}
+
+// newBenchmarkCompressor returns the compressor a backup in format uses at
+// level; zip entries are deflated on their own, so one stream stands in
+// for them
+func newBenchmarkCompressor(w io.Writer, format string, level, workers int) (io.WriteCloser, error) {
+	if format == FormatZip {
+		return flate.NewWriter(w, level)
+	}
+	return tarCodecs[format].newWriter(w, level, workers)
+}
+
+// Recommended returns the fastest result whose output is at most
+// benchmarkTolerance times the smallest one, reporting false without results
+func (b CompressionBenchmark) Recommended() (BenchmarkResult, bool) {
+	if len(b.Results) == 0 {
+		return BenchmarkResult{}, false
+	}
+	smallest := b.Results[0].Size
+	for _, result := range b.Results {
+		smallest = min(smallest, result.Size)
+	}
+	var best BenchmarkResult
+	found := false
+	for _, result := range b.Results {
+		if float64(result.Size) > float64(smallest)*benchmarkTolerance {
+			continue
+		}
+		if !found || result.Duration < best.Duration {
+			best, found = result, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"backup-home/internal/logging"
+)
+
+const (
+	// bestSampleSize caps the amount of source data compressed per candidate
+	bestSampleSize = 16 * 1024 * 1024
+	// bestSamplePerFile caps how much of a single file goes into the sample
+	bestSamplePerFile = 256 * 1024
+)
+
+// ChooseBestFormat compresses a sample of the source with every tar format
+// and returns the format that produced the smallest output
+func ChooseBestFormat(ctx context.Context, opts Options) (string, error) {
+	if err := logging.InitLogger(opts.Verbose); err != nil {
+		return "", fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	sugar = logging.GetSugar()
+	if err := CheckCompressionLevel(opts.CompressionLevel); err != nil {
+		return "", err
+	}
+
+	sample, err := collectSample(ctx, opts, bestSampleSize)
+	if err != nil {
+		return "", err
+	}
+	if len(sample) == 0 {
+		sugar.Infof("No data to sample, using %s", FormatTarGz)
+		return FormatTarGz, nil
+	}
+
+	candidates := make([]string, 0, len(tarCodecs))
+	for format := range tarCodecs {
+		candidates = append(candidates, format)
+	}
+	sort.Strings(candidates)
+
+	best := ""
+	var bestSize int64
+	for _, format := range candidates {
+		counter := &countingWriter{writer: io.Discard}
+		compressor, err := tarCodecs[format].newWriter(counter, opts.CompressionLevel, opts.workers())
+		if err != nil {
+			return "", fmt.Errorf("failed to create %s writer: %w", format, err)
+		}
+		if _, err := compressor.Write(sample); err != nil {
+			compressor.Close()
+			return "", fmt.Errorf("failed to compress sample with %s: %w", format, err)
+		}
+		if err := compressor.Close(); err != nil {
+			return "", fmt.Errorf("failed to compress sample with %s: %w", format, err)
+		}
+
+		size := counter.Count()
+		sugar.Infof("Sample compressed with %s: %.2f MB -> %.2f MB", format,
+			float64(len(sample))/1024/1024, float64(size)/1024/1024)
+		if best == "" || size < bestSize {
+			best, bestSize = format, size
+		}
+	}
+
+	sugar.Infof("Best compression for this data: %s (%.1f%% of sample size)", best,
+		float64(bestSize)*100/float64(len(sample)))
+	return best, nil
+}
+
+// collectSample reads the beginning of included files until the sample holds
+// limit bytes
+func collectSample(ctx context.Context, opts Options, limit int) ([]byte, error) {
+	var sample bytes.Buffer
+	errSampleFull := fmt.Errorf("sample full")
+	err := forEachSource(opts, func(opts Options, _ string) error {
+		excludes := newExcludeMatcher(opts)
+		ignores := newIgnoreFiles(opts)
+		gitDirty := newGitDirty(opts)
+		return walkSource(ctx, opts, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			relPath, err := filepath.Rel(opts.Source, path)
+			if err != nil || relPath == "." {
+				return nil
+			}
+
+			_, excluded, descend := excludes.match(relPath, info.IsDir())
+			if descend {
+				return nil
+			}
+			if excluded || ignores.ignored(path, relPath, info.IsDir()) || gitDirty.skipped(path, relPath, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !info.Mode().IsRegular() || sizeExclusion(info, opts) != "" {
+				return nil
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				return nil
+			}
+			defer file.Close()
+
+			remaining := int64(limit - sample.Len())
+			if remaining > bestSamplePerFile {
+				remaining = bestSamplePerFile
+			}
+			if _, err := io.CopyN(&sample, file, remaining); err != nil && err != io.EOF {
+				sugar.Debugf("Failed to sample %s: %v", path, err)
+			}
+
+			if sample.Len() >= limit {
+				return errSampleFull
+			}
+			return nil
+		})
+	})
+	if err != nil && err != errSampleFull {
+		return nil, fmt.Errorf("failed to sample source: %w", err)
+	}
+
+	return sample.Bytes(), nil
+}
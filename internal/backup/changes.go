@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"os"
+	"time"
+)
+
+// fileRecord is the size and modification time of an archived file as it was
+// when the walk saw it
+type fileRecord struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// maxChangedListed caps how many changed files are named in the log
+const maxChangedListed = 20
+
+// reportChangedFiles re-stats the archived files and logs how many changed or
+// disappeared while the backup was running
+func reportChangedFiles(records []fileRecord) int {
+	changed := 0
+	for _, record := range records {
+		info, err := os.Stat(record.path)
+		if err == nil && info.Size() == record.size && info.ModTime().Equal(record.modTime) {
+			continue
+		}
+		if changed < maxChangedListed {
+			sugar.Warnf("Changed during backup: %s", record.path)
+		}
+		changed++
+	}
+
+	if changed > 0 {
+		sugar.Warnf("%d of %d files changed during backup; the archive may be inconsistent, consider retrying or using --snapshot", changed, len(records))
+	} else {
+		sugar.Infof("No files changed during backup (%d checked)", len(records))
+	}
+	return changed
+}
@@ -0,0 +1,203 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"backup-home/internal/crypt"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
+)
+
+// tarCodec creates the compression streams wrapped around a tar archive
+type tarCodec struct {
+	// magic is the signature at the start of a compressed stream
+	magic []byte
+	// newWriter returns a compressing writer for a 0-9 compression level,
+	// using up to workers goroutines where the codec compresses in parallel.
+	// Level 0 stores the data where the codec can, or compresses it as fast as
+	// the codec allows.
+	newWriter func(w io.Writer, level, workers int) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// tarCodecs maps tar-based archive formats to their compression codec
+var tarCodecs = map[string]tarCodec{
+	FormatTarGz:  {magic: []byte{0x1f, 0x8b}, newWriter: newGzipWriter, newReader: newGzipReader},
+	FormatTarZst: {magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, newWriter: newZstdWriter, newReader: newZstdReader},
+	FormatTarXz:  {magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, newWriter: newXzWriter, newReader: newXzReader},
+	FormatTarBz2: {magic: []byte{'B', 'Z', 'h'}, newWriter: newBzip2Writer, newReader: newBzip2Reader},
+}
+
+// compressionFormats maps compressor names to the tar format using them
+var compressionFormats = map[string]string{
+	"gzip":  FormatTarGz,
+	"zstd":  FormatTarZst,
+	"xz":    FormatTarXz,
+	"bzip2": FormatTarBz2,
+}
+
+// CompressionFormats returns the supported compressor names, sorted
+func CompressionFormats() []string {
+	names := make([]string, 0, len(compressionFormats))
+	for name := range compressionFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TarFormatFor returns the tar archive format compressed with the named
+// compressor, e.g. tar.zst for zstd
+func TarFormatFor(compression string) (string, error) {
+	format, ok := compressionFormats[compression]
+	if !ok {
+		return "", fmt.Errorf("unsupported compression format %q (supported: %s)", compression, strings.Join(CompressionFormats(), ", "))
+	}
+	return format, nil
+}
+
+// zipMagic is the signature of a zip local file header
+var zipMagic = []byte{'P', 'K', 0x03, 0x04}
+
+// isTarFormat reports whether format is a compressed tar format
+func isTarFormat(format string) bool {
+	_, ok := tarCodecs[format]
+	return ok
+}
+
+// detectFormat identifies the archive format of the file at path from its
+// leading magic bytes
+func detectFormat(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	format, err := detectStreamFormat(bufio.NewReader(file))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, path)
+	}
+	return format, nil
+}
+
+// detectStreamFormat identifies the archive format of a stream from its
+// leading magic bytes without consuming them
+func detectStreamFormat(r *bufio.Reader) (string, error) {
+	header, err := r.Peek(8)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read archive header: %w", err)
+	}
+
+	if bytes.HasPrefix(header, zipMagic) {
+		return FormatZip, nil
+	}
+	for format, codec := range tarCodecs {
+		if bytes.HasPrefix(header, codec.magic) {
+			return format, nil
+		}
+	}
+	if crypt.IsEncrypted(header) {
+		return "", fmt.Errorf("archive is encrypted")
+	}
+	return "", fmt.Errorf("unrecognized archive format")
+}
+
+// newTarCompressor returns the compressing writer for the archive format in opts
+func newTarCompressor(w io.Writer, opts Options) (io.WriteCloser, error) {
+	return tarCodecs[opts.Format].newWriter(w, opts.CompressionLevel, opts.workers())
+}
+
+// newGzipWriter compresses with parallel gzip; level 0 writes stored deflate
+// blocks
+func newGzipWriter(w io.Writer, level, workers int) (io.WriteCloser, error) {
+	// Use parallel gzip compression with one block in flight per worker
+	writer, err := pgzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	if err := writer.SetConcurrency(1<<20, workers); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	return pgzip.NewReader(r)
+}
+
+func newZstdWriter(w io.Writer, level, workers int) (io.WriteCloser, error) {
+	return zstd.NewWriter(w,
+		zstd.WithEncoderLevel(zstdLevel(level)),
+		zstd.WithEncoderConcurrency(workers),
+	)
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+// zstdLevel maps a 0-9 compression level onto the zstd encoder presets.
+// zstd has no store mode, so level 0 uses the fastest one.
+func zstdLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level <= 2:
+		return zstd.SpeedFastest
+	case level <= 6:
+		return zstd.SpeedDefault
+	case level <= 8:
+		return zstd.SpeedBetterCompression
+	default:
+		return zstd.SpeedBestCompression
+	}
+}
+
+// xzDictCaps maps 0-9 compression levels to the dictionary sizes of the
+// matching xz presets
+var xzDictCaps = [10]int{
+	256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20,
+	8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+// newXzWriter compresses with xz, which is slower than gzip and zstd but
+// usually produces smaller archives
+func newXzWriter(w io.Writer, level, workers int) (io.WriteCloser, error) {
+	config := xz.WriterConfig{DictCap: xzDictCaps[level]}
+	return config.NewWriter(w)
+}
+
+func newXzReader(r io.Reader) (io.ReadCloser, error) {
+	reader, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(reader), nil
+}
+
+// newBzip2Writer compresses with bzip2 for compatibility with older restore
+// tooling. Level 0 uses the smallest block size since bzip2 has no store mode.
+func newBzip2Writer(w io.Writer, level, workers int) (io.WriteCloser, error) {
+	if level < bzip2.BestSpeed {
+		level = bzip2.BestSpeed
+	} else if level > bzip2.BestCompression {
+		level = bzip2.BestCompression
+	}
+	return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+}
+
+func newBzip2Reader(r io.Reader) (io.ReadCloser, error) {
+	return bzip2.NewReader(r, nil)
+}
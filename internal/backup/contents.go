@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ContentsExtension is appended to the archive path to name the JSON
+// manifest listing every entry of the archive, written with Options.Manifest
+const ContentsExtension = ".manifest.json"
+
+// ContentsEntry describes one archived path in the contents manifest
+type ContentsEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mod_time"`
+	// Link is the target of a symlink
+	Link string `json:"link,omitempty"`
+}
+
+// contentsManifest is the JSON document written next to the archive
+type contentsManifest struct {
+	Archive string `json:"archive"`
+	Source  string `json:"source,omitempty"`
+	// Sources lists the directories of an archive of several sources
+	Sources []string  `json:"sources,omitempty"`
+	Created time.Time `json:"created"`
+	Entries int       `json:"entries"`
+	// Bytes is the size of the archived content, ArchiveBytes the size of
+	// the archive and Ratio the first divided by the second
+	Bytes        int64           `json:"bytes"`
+	ArchiveBytes int64           `json:"archive_bytes,omitempty"`
+	Ratio        float64         `json:"ratio,omitempty"`
+	Files        []ContentsEntry `json:"files"`
+}
+
+// contentsEntryFromHeader records a tar entry for the contents manifest
+func contentsEntryFromHeader(header *tar.Header) ContentsEntry {
+	return ContentsEntry{
+		Path:    filepath.ToSlash(header.Name),
+		Size:    header.Size,
+		Mode:    header.FileInfo().Mode().String(),
+		ModTime: header.ModTime,
+		Link:    header.Linkname,
+	}
+}
+
+// writeContents writes the contents manifest of the archive at archivePath,
+// sorted by path so manifests of different runs can be diffed. archiveBytes
+// is the size of the archive, or zero when it is unknown.
+func writeContents(archivePath string, opts Options, stats archiveStats, archiveBytes int64) (string, error) {
+	files := stats.Contents
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	data, err := json.MarshalIndent(contentsManifest{
+		Archive:      filepath.Base(archivePath),
+		Source:       opts.Source,
+		Sources:      opts.Sources,
+		Created:      time.Now(),
+		Entries:      stats.Entries,
+		Bytes:        stats.Bytes,
+		ArchiveBytes: archiveBytes,
+		Ratio:        compressionRatio(stats.Bytes, archiveBytes),
+		Files:        files,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode contents manifest: %w", err)
+	}
+
+	manifestPath := archivePath + ContentsExtension
+	if err := os.WriteFile(manifestPath, append(data, '\n'), opts.ArchiveMode); err != nil {
+		return "", fmt.Errorf("failed to write contents manifest: %w", err)
+	}
+	return manifestPath, nil
+}
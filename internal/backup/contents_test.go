@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteContentsRatio(t *testing.T) {
+	tests := []struct {
+		name         string
+		bytes        int64
+		archiveBytes int64
+		wantRatio    float64
+	}{
+		{"compressed", 4096, 1024, 4},
+		{"incompressible", 1000, 1000, 1},
+		// An unknown archive size, or empty content, leaves no ratio
+		{"unknown archive size", 4096, 0, 0},
+		{"empty source", 0, 512, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archivePath := filepath.Join(t.TempDir(), "user.tar.gz")
+			stats := archiveStats{Entries: 1, Bytes: tt.bytes}
+			manifestPath, err := writeContents(archivePath, Options{ArchiveMode: 0o600}, stats, tt.archiveBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(manifestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var manifest contentsManifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Bytes != tt.bytes || manifest.ArchiveBytes != tt.archiveBytes {
+				t.Fatalf("manifest sizes = %d, %d, want %d, %d", manifest.Bytes, manifest.ArchiveBytes, tt.bytes, tt.archiveBytes)
+			}
+			if manifest.Ratio != tt.wantRatio {
+				t.Fatalf("manifest ratio = %v, want %v", manifest.Ratio, tt.wantRatio)
+			}
+		})
+	}
+}
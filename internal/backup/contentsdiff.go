@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ContentsChange is a path that differs between two contents manifests.
+// Old is empty for an added path and New for a removed one.
+type ContentsChange struct {
+	Path string
+	Old  *ContentsEntry
+	New  *ContentsEntry
+}
+
+// SizeChange returns how many bytes the change adds, negative when it
+// shrinks or removes the path
+func (c ContentsChange) SizeChange() int64 {
+	var delta int64
+	if c.New != nil {
+		delta += c.New.Size
+	}
+	if c.Old != nil {
+		delta -= c.Old.Size
+	}
+	return delta
+}
+
+// ContentsDiff lists what changed between two contents manifests, each
+// sorted by path
+type ContentsDiff struct {
+	Added    []ContentsChange
+	Removed  []ContentsChange
+	Modified []ContentsChange
+}
+
+// ReadContents reads the entries of a contents manifest written with
+// Options.Manifest
+func ReadContents(path string) ([]ContentsEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contents manifest: %w", err)
+	}
+	var manifest contentsManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse contents manifest %s: %w", path, err)
+	}
+	return manifest.Files, nil
+}
+
+// DiffContents compares the entries of two manifests. A path is modified
+// when its size, modification time, type or link target changed;
+// directories only count as added or removed.
+func DiffContents(oldEntries, newEntries []ContentsEntry) ContentsDiff {
+	oldByPath := make(map[string]*ContentsEntry, len(oldEntries))
+	for i := range oldEntries {
+		oldByPath[oldEntries[i].Path] = &oldEntries[i]
+	}
+
+	var diff ContentsDiff
+	seen := make(map[string]bool, len(newEntries))
+	for i := range newEntries {
+		entry := &newEntries[i]
+		seen[entry.Path] = true
+		old, ok := oldByPath[entry.Path]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, ContentsChange{Path: entry.Path, New: entry})
+		case contentsModified(old, entry):
+			diff.Modified = append(diff.Modified, ContentsChange{Path: entry.Path, Old: old, New: entry})
+		}
+	}
+	for i := range oldEntries {
+		if entry := &oldEntries[i]; !seen[entry.Path] {
+			diff.Removed = append(diff.Removed, ContentsChange{Path: entry.Path, Old: entry})
+		}
+	}
+
+	for _, changes := range [][]ContentsChange{diff.Added, diff.Removed, diff.Modified} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	}
+	return diff
+}
+
+// contentsModified reports whether an entry present in both manifests changed
+func contentsModified(old, new *ContentsEntry) bool {
+	if isDirMode(old.Mode) && isDirMode(new.Mode) {
+		return false
+	}
+	return old.Size != new.Size || !old.ModTime.Equal(new.ModTime) ||
+		modeType(old.Mode) != modeType(new.Mode) || old.Link != new.Link
+}
+
+// isDirMode reports whether a manifest mode string describes a directory
+func isDirMode(mode string) bool {
+	return modeType(mode) == "d"
+}
+
+// modeType returns the file type letter of a manifest mode string, "-" for a
+// regular file
+func modeType(mode string) string {
+	if mode == "" {
+		return ""
+	}
+	return mode[:1]
+}
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+)
+
+// diskFullRetryDelay is how long to pause before retrying a write that failed
+// because the output disk is full
+const diskFullRetryDelay = 10 * time.Second
+
+// diskFullWriter retries writes that fail with ENOSPC until timeout has passed
+// since the disk first filled up, giving the user a chance to free space
+type diskFullWriter struct {
+	writer  io.Writer
+	timeout time.Duration
+}
+
+func (w *diskFullWriter) Write(p []byte) (int, error) {
+	var written int
+	var deadline time.Time
+	for {
+		n, err := w.writer.Write(p[written:])
+		written += n
+		if err == nil || !errors.Is(err, syscall.ENOSPC) {
+			return written, err
+		}
+
+		if deadline.IsZero() {
+			deadline = time.Now().Add(w.timeout)
+		}
+		if time.Now().After(deadline) {
+			sugar.Errorf("Output disk is still full after waiting %s", w.timeout)
+			return written, err
+		}
+
+		sugar.Warnf("Output disk is full, free some space; retrying in %s (giving up in %s)",
+			diskFullRetryDelay, time.Until(deadline).Round(time.Second))
+		time.Sleep(diskFullRetryDelay)
+	}
+}
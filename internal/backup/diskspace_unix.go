@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd
+
+package backup
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the bytes available to this user on the filesystem
+// holding dir
+func freeSpace(dir string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	// FreeBSD reports negative available blocks once the reserve is in use
+	avail := int64(stat.Bavail)
+	if avail < 0 {
+		avail = 0
+	}
+	return uint64(avail) * uint64(stat.Bsize), nil
+}
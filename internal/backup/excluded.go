@@ -0,0 +1,85 @@
+package backup
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// excludedTotal is what one exclude pattern left out of the archive
+type excludedTotal struct {
+	Paths int
+	// Bytes is the size of the regular files left out, including the
+	// contents of excluded directories
+	Bytes int64
+}
+
+// recordExclusion adds path, left out of the archive by reason (an exclude
+// pattern or IgnoreFileName), to stats. It only runs with opts.Verbose, as an
+// excluded directory is walked to measure it.
+func recordExclusion(stats *archiveStats, opts Options, reason, path string, info os.FileInfo) {
+	if !opts.Verbose {
+		return
+	}
+	if stats.Excluded == nil {
+		stats.Excluded = make(map[string]excludedTotal)
+	}
+	total := stats.Excluded[reason]
+	total.Paths++
+	total.Bytes += excludedSize(path, info)
+	stats.Excluded[reason] = total
+}
+
+// excludedSize returns the size of the regular file at path, or of the
+// regular files below the directory at path. Unreadable entries are not
+// counted and links are not followed.
+func excludedSize(path string, info os.FileInfo) int64 {
+	if !info.IsDir() {
+		if info.Mode().IsRegular() {
+			return info.Size()
+		}
+		return 0
+	}
+
+	var size int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// reportExcludedSizes logs how much each exclude pattern left out of the
+// archive, largest first, so the impact of the exclude list is visible
+func reportExcludedSizes(excluded map[string]excludedTotal) {
+	if len(excluded) == 0 {
+		return
+	}
+	reasons := make([]string, 0, len(excluded))
+	for reason := range excluded {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		a, b := excluded[reasons[i]], excluded[reasons[j]]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return reasons[i] < reasons[j]
+	})
+
+	var bytes int64
+	for _, reason := range reasons {
+		bytes += excluded[reason].Bytes
+	}
+	sugar.Infof("Excluded %.2f MB in total:", float64(bytes)/1024/1024)
+	for _, reason := range reasons {
+		total := excluded[reason]
+		sugar.Infof("  excluded %.2f MB (%d paths) via %s", float64(total.Bytes)/1024/1024, total.Paths, reason)
+	}
+}
@@ -0,0 +1,145 @@
+package backup
+
+import (
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"backup-home/internal/platform"
+)
+
+// getExcludePatterns resolves the exclude patterns for a backup run
+func getExcludePatterns(opts Options) []string {
+	base := platform.GetExcludePatterns()
+	if opts.BaseExcludes != nil {
+		base = opts.BaseExcludes
+	}
+	patterns := append(base, opts.Excludes...)
+	patterns = append(patterns, opts.rootIgnores[opts.Source].excludes...)
+	return uniquePatterns(patterns)
+}
+
+// getIncludePatterns resolves the include patterns for a backup run
+func getIncludePatterns(opts Options) []string {
+	patterns := append(append([]string(nil), opts.Includes...), opts.rootIgnores[opts.Source].includes...)
+	return uniquePatterns(patterns)
+}
+
+// logPatterns logs the exclude and include patterns applied to a backup
+func logPatterns(opts Options) {
+	if opts.IgnoreExcludes {
+		return
+	}
+	sugar.Infof("Using exclude patterns: [%s]", strings.Join(getExcludePatterns(opts), ", "))
+	if includes := getIncludePatterns(opts); len(includes) > 0 {
+		sugar.Infof("Using include patterns: [%s]", strings.Join(includes, ", "))
+	}
+}
+
+// uniquePatterns drops repeated patterns while keeping their first position
+func uniquePatterns(patterns []string) []string {
+	seen := make(map[string]bool, len(patterns))
+	result := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if seen[pattern] {
+			continue
+		}
+		seen[pattern] = true
+		result = append(result, pattern)
+	}
+	return result
+}
+
+// isExcludedPath reports whether relPath matches one of the exclude patterns,
+// using the platform's matching rules
+func isExcludedPath(relPath string, patterns []string) bool {
+	if runtime.GOOS == "windows" {
+		return isExcluded(relPath, patterns)
+	}
+
+	pathSegments := strings.Split("./"+filepath.ToSlash(relPath), "/")
+	for _, pattern := range patterns {
+		if matchPattern(strings.Split(pattern, "/"), pathSegments) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingExclude returns the first exclude pattern matching relPath
+func matchingExclude(relPath string, patterns []string) (string, bool) {
+	for i, pattern := range patterns {
+		if isExcludedPath(relPath, patterns[i:i+1]) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// excludeMatcher decides which paths of a walk the exclude patterns leave
+// out, with include patterns taking precedence over them. A directory
+// matching an exclude is still walked when an include could match something
+// beneath it; its contents are then excluded unless an include matches them,
+// and excludes matching deeper paths apply again.
+type excludeMatcher struct {
+	excludes []string
+	includes []string
+	// walked maps the excluded directories descended into for includes, in
+	// slash form, to the exclude pattern that matched them
+	walked map[string]string
+	// listed holds the paths of Options.FilesFrom, which are never excluded
+	listed map[string]bool
+}
+
+// newExcludeMatcher returns the matcher of the exclude and include patterns
+// of opts; it excludes nothing with opts.IgnoreExcludes
+func newExcludeMatcher(opts Options) *excludeMatcher {
+	m := &excludeMatcher{walked: make(map[string]string), listed: make(map[string]bool)}
+	if !opts.IgnoreExcludes {
+		m.excludes = getExcludePatterns(opts)
+		m.includes = getIncludePatterns(opts)
+	}
+	for _, relPath := range opts.FilesFrom {
+		m.listed[filepath.ToSlash(relPath)] = true
+	}
+	return m
+}
+
+// match reports whether relPath is left out and the exclude pattern
+// responsible. For an excluded directory, descend reports whether the walk
+// must still enter it because an include could match beneath it. Parents
+// must be matched before their contents.
+func (m *excludeMatcher) match(relPath string, isDir bool) (pattern string, excluded, descend bool) {
+	if m.listed[filepath.ToSlash(relPath)] {
+		return "", false, false
+	}
+	if _, ok := matchingExclude(relPath, m.includes); ok {
+		return "", false, false
+	}
+	pattern, excluded = matchingExclude(relPath, m.excludes)
+	if !excluded && len(m.walked) > 0 {
+		pattern, excluded = m.walked[path.Dir(filepath.ToSlash(relPath))]
+	}
+	if excluded && isDir && m.couldInclude(relPath) {
+		m.walked[filepath.ToSlash(relPath)] = pattern
+		descend = true
+	}
+	return pattern, excluded, descend
+}
+
+// couldInclude reports whether an include pattern could match a path below
+// the directory relPath. Windows patterns also match at any depth, so there
+// any include could.
+func (m *excludeMatcher) couldInclude(relPath string) bool {
+	if runtime.GOOS == "windows" {
+		return len(m.includes) > 0
+	}
+	dirSegments := strings.Split("./"+filepath.ToSlash(relPath), "/")
+	for _, include := range m.includes {
+		if matchPatternPrefix(strings.Split(include, "/"), dirSegments) {
+			return true
+		}
+	}
+	return false
+}
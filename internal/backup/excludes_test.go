@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestExcludeMatcher(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows patterns match at any depth")
+	}
+
+	type step struct {
+		path     string
+		dir      bool
+		excluded bool
+		descend  bool
+		// pattern is the exclude expected to leave path out
+		pattern string
+	}
+	tests := []struct {
+		name     string
+		excludes []string
+		includes []string
+		// steps are matched in walk order, parents before their contents
+		steps []step
+	}{
+		{
+			name:     "excludes without includes",
+			excludes: []string{"./.cache", "./**/*.log"},
+			steps: []step{
+				{path: ".cache", dir: true, excluded: true, pattern: "./.cache"},
+				{path: "docs", dir: true},
+				{path: "docs/debug.log", excluded: true, pattern: "./**/*.log"},
+				{path: "docs/notes.txt"},
+			},
+		},
+		{
+			name:     "an include overrides the exclude of the same path",
+			excludes: []string{"./**/*.log"},
+			includes: []string{"./keep.log"},
+			steps: []step{
+				{path: "keep.log"},
+				{path: "other.log", excluded: true, pattern: "./**/*.log"},
+			},
+		},
+		{
+			name:     "the walk descends into an excluded directory to reach an include",
+			excludes: []string{"./.cache"},
+			includes: []string{"./.cache/important"},
+			steps: []step{
+				{path: ".cache", dir: true, excluded: true, descend: true, pattern: "./.cache"},
+				{path: ".cache/important", dir: true},
+				{path: ".cache/important/data.txt"},
+				{path: ".cache/other", dir: true, excluded: true, pattern: "./.cache"},
+				{path: ".cache/temp.txt", excluded: true, pattern: "./.cache"},
+			},
+		},
+		{
+			name:     "nested excluded directories are descended to reach a deep include",
+			excludes: []string{"./.local"},
+			includes: []string{"./.local/share/keyrings"},
+			steps: []step{
+				{path: ".local", dir: true, excluded: true, descend: true, pattern: "./.local"},
+				{path: ".local/share", dir: true, excluded: true, descend: true, pattern: "./.local"},
+				{path: ".local/share/keyrings", dir: true},
+				{path: ".local/share/Trash", dir: true, excluded: true, pattern: "./.local"},
+				{path: ".local/state", dir: true, excluded: true, pattern: "./.local"},
+			},
+		},
+		{
+			name:     "an excluded directory with no include beneath it is not descended",
+			excludes: []string{"./.cache"},
+			includes: []string{"./docs/keep"},
+			steps: []step{
+				{path: ".cache", dir: true, excluded: true, pattern: "./.cache"},
+				{path: "docs", dir: true},
+			},
+		},
+		{
+			name:     "a ** include could match beneath any excluded directory",
+			excludes: []string{"./Downloads"},
+			includes: []string{"./**/*.pdf"},
+			steps: []step{
+				{path: "Downloads", dir: true, excluded: true, descend: true, pattern: "./Downloads"},
+				{path: "Downloads/invoice.pdf"},
+				{path: "Downloads/setup.iso", excluded: true, pattern: "./Downloads"},
+			},
+		},
+		{
+			name:     "excludes matching deeper paths apply again inside an include",
+			excludes: []string{"./.cache", "./**/*.tmp"},
+			includes: []string{"./.cache/keep"},
+			steps: []step{
+				{path: ".cache", dir: true, excluded: true, descend: true, pattern: "./.cache"},
+				{path: ".cache/keep", dir: true},
+				{path: ".cache/keep/state.json"},
+				{path: ".cache/keep/scratch.tmp", excluded: true, pattern: "./**/*.tmp"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newExcludeMatcher(Options{BaseExcludes: tt.excludes, Includes: tt.includes})
+			for _, s := range tt.steps {
+				pattern, excluded, descend := m.match(s.path, s.dir)
+				if excluded != s.excluded || descend != s.descend || pattern != s.pattern {
+					t.Errorf("match(%q) = %q, excluded %v, descend %v; want %q, excluded %v, descend %v",
+						s.path, pattern, excluded, descend, s.pattern, s.excluded, s.descend)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ReadFileList reads the paths of a --files-from list, one per line. Blank
+// lines and lines starting with # are skipped.
+func ReadFileList(listPath string) ([]string, error) {
+	file, err := os.Open(listPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file list: %w", err)
+	}
+	defer file.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file list %s: %w", listPath, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("file list %s lists no paths", listPath)
+	}
+	return paths, nil
+}
+
+// resolveFileList makes the listed paths relative to source, where relative
+// ones already are, and drops paths inside a listed directory, which is
+// archived with its contents anyway. Paths outside source are rejected.
+func resolveFileList(source string, list []string) ([]string, error) {
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve source %s: %w", source, err)
+	}
+
+	var resolved []string
+	for _, listed := range list {
+		relPath := filepath.Clean(listed)
+		if filepath.IsAbs(relPath) {
+			if relPath, err = filepath.Rel(absSource, relPath); err != nil {
+				return nil, fmt.Errorf("listed path %s is not inside the source %s", listed, source)
+			}
+		}
+		if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("listed path %s is not inside the source %s", listed, source)
+		}
+		if relPath == "." {
+			return nil, fmt.Errorf("listed path %s is the whole source, leave out --files-from to archive it", listed)
+		}
+		resolved = append(resolved, relPath)
+	}
+
+	sort.Strings(resolved)
+	var kept []string
+	for _, relPath := range resolved {
+		if !slices.ContainsFunc(kept, func(dir string) bool { return isWithin(relPath, dir) }) {
+			kept = append(kept, relPath)
+		}
+	}
+	return kept, nil
+}
+
+// walkRoots returns the paths a walk of opts starts from: the listed paths
+// of opts.FilesFrom below the source, or else the source itself
+func walkRoots(opts Options) []string {
+	if len(opts.FilesFrom) == 0 {
+		return []string{opts.Source}
+	}
+	roots := make([]string, len(opts.FilesFrom))
+	for i, relPath := range opts.FilesFrom {
+		roots[i] = filepath.Join(opts.Source, relPath)
+	}
+	return roots
+}
@@ -0,0 +1,39 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+)
+
+// sizeExclusion returns why a regular file is left out for its size, or an
+// empty string if it is within Options.MinFileSize and Options.MaxFileSize
+func sizeExclusion(info os.FileInfo, opts Options) string {
+	if !info.Mode().IsRegular() {
+		return ""
+	}
+	size := info.Size()
+	switch {
+	case opts.MaxFileSize > 0 && size > opts.MaxFileSize:
+		return fmt.Sprintf("%.2f MB is over the maximum file size of %.2f MB", float64(size)/1024/1024, float64(opts.MaxFileSize)/1024/1024)
+	case opts.MinFileSize > 0 && size < opts.MinFileSize:
+		return fmt.Sprintf("%d bytes is under the minimum file size of %d bytes", size, opts.MinFileSize)
+	default:
+		return ""
+	}
+}
+
+// excludedBySize reports whether a regular file is left out for its size,
+// logging each file over the maximum; the many small files under the
+// minimum are only logged with verbose output
+func excludedBySize(info os.FileInfo, relPath string, opts Options) bool {
+	reason := sizeExclusion(info, opts)
+	if reason == "" {
+		return false
+	}
+	if opts.MaxFileSize > 0 && info.Size() > opts.MaxFileSize {
+		sugar.Infof("Skipping %s: %s", relPath, reason)
+	} else {
+		sugar.Debugf("Skipping %s: %s", relPath, reason)
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitRepo holds what git reports as changed in one repository, as paths
+// relative to the source in slash form
+type gitRepo struct {
+	// dirty holds the modified and untracked files and their parent
+	// directories
+	dirty map[string]bool
+	// trees holds untracked directories git does not list the contents of,
+	// such as nested repositories
+	trees map[string]bool
+}
+
+// gitDirty limits a walk, with Options.GitDirtyOnly, to the files git reports
+// as modified or untracked in each repository found in the source. Paths
+// outside repositories are not affected.
+type gitDirty struct {
+	// repos maps the root of each repository found, relative to the source in
+	// slash form with "." for the source itself, to its changes
+	repos map[string]*gitRepo
+}
+
+// newGitDirty loads the repository at the root of the source, if any, or
+// returns nil when the walk is not limited to uncommitted changes
+func newGitDirty(opts Options) *gitDirty {
+	if !opts.GitDirtyOnly {
+		return nil
+	}
+	g := &gitDirty{repos: make(map[string]*gitRepo)}
+	if isGitRepo(opts.Source) {
+		g.load(opts.Source, ".")
+	}
+	return g
+}
+
+// skipped reports whether relPath is left out because it lies in a git
+// repository without uncommitted changes to it. A repository whose status
+// cannot be read is archived in full. A walk must pass each directory before
+// its contents, so that repositories are found.
+func (g *gitDirty) skipped(path, relPath string, isDir bool) bool {
+	if g == nil || relPath == "." {
+		return false
+	}
+	slashed := filepath.ToSlash(relPath)
+
+	if isDir && isGitRepo(path) {
+		// A nested repository is judged by its own status
+		if repo := g.load(path, slashed); repo != nil && len(repo.dirty) == 0 && len(repo.trees) == 0 {
+			return true
+		}
+		return false
+	}
+
+	root, repo := g.repoOf(slashed)
+	if repo == nil {
+		return false
+	}
+	if slashed == joinRel(root, ".git") || strings.HasPrefix(slashed, joinRel(root, ".git")+"/") {
+		return true
+	}
+	if repo.dirty[slashed] {
+		return false
+	}
+	for dir := slashed; dir != root && dir != "."; dir = parentRel(dir) {
+		if repo.trees[dir] {
+			return false
+		}
+	}
+	return true
+}
+
+// repoOf returns the innermost loaded repository containing slashed
+func (g *gitDirty) repoOf(slashed string) (string, *gitRepo) {
+	for dir := parentRel(slashed); ; dir = parentRel(dir) {
+		if repo, ok := g.repos[dir]; ok {
+			return dir, repo
+		}
+		if dir == "." {
+			return "", nil
+		}
+	}
+}
+
+// load reads the status of the repository at path, whose root is root
+// relative to the source, or returns nil when git fails
+func (g *gitDirty) load(path, root string) *gitRepo {
+	cmd := exec.Command("git", "-C", path, "status", "--porcelain", "-z", "--untracked-files=all")
+	out, err := cmd.Output()
+	if err != nil {
+		sugar.Warnf("Failed to read git status of %s, archiving all of it: %v", path, err)
+		return nil
+	}
+
+	repo := &gitRepo{dirty: make(map[string]bool), trees: make(map[string]bool)}
+	entries := bytes.Split(out, []byte{0})
+	for i := 0; i < len(entries); i++ {
+		entry := string(entries[i])
+		if len(entry) < 4 {
+			continue
+		}
+		status, name := entry[:2], entry[3:]
+		if status[0] == 'R' || status[0] == 'C' {
+			// The original path of a rename or copy follows
+			i++
+		}
+		rel := joinRel(root, strings.TrimSuffix(name, "/"))
+		if strings.HasSuffix(name, "/") {
+			repo.trees[rel] = true
+		} else {
+			repo.dirty[rel] = true
+		}
+		for dir := parentRel(rel); dir != root && dir != "."; dir = parentRel(dir) {
+			repo.dirty[dir] = true
+		}
+	}
+	g.repos[root] = repo
+	sugar.Debugf("Git repository %s: %d changed paths", path, len(repo.dirty)+len(repo.trees))
+	return repo
+}
+
+// isGitRepo reports whether the directory at path is the root of a git
+// repository or worktree
+func isGitRepo(path string) bool {
+	_, err := os.Lstat(filepath.Join(path, ".git"))
+	return err == nil
+}
+
+// joinRel joins name to the slash-form directory dir, where "." is the source
+func joinRel(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// parentRel returns the parent of the slash-form relative path rel, "." for a
+// top-level path
+func parentRel(rel string) string {
+	if i := strings.LastIndex(rel, "/"); i >= 0 {
+		return rel[:i]
+	}
+	return "."
+}
@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the per-directory ignore file read with Options.UseIgnoreFiles
+const IgnoreFileName = ".backupignore"
+
+// ignoreRule is one pattern of an ignore file
+type ignoreRule struct {
+	segments []string
+	// anchored patterns contain a slash and match relative to the ignore
+	// file's directory; others match a name at any depth below it
+	anchored bool
+	negate   bool
+	dirOnly  bool
+}
+
+// matches reports whether the rule matches rel, the path relative to the
+// directory of the rule's ignore file, split into segments
+func (r ignoreRule) matches(rel []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		return matchPattern(r.segments, rel)
+	}
+	return matchPattern(r.segments, rel[len(rel)-1:])
+}
+
+// ignoreFiles applies the ignore files found in the directories of a walk to
+// their subtrees, with gitignore semantics: later rules and rules from deeper
+// directories win, and a rule starting with "!" re-includes a path
+type ignoreFiles struct {
+	// rules maps a directory relative to the source, in slash form with ""
+	// for the source itself, to the rules of its ignore file
+	rules map[string][]ignoreRule
+}
+
+// newIgnoreFiles loads the ignore file at the root of the source, or returns
+// nil when ignore files are not used
+func newIgnoreFiles(opts Options) *ignoreFiles {
+	if !opts.UseIgnoreFiles {
+		return nil
+	}
+	f := &ignoreFiles{rules: make(map[string][]ignoreRule)}
+	f.load(opts.Source, "")
+	return f
+}
+
+// ignored reports whether relPath is left out by the ignore files of its
+// parent directories. A walk must pass each directory before its contents,
+// so that the directory's own ignore file is loaded.
+func (f *ignoreFiles) ignored(path, relPath string, isDir bool) bool {
+	// The source's own ignore file is loaded up front
+	if f == nil || relPath == "." {
+		return false
+	}
+
+	slashed := filepath.ToSlash(relPath)
+	parts := strings.Split(slashed, "/")
+	ignored := false
+	// Check from the source down, so rules in deeper directories win
+	for i := range parts {
+		for _, rule := range f.rules[strings.Join(parts[:i], "/")] {
+			if rule.matches(parts[i:], isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	if isDir && !ignored {
+		f.load(path, slashed)
+	}
+	return ignored
+}
+
+// load reads the ignore file of the directory at path, if it has one
+func (f *ignoreFiles) load(path, relDir string) {
+	ignorePath := filepath.Join(path, IgnoreFileName)
+	file, err := os.Open(ignorePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			sugar.Warnf("Failed to read ignore file %s: %v", ignorePath, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		sugar.Warnf("Failed to read ignore file %s: %v", ignorePath, err)
+	}
+	if len(rules) > 0 {
+		sugar.Debugf("Using ignore file: %s (%d patterns)", ignorePath, len(rules))
+		f.rules[relDir] = rules
+	}
+}
+
+// parseIgnoreRule parses one line of an ignore file, reporting false for
+// blank lines and comments
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		// An escaped leading "!" or "#" is part of the name
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	rule.segments = strings.Split(line, "/")
+	return rule, true
+}
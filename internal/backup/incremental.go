@@ -0,0 +1,10 @@
+package backup
+
+import "os"
+
+// unchangedSince reports whether an incremental backup leaves info out: it is
+// not a directory and was not modified after opts.Since. Directories are kept
+// so incremental archives can be restored on top of each other.
+func unchangedSince(info os.FileInfo, opts Options) bool {
+	return !opts.Since.IsZero() && !info.IsDir() && !info.ModTime().After(opts.Since)
+}
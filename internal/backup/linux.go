@@ -2,148 +2,148 @@ package backup
 
 import (
 	"archive/tar"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"backup-home/internal/logging"
-	"backup-home/internal/platform"
-
-	"github.com/klauspost/pgzip"
+	"backup-home/internal/progress"
 )
 
-func createLinuxArchive(source, backupPath string, compressionLevel int, verbose bool, ignoreExcludes bool, skipOnError bool) error {
+func createLinuxArchive(ctx context.Context, out io.Writer, opts Options) (archiveStats, error) {
+	var stats archiveStats
+
 	// Initialize logger (this is safe to call multiple times)
-	if err := logging.InitLogger(verbose); err != nil {
-		return fmt.Errorf("failed to initialize logger: %w", err)
+	if err := logging.InitLogger(opts.Verbose); err != nil {
+		return stats, fmt.Errorf("failed to initialize logger: %w", err)
 	}
 
 	// Get the sugar reference for this package
 	sugar = logging.GetSugar()
-	outFile, err := os.Create(backupPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer outFile.Close()
+	// Count compressed bytes for progress reporting
+	counter := &countingWriter{writer: out}
 
-	// Use parallel gzip compression with number of CPU cores
-	gzipWriter, err := pgzip.NewWriterLevel(outFile, compressionLevel)
+	compressor, err := newTarCompressor(counter, opts)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip writer: %w", err)
+		return stats, fmt.Errorf("failed to create %s writer: %w", opts.Format, err)
 	}
-	defer gzipWriter.Close()
 
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close()
+	tarWriter := tar.NewWriter(compressor)
+
+	// Read files ahead on worker goroutines while entries are written in walk order
+	pipeline := newTarPipeline(ctx, tarWriter, opts, &stats)
 
 	startTime := time.Now()
 	lastUpdate := time.Now()
-	updateInterval := 5 * time.Second
-
-	// Get exclude patterns
-	var excludePatterns []string
-	if !ignoreExcludes {
-		excludePatterns = platform.GetExcludePatterns()
-		sugar.Infof("Using exclude patterns: [%s]", strings.Join(excludePatterns, ", "))
-	}
-
-	err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			sugar.Debugf("Error accessing path %s: %v", path, err)
-			return nil
-		}
-
-		relPath, err := filepath.Rel(source, path)
-		if err != nil {
-			return fmt.Errorf("failed to get relative path: %w", err)
-		}
+	updateInterval := progress.Interval()
+
+	logPatterns(opts)
+	err = forEachSource(opts, func(opts Options, prefix string) error {
+		excludes := newExcludeMatcher(opts)
+		ignores := newIgnoreFiles(opts)
+		gitDirty := newGitDirty(opts)
+		return walkSource(ctx, opts, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				sugar.Debugf("Error accessing path %s: %v", path, err)
+				pipeline.skip(path, "access error", err)
+				return nil
+			}
 
-		if relPath == "." {
-			return nil
-		}
+			relPath, err := filepath.Rel(opts.Source, path)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path: %w", err)
+			}
 
-		// Normalize path for pattern matching
-		normalizedPath := "./" + filepath.ToSlash(relPath)
+			if relPath == "." {
+				return nil
+			}
 
-		// Check exclude patterns
-		for _, pattern := range excludePatterns {
-			segments := strings.Split(pattern, "/")
-			pathSegments := strings.Split(normalizedPath, "/")
+			// Normalize path for pattern matching
+			normalizedPath := "./" + filepath.ToSlash(relPath)
 
-			matched := matchPattern(segments, pathSegments)
-			if matched {
-				if verbose {
+			// Check exclude patterns
+			if pattern, excluded, descend := excludes.match(relPath, info.IsDir()); excluded {
+				if descend {
+					// Walked only for the include patterns that may match inside
+					if opts.Verbose {
+						sugar.Debugf("Excluding: %s (matched pattern %s, searching it for includes)", normalizedPath, pattern)
+					}
+					return nil
+				}
+				if opts.Verbose {
 					sugar.Debugf("Excluding: %s (matched pattern %s)", normalizedPath, pattern)
 				}
+				recordExclusion(&stats, opts, pattern, path, info)
 				if info.IsDir() {
 					return filepath.SkipDir
 				}
 				return nil
 			}
-		}
 
-		if verbose {
-			sugar.Debugf("Including: %s", normalizedPath)
-		}
-
-		// Handle symlinks specially on Linux
-		var header *tar.Header
-		if info.Mode()&os.ModeSymlink != 0 {
-			link, err := os.Readlink(path)
-			if err != nil {
-				sugar.Debugf("Failed to read symlink %s: %v", path, err)
+			if ignores.ignored(path, relPath, info.IsDir()) {
+				sugar.Debugf("Ignoring: %s (%s)", relPath, IgnoreFileName)
+				recordExclusion(&stats, opts, IgnoreFileName, path, info)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
 				return nil
 			}
-			header, err = tar.FileInfoHeader(info, link)
-		} else {
-			header, err = tar.FileInfoHeader(info, info.Name())
-		}
-
-		if err != nil {
-			if skipOnError {
-				sugar.Warnf("Skipping file due to header creation error: %s (%v)", path, err)
+
+			if gitDirty.skipped(path, relPath, info.IsDir()) {
+				sugar.Debugf("Skipping: %s (no uncommitted changes in git)", relPath)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
 				return nil
 			}
-			return fmt.Errorf("failed to create tar header for %s: %w", path, err)
-		}
-		header.Name = relPath
 
-		if err := tarWriter.WriteHeader(header); err != nil {
-			if skipOnError {
-				sugar.Warnf("Skipping file due to header write error: %s (%v)", path, err)
+			if unchangedSince(info, opts) {
 				return nil
 			}
-			return fmt.Errorf("failed to write tar header for %s: %w", path, err)
-		}
-
-		if info.Mode().IsRegular() {
-			file, err := os.Open(path)
-			if err != nil {
-				sugar.Debugf("Failed to open file %s: %v", path, err)
+			if excludedBySize(info, relPath, opts) {
 				return nil
 			}
-			defer file.Close()
 
-			buf := bufferPool.Get().([]byte)
-			_, err = io.CopyBuffer(tarWriter, file, buf)
-			bufferPool.Put(buf)
+			if opts.Verbose {
+				sugar.Debugf("Including: %s", normalizedPath)
+			}
+
+			// Handle symlinks specially on Linux
+			var header *tar.Header
+			if info.Mode()&os.ModeSymlink != 0 {
+				link, err := os.Readlink(path)
+				if err != nil {
+					sugar.Debugf("Failed to read symlink %s: %v", path, err)
+					return nil
+				}
+				header, err = tar.FileInfoHeader(info, link)
+			} else {
+				header, err = tar.FileInfoHeader(info, info.Name())
+			}
+
 			if err != nil {
-				if skipOnError {
-					sugar.Warnf("Skipping file due to content write error: %s (%v)", path, err)
+				if opts.SkipOnError {
+					sugar.Warnf("Skipping file due to header creation error: %s (%v)", path, err)
+					pipeline.skip(path, "header creation error", err)
 					return nil
 				}
-				sugar.Errorf("Failed to write file %s: %v", path, err)
-				return fmt.Errorf("failed to write file content for %s: %w", path, err)
+				return fmt.Errorf("failed to create tar header for %s: %w", path, err)
+			}
+			header.Name = entryName(prefix, relPath)
+			if opts.PreserveXattrs {
+				addXattrs(header, path)
 			}
-		}
 
-		// Progress reporting
-		if time.Since(lastUpdate) >= updateInterval {
-			if stat, err := outFile.Stat(); err == nil {
-				sizeMB := float64(stat.Size()) / 1024 / 1024
+			if err := pipeline.submit(path, header); err != nil {
+				return err
+			}
+
+			// Progress reporting
+			if time.Since(lastUpdate) >= updateInterval {
+				sizeMB := float64(counter.Count()) / 1024 / 1024
 				elapsed := time.Since(startTime).Seconds()
 				mbPerSec := sizeMB / elapsed
 
@@ -152,24 +152,22 @@ func createLinuxArchive(source, backupPath string, compressionLevel int, verbose
 					sizeMB,
 					mbPerSec,
 				)
+				progress.Report(progress.TypeProgress, progress.PhaseArchive, counter.Count(), 0, startTime)
+				lastUpdate = time.Now()
 			}
-			lastUpdate = time.Now()
-		}
 
-		return nil
+			return nil
+		})
 	})
+	if closeErr := pipeline.close(); err == nil {
+		err = closeErr
+	}
 
 	if err != nil {
-		return fmt.Errorf("failed to create archive: %w", err)
+		err = fmt.Errorf("failed to create archive: %w", err)
 	}
-
-	// Final statistics
-	if stat, err := outFile.Stat(); err == nil {
-		sugar.Infof("Final archive size: %.2f MB (average speed: %.2f MB/s)",
-			float64(stat.Size())/1024/1024,
-			float64(stat.Size())/1024/1024/time.Since(startTime).Seconds(),
-		)
+	if closeErr := closeArchiveWriters(tarWriter.Close, compressor.Close); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to finish archive: %w", closeErr)
 	}
-
-	return nil
+	return stats, err
 }
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ListEntry is a path visited by ListFiles
+type ListEntry struct {
+	// Path is relative to the source, below the source's name when there
+	// are several
+	Path string
+	Info os.FileInfo
+	// Excluded is set for a path left out of the archive, with the reason
+	Excluded bool
+	Reason   string
+}
+
+// ListStats counts what ListFiles found
+type ListStats struct {
+	Files int
+	Dirs  int
+	// Bytes is the total size of the included regular files
+	Bytes    int64
+	Excluded int
+}
+
+// ListFiles walks the source like an archive builder would and calls fn for
+// every path it includes or excludes, without creating an archive. The
+// contents of an excluded directory are not visited.
+func ListFiles(opts Options, fn func(ListEntry)) (ListStats, error) {
+	return listFiles(context.Background(), opts, fn)
+}
+
+// listFiles is ListFiles stopping with ctx's error once ctx is canceled
+func listFiles(ctx context.Context, opts Options, fn func(ListEntry)) (ListStats, error) {
+	var stats ListStats
+
+	opts, err := prepareOptions(opts)
+	if err != nil {
+		return stats, err
+	}
+
+	err = forEachSource(opts, func(opts Options, prefix string) error {
+		excludes := newExcludeMatcher(opts)
+		ignores := newIgnoreFiles(opts)
+		gitDirty := newGitDirty(opts)
+		return walkSource(ctx, opts, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				sugar.Debugf("Error accessing path %s: %v", path, err)
+				return nil
+			}
+
+			relPath, err := filepath.Rel(opts.Source, path)
+			if err != nil || relPath == "." {
+				return nil
+			}
+
+			reason := ""
+			if pattern, excluded, descend := excludes.match(relPath, info.IsDir()); excluded {
+				if descend {
+					// Searched for includes, so only its contents are listed
+					return nil
+				}
+				reason = "pattern " + pattern
+			} else if ignores.ignored(path, relPath, info.IsDir()) {
+				reason = IgnoreFileName
+			} else if gitDirty.skipped(path, relPath, info.IsDir()) {
+				reason = "no uncommitted changes in git"
+			} else if unchangedSince(info, opts) {
+				reason = "unchanged since last backup"
+			} else if size := sizeExclusion(info, opts); size != "" {
+				reason = size
+			}
+
+			if reason != "" {
+				stats.Excluded++
+				fn(ListEntry{Path: entryName(prefix, relPath), Info: info, Excluded: true, Reason: reason})
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if info.IsDir() {
+				stats.Dirs++
+			} else {
+				stats.Files++
+			}
+			if info.Mode().IsRegular() {
+				stats.Bytes += info.Size()
+			}
+			fn(ListEntry{Path: entryName(prefix, relPath), Info: info})
+			return nil
+		})
+	})
+	if err != nil {
+		return stats, fmt.Errorf("failed to walk directory: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// localBackup is an archive found next to a new backup, with the companion
+// files named after it: parts and manifest, checksum files, contents lists
+type localBackup struct {
+	name    string
+	modTime time.Time
+	files   []string
+}
+
+// PruneLocalBackups keeps the newest keep archives of the current user in the
+// directory of backupPath, deleting older ones along with their companion
+// files. Archives are recognized by the username naming scheme,
+// username.tar.gz or username.<anything>.tar.gz, with the extension of
+// backupPath, which always counts as the newest. It returns the archives
+// deleted.
+func PruneLocalBackups(backupPath string, keep int) ([]string, error) {
+	if keep < 1 {
+		return nil, fmt.Errorf("at least one local backup must be kept")
+	}
+	ext := archiveExtensionOf(backupPath)
+	if ext == "" {
+		return nil, fmt.Errorf("%s does not have an archive extension", backupPath)
+	}
+	username, err := getUsername()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get username: %w", err)
+	}
+
+	dir := filepath.Dir(backupPath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	current := filepath.Base(backupPath)
+	var backups []localBackup
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		// A split archive is found through its manifest
+		name := strings.TrimSuffix(entry.Name(), ManifestExtension)
+		if name == current || seen[name] || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		if name != username+ext && !strings.HasPrefix(name, username+".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		seen[name] = true
+		backups = append(backups, localBackup{name: name, modTime: info.ModTime()})
+	}
+	if len(backups) < keep {
+		return nil, nil
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].modTime.After(backups[j].modTime)
+	})
+	var removed []string
+	for _, old := range backups[keep-1:] {
+		for _, entry := range entries {
+			if entry.Name() == old.name || isCompanion(entry.Name(), old.name) {
+				old.files = append(old.files, filepath.Join(dir, entry.Name()))
+			}
+		}
+		for _, path := range old.files {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return removed, fmt.Errorf("failed to remove old local backup: %w", err)
+			}
+		}
+		sugar.Infof("Removed old local backup: %s", filepath.Join(dir, old.name))
+		removed = append(removed, filepath.Join(dir, old.name))
+	}
+	return removed, nil
+}
+
+// isCompanion reports whether name is a file belonging to the archive
+// archiveName, such as a part or checksum file, and not another archive like
+// its encrypted counterpart
+func isCompanion(name, archiveName string) bool {
+	return strings.HasPrefix(name, archiveName+".") && archiveExtensionOf(name) == ""
+}
+
+// archiveExtensionOf returns the archive extension path ends with, or an
+// empty string when it has none
+func archiveExtensionOf(path string) string {
+	for _, format := range Formats() {
+		for _, encrypted := range []bool{true, false} {
+			if ext := archiveExtension(format, encrypted); strings.HasSuffix(path, ext) {
+				return ext
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,294 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// smallFileLimit is the largest file read fully into memory by a reader
+// worker; larger files are opened ahead and streamed by the writer
+const smallFileLimit = 1024 * 1024
+
+var smallFilePool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, smallFileLimit)
+	},
+}
+
+// tarEntry is one walked path on its way through a tarPipeline
+type tarEntry struct {
+	path   string
+	header *tar.Header
+	// data holds the content of a small file, file an opened large file
+	data []byte
+	file *os.File
+	// skip drops an entry whose file could not be opened, with the error in err
+	skip bool
+	err  error
+	// ready is closed once the content has been read or opened
+	ready chan struct{}
+}
+
+// tarPipeline reads file contents on several workers while a single writer
+// adds entries to the tar stream in the order they were submitted. The
+// writer consumes a bounded queue of entries in walk order, so a small file
+// read quickly never jumps ahead of a large one, and at most window entries
+// are held in memory at once. Once ctx is canceled nothing more is read or
+// written and the pipeline fails with ctx's error.
+type tarPipeline struct {
+	ctx       context.Context
+	tarWriter *tar.Writer
+	opts      Options
+	stats     *archiveStats
+
+	jobs    chan *tarEntry
+	ordered chan *tarEntry
+	workers sync.WaitGroup
+	done    chan struct{}
+	// failed is closed after err is set by the writer
+	failed chan struct{}
+	err    error
+	// skipMu guards stats.Skipped, added to by the walk and the writer
+	skipMu sync.Mutex
+}
+
+// newTarPipeline starts the reader workers and the writer. Written entries and
+// bytes are added to stats, which must not be read until close returns.
+func newTarPipeline(ctx context.Context, tarWriter *tar.Writer, opts Options, stats *archiveStats) *tarPipeline {
+	numWorkers := opts.workers()
+	p := &tarPipeline{
+		ctx:       ctx,
+		tarWriter: tarWriter,
+		opts:      opts,
+		stats:     stats,
+		jobs:      make(chan *tarEntry, numWorkers*2),
+		ordered:   make(chan *tarEntry, numWorkers*4),
+		done:      make(chan struct{}),
+		failed:    make(chan struct{}),
+	}
+
+	for i := 0; i < numWorkers; i++ {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for entry := range p.jobs {
+				if ctx.Err() == nil {
+					p.read(entry)
+				}
+				close(entry.ready)
+			}
+		}()
+	}
+	go p.writeAll()
+
+	return p
+}
+
+// submit queues a header, and for regular files the content at path, to be
+// written after everything submitted before it. It returns the writer's error
+// once writing has failed.
+func (p *tarPipeline) submit(path string, header *tar.Header) error {
+	entry := &tarEntry{path: path, header: header, ready: make(chan struct{})}
+
+	select {
+	case p.ordered <- entry:
+	case <-p.failed:
+		return p.err
+	}
+
+	if header.Typeflag == tar.TypeReg {
+		p.jobs <- entry
+	} else {
+		close(entry.ready)
+	}
+	return nil
+}
+
+// close waits for every submitted entry to be written and returns the
+// writer's error, if any
+func (p *tarPipeline) close() error {
+	close(p.ordered)
+	close(p.jobs)
+	p.workers.Wait()
+	<-p.done
+	return p.err
+}
+
+// skip records a path left out of the archive because of err
+func (p *tarPipeline) skip(path, reason string, err error) {
+	p.skipMu.Lock()
+	p.stats.Skipped = append(p.stats.Skipped, skippedFile(path, reason, err))
+	p.skipMu.Unlock()
+}
+
+// read opens the entry's file and, if it is small, reads it into a pooled buffer
+func (p *tarPipeline) read(entry *tarEntry) {
+	file, err := os.Open(entry.path)
+	if err != nil {
+		sugar.Debugf("Failed to open file %s: %v", entry.path, err)
+		entry.skip = true
+		entry.err = err
+		return
+	}
+
+	if entry.header.Size > smallFileLimit {
+		entry.file = file
+		return
+	}
+	defer file.Close()
+
+	buf := smallFilePool.Get().([]byte)
+	n, err := io.ReadFull(file, buf[:entry.header.Size])
+	if err != nil {
+		smallFilePool.Put(buf)
+		entry.err = fmt.Errorf("read %d of %d bytes: %w", n, entry.header.Size, err)
+		return
+	}
+	entry.data = buf[:n]
+}
+
+// writeAll writes queued entries in order until the queue is closed. After a
+// fatal error the remaining entries are only released.
+func (p *tarPipeline) writeAll() {
+	defer close(p.done)
+
+	for entry := range p.ordered {
+		<-entry.ready
+		if p.err == nil {
+			err := p.ctx.Err()
+			if err == nil {
+				err = p.write(entry)
+			}
+			if err != nil {
+				p.err = err
+				close(p.failed)
+			}
+		}
+		if entry.file != nil {
+			entry.file.Close()
+		}
+		if entry.data != nil {
+			smallFilePool.Put(entry.data[:cap(entry.data)])
+		}
+	}
+}
+
+func (p *tarPipeline) write(entry *tarEntry) error {
+	if entry.skip {
+		if !p.opts.SkipOnError {
+			return fmt.Errorf("failed to open %s: %w", entry.path, entry.err)
+		}
+		sugar.Warnf("Skipping file due to open error: %s (%v)", entry.path, entry.err)
+		p.skip(entry.path, "open error", entry.err)
+		return nil
+	}
+	if entry.err != nil {
+		if p.opts.SkipOnError {
+			sugar.Warnf("Skipping file due to read error: %s (%v)", entry.path, entry.err)
+			p.skip(entry.path, "read error", entry.err)
+			return nil
+		}
+		return fmt.Errorf("failed to read file content for %s: %w", entry.path, entry.err)
+	}
+
+	// The manifest and change check below keep the real header
+	header := entry.header
+	if p.opts.Reproducible {
+		header = reproducibleTarHeader(header)
+	}
+	if err := p.tarWriter.WriteHeader(header); err != nil {
+		if ctxErr := p.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if p.opts.SkipOnError {
+			sugar.Warnf("Skipping file due to header write error: %s (%v)", entry.path, err)
+			p.skip(entry.path, "header write error", err)
+			return nil
+		}
+		return fmt.Errorf("failed to write tar header for %s: %w", entry.path, err)
+	}
+	p.stats.Entries++
+
+	var written int64
+	var err, readErr error
+	switch {
+	case entry.data != nil:
+		var n int
+		n, err = p.tarWriter.Write(entry.data)
+		written = int64(n)
+	case entry.file != nil:
+		source := &sourceReader{reader: io.LimitReader(entry.file, entry.header.Size)}
+		buf := bufferPool.Get().([]byte)
+		written, err = io.CopyBuffer(p.tarWriter, source, buf)
+		bufferPool.Put(buf)
+		if source.err != nil {
+			readErr, err = source.err, nil
+		} else if err == nil && written < entry.header.Size {
+			readErr = io.ErrUnexpectedEOF
+		}
+	}
+	p.stats.Bytes += written
+
+	if err != nil {
+		if ctxErr := p.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		// The header promised the full size, so the archive cannot go on
+		return fmt.Errorf("failed to write file content for %s: %w", entry.path, err)
+	}
+	if readErr != nil {
+		if !p.opts.SkipOnError {
+			return fmt.Errorf("failed to read file content for %s: %w", entry.path, readErr)
+		}
+		// The header already promised the full size, so the rest of the
+		// entry is filled with zeros to keep the tar stream valid
+		if _, err := io.CopyN(p.tarWriter, zeroReader{}, entry.header.Size-written); err != nil {
+			if ctxErr := p.ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("failed to write file content for %s: %w", entry.path, err)
+		}
+		sugar.Warnf("File could not be read completely, archived with its last %d bytes as zeros: %s (%v)",
+			entry.header.Size-written, entry.path, readErr)
+		p.skip(entry.path, "read error", readErr)
+		return nil
+	}
+
+	if entry.header.Typeflag == tar.TypeReg {
+		p.stats.RegularFiles++
+	}
+	if p.opts.Manifest {
+		p.stats.Contents = append(p.stats.Contents, contentsEntryFromHeader(entry.header))
+	}
+	if p.opts.CheckChanges && entry.header.Typeflag == tar.TypeReg {
+		p.stats.Files = append(p.stats.Files, fileRecord{path: entry.path, size: entry.header.Size, modTime: entry.header.ModTime})
+	}
+	return nil
+}
+
+// sourceReader keeps the error reading an entry's file, to tell it from a
+// failure writing the archive
+type sourceReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *sourceReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// zeroReader reads zero bytes without end
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestCreateArchiveUnreadableFile checks that a file which cannot be opened
+// fails the archive unless SkipOnError is set, and is listed as skipped if so
+func TestCreateArchiveUnreadableFile(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("file modes do not keep this user from reading files")
+	}
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "readable.txt"), []byte("readable\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unreadable := filepath.Join(source, "unreadable.txt")
+	if err := os.WriteFile(unreadable, []byte("secret\n"), 0000); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, skipOnError := range []bool{false, true} {
+		t.Run(fmt.Sprintf("SkipOnError=%v", skipOnError), func(t *testing.T) {
+			opts, err := prepareOptions(Options{
+				Source:      source,
+				Format:      FormatTarGz,
+				SkipOnError: skipOnError,
+				ArchiveMode: 0600,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stats, err := createArchive(context.Background(), filepath.Join(t.TempDir(), "backup.tar.gz"), opts)
+			if !skipOnError {
+				if err == nil {
+					t.Fatal("archiving an unreadable file succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stats.Skipped) != 1 || stats.Skipped[0].Path != unreadable {
+				t.Fatalf("skipped = %v, want only %s", stats.Skipped, unreadable)
+			}
+		})
+	}
+}
+
+// writeBenchmarkSource fills dir with many small files and a few large ones,
+// like a home directory of dotfiles next to media
+func writeBenchmarkSource(b *testing.B, dir string) {
+	b.Helper()
+	for i := 0; i < 500; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("dir%02d", i%20))
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			b.Fatal(err)
+		}
+		writeRandomFile(b, filepath.Join(sub, fmt.Sprintf("small%03d", i)), 16*1024)
+	}
+	for i := 0; i < 4; i++ {
+		writeRandomFile(b, filepath.Join(dir, fmt.Sprintf("large%d", i)), 4*1024*1024)
+	}
+}
+
+func writeRandomFile(b *testing.B, path string, size int) {
+	b.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkCreateArchive compares the serial path, a single reader, with the
+// parallel readers of the tar pipeline
+func BenchmarkCreateArchive(b *testing.B) {
+	source := b.TempDir()
+	writeBenchmarkSource(b, source)
+
+	for _, bench := range []struct {
+		name        string
+		concurrency int
+	}{
+		{"sequential", 1},
+		{"parallel", 0},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			opts, err := prepareOptions(Options{
+				Source:           source,
+				Format:           FormatTarGz,
+				CompressionLevel: 1,
+				Concurrency:      bench.concurrency,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				stats, err := writeArchive(context.Background(), io.Discard, opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(stats.Bytes)
+			}
+		})
+	}
+}
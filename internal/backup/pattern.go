@@ -54,3 +54,28 @@ func matchPattern(pattern, path []string) bool {
 
 	return matchPattern(pattern[1:], path[1:])
 }
+
+// matchPatternPrefix reports whether the pattern segments could match a path
+// below the path segments, so a walk has to enter that directory to find it
+func matchPatternPrefix(pattern, path []string) bool {
+	if len(path) == 0 {
+		return len(pattern) > 0
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+
+	// Extension patterns and ** match at any depth
+	if pattern[0] == "**" || (strings.HasPrefix(pattern[0], "*") && strings.Contains(pattern[0], ".")) {
+		return true
+	}
+
+	name, segment := pattern[0], path[0]
+	if runtime.GOOS == "windows" {
+		name, segment = strings.ToLower(name), strings.ToLower(segment)
+	}
+	if matched, err := filepath.Match(name, segment); err != nil || !matched {
+		return false
+	}
+	return matchPatternPrefix(pattern[1:], path[1:])
+}
@@ -0,0 +1,30 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"time"
+)
+
+// reproducibleTime is the modification time of every entry in a reproducible
+// archive, the earliest time a zip entry can hold
+var reproducibleTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// reproducibleTarHeader returns a copy of header without the metadata that
+// differs between runs or machines while the content stays the same: the
+// times are fixed and the owner IDs and names dropped
+func reproducibleTarHeader(header *tar.Header) *tar.Header {
+	normalized := *header
+	normalized.ModTime = reproducibleTime
+	normalized.AccessTime = time.Time{}
+	normalized.ChangeTime = time.Time{}
+	normalized.Uid, normalized.Gid = 0, 0
+	normalized.Uname, normalized.Gname = "", ""
+	return &normalized
+}
+
+// makeZipHeaderReproducible fixes the modification time of a zip header, the
+// only metadata of a zip entry that changes while its content does not
+func makeZipHeaderReproducible(header *zip.FileHeader) {
+	header.Modified = reproducibleTime
+}
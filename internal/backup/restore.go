@@ -0,0 +1,386 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"backup-home/internal/crypt"
+	"backup-home/internal/logging"
+)
+
+// ArchiveEntry describes one entry of an archive being restored or listed
+type ArchiveEntry struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	// Linkname is the target of a symlink or hard link
+	Linkname string
+	// Xattrs are the extended attributes stored with a tar entry
+	Xattrs map[string]string
+}
+
+// RestoreOptions configures RestoreArchive
+type RestoreOptions struct {
+	// Target is the directory the archive is extracted into
+	Target string
+	// List only reads the entries, without writing anything to Target
+	List bool
+	// Passphrase decrypts an archive created with --encrypt
+	Passphrase string
+	// Entry, if set, is called for every entry of the archive
+	Entry func(ArchiveEntry)
+}
+
+// RestoreStats summarizes a restored or listed archive
+type RestoreStats struct {
+	Entries int
+	Bytes   int64
+}
+
+// RestoreArchive extracts an archive created by CreateBackup into
+// opts.Target, recreating directories, file modes, modification times and
+// links. A split archive is read from the parts listed in the manifest at
+// path plus ManifestExtension. The format is detected from the file contents.
+// Entries that would be written outside the target are refused.
+func RestoreArchive(path string, opts RestoreOptions) (RestoreStats, error) {
+	sugar = logging.GetSugar()
+
+	file, err := openArchive(path)
+	if err != nil {
+		return RestoreStats{}, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	buffered := bufio.NewReader(file)
+	encrypted := false
+	if header, _ := buffered.Peek(8); crypt.IsEncrypted(header) {
+		if opts.Passphrase == "" {
+			return RestoreStats{}, fmt.Errorf("archive is encrypted: a passphrase is required")
+		}
+		reader, err := crypt.NewReader(buffered, opts.Passphrase)
+		if err != nil {
+			return RestoreStats{}, err
+		}
+		buffered = bufio.NewReader(reader)
+		encrypted = true
+	}
+
+	format, err := detectStreamFormat(buffered)
+	if err != nil {
+		return RestoreStats{}, err
+	}
+	sugar.Infof("Reading %s archive: %s", format, path)
+
+	ex := &extractor{opts: opts}
+	if !opts.List {
+		if err := os.MkdirAll(opts.Target, 0755); err != nil {
+			return RestoreStats{}, fmt.Errorf("failed to create target directory: %w", err)
+		}
+		target, err := filepath.Abs(opts.Target)
+		if err == nil {
+			target, err = filepath.EvalSymlinks(target)
+		}
+		if err != nil {
+			return RestoreStats{}, fmt.Errorf("failed to resolve target directory: %w", err)
+		}
+		ex.root = target
+	}
+
+	if format == FormatZip && encrypted {
+		// Reading a zip needs random access, so decrypt it to a temp file
+		zipPath, decryptErr := decryptToTemp(buffered, path)
+		if decryptErr != nil {
+			return RestoreStats{}, decryptErr
+		}
+		defer os.Remove(zipPath)
+		decrypted, openErr := openArchive(zipPath)
+		if openErr != nil {
+			return RestoreStats{}, fmt.Errorf("failed to open decrypted archive: %w", openErr)
+		}
+		defer decrypted.Close()
+		err = ex.restoreZip(decrypted, decrypted.Size())
+	} else if format == FormatZip {
+		err = ex.restoreZip(file, file.Size())
+	} else {
+		err = ex.restoreTar(buffered, format)
+		if err == nil && encrypted {
+			// Read to the end so the final encrypted chunk is authenticated too
+			_, err = io.Copy(io.Discard, buffered)
+		}
+	}
+	if err == nil {
+		ex.finishDirs()
+	}
+	return ex.stats, err
+}
+
+// decryptToTemp writes the decrypted archive from r next to path
+func decryptToTemp(r io.Reader, path string) (string, error) {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".restore-*.zip")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := io.Copy(temp, r); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return "", fmt.Errorf("failed to decrypt archive: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return "", err
+	}
+	return temp.Name(), nil
+}
+
+// dirTimes records a restored directory's mode and time, applied once its
+// contents are written so a read-only directory can still be filled
+type dirTimes struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// extractor writes archive entries below root
+type extractor struct {
+	opts  RestoreOptions
+	root  string
+	stats RestoreStats
+	dirs  []dirTimes
+	// safeDirs caches parent directories already checked to stay inside root
+	safeDirs map[string]bool
+}
+
+func (ex *extractor) restoreTar(r io.Reader, format string) error {
+	decompressor, err := tarCodecs[format].newReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to create %s reader: %w", format, err)
+	}
+	defer decompressor.Close()
+
+	tarReader := tar.NewReader(decompressor)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry %d: %w", ex.stats.Entries+1, err)
+		}
+
+		entry := ArchiveEntry{
+			Name:     header.Name,
+			Size:     header.Size,
+			Mode:     header.FileInfo().Mode(),
+			ModTime:  header.ModTime,
+			Linkname: header.Linkname,
+			Xattrs:   xattrsFromHeader(header),
+		}
+		if header.Typeflag == tar.TypeLink {
+			err = ex.link(entry)
+		} else {
+			err = ex.restore(entry, tarReader)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (ex *extractor) restoreZip(r io.ReaderAt, size int64) error {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	for _, file := range zipReader.File {
+		entry := ArchiveEntry{
+			Name:    strings.TrimSuffix(file.Name, "/"),
+			Size:    int64(file.UncompressedSize64),
+			Mode:    file.Mode(),
+			ModTime: file.Modified,
+		}
+		if err := ex.restoreZipEntry(entry, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ex *extractor) restoreZipEntry(entry ArchiveEntry, file *zip.File) error {
+	if !entry.Mode.IsRegular() || ex.opts.List {
+		return ex.restore(entry, nil)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", entry.Name, err)
+	}
+	defer reader.Close()
+	return ex.restore(entry, reader)
+}
+
+// restore writes one entry, reading a regular file's content from content
+func (ex *extractor) restore(entry ArchiveEntry, content io.Reader) error {
+	ex.stats.Entries++
+	if entry.Mode.IsRegular() {
+		ex.stats.Bytes += entry.Size
+	}
+	if ex.opts.Entry != nil {
+		ex.opts.Entry(entry)
+	}
+	if ex.opts.List {
+		return nil
+	}
+
+	dest, err := ex.destination(entry.Name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case entry.Mode.IsDir():
+		if err := os.MkdirAll(dest, 0700); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dest, err)
+		}
+		restoreXattrs(dest, entry.Xattrs)
+		ex.dirs = append(ex.dirs, dirTimes{path: dest, mode: entry.Mode.Perm(), modTime: entry.ModTime})
+		return nil
+	case entry.Mode&os.ModeSymlink != 0:
+		if err := ex.replace(dest); err != nil {
+			return err
+		}
+		if err := os.Symlink(entry.Linkname, dest); err != nil {
+			sugar.Warnf("Failed to create symlink %s -> %s: %v", dest, entry.Linkname, err)
+		}
+		return nil
+	case entry.Mode.IsRegular():
+		return ex.writeFile(dest, entry, content)
+	default:
+		sugar.Debugf("Skipping special file: %s (%s)", entry.Name, entry.Mode.Type())
+		return nil
+	}
+}
+
+// link recreates a tar hard link to an earlier entry
+func (ex *extractor) link(entry ArchiveEntry) error {
+	ex.stats.Entries++
+	if ex.opts.Entry != nil {
+		ex.opts.Entry(entry)
+	}
+	if ex.opts.List {
+		return nil
+	}
+
+	dest, err := ex.destination(entry.Name)
+	if err != nil {
+		return err
+	}
+	target, err := ex.destination(entry.Linkname)
+	if err != nil {
+		return err
+	}
+	if err := ex.replace(dest); err != nil {
+		return err
+	}
+	if err := os.Link(target, dest); err != nil {
+		return fmt.Errorf("failed to create hard link %s: %w", dest, err)
+	}
+	return nil
+}
+
+func (ex *extractor) writeFile(dest string, entry ArchiveEntry, content io.Reader) error {
+	if err := ex.replace(dest); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode.Perm()|0200)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+
+	buf := bufferPool.Get().([]byte)
+	_, err = io.CopyBuffer(file, content, buf)
+	bufferPool.Put(buf)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+
+	// Set before the mode, which may take away the write access this needs
+	restoreXattrs(dest, entry.Xattrs)
+
+	// The umask may have narrowed the mode, and write access was added above
+	if err := os.Chmod(dest, entry.Mode.Perm()); err != nil {
+		sugar.Debugf("Failed to set mode of %s: %v", dest, err)
+	}
+	if err := os.Chtimes(dest, entry.ModTime, entry.ModTime); err != nil {
+		sugar.Debugf("Failed to set modification time of %s: %v", dest, err)
+	}
+	return nil
+}
+
+// replace removes an existing non-directory at dest, so a symlink left there
+// is never written through
+func (ex *extractor) replace(dest string) error {
+	info, err := os.Lstat(dest)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	if err := os.Remove(dest); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", dest, err)
+	}
+	return nil
+}
+
+// destination maps an entry name to a path below the target, creating its
+// parent directories. Names that are absolute, climb out with "..", or lead
+// through a symlink pointing outside the target are refused.
+func (ex *extractor) destination(name string) (string, error) {
+	rel := filepath.FromSlash(name)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("refusing to restore %s: path is outside the target directory", name)
+	}
+	dest := filepath.Join(ex.root, rel)
+
+	parent := filepath.Dir(dest)
+	if ex.safeDirs[parent] {
+		return dest, nil
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", parent, err)
+	}
+	resolved, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", parent, err)
+	}
+	if resolved != ex.root && !strings.HasPrefix(resolved, ex.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to restore %s: a symlink leads outside the target directory", name)
+	}
+	if ex.safeDirs == nil {
+		ex.safeDirs = make(map[string]bool)
+	}
+	ex.safeDirs[parent] = true
+	return dest, nil
+}
+
+// finishDirs applies directory modes and times, deepest first so setting a
+// parent's time is not undone by changes to its children
+func (ex *extractor) finishDirs() {
+	for i := len(ex.dirs) - 1; i >= 0; i-- {
+		dir := ex.dirs[i]
+		if err := os.Chmod(dir.path, dir.mode); err != nil {
+			sugar.Debugf("Failed to set mode of %s: %v", dir.path, err)
+		}
+		if err := os.Chtimes(dir.path, dir.modTime, dir.modTime); err != nil {
+			sugar.Debugf("Failed to set modification time of %s: %v", dir.path, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"backup-home/internal/platform"
+)
+
+// rootIgnore holds the patterns of the ignore file at the root of a source
+type rootIgnore struct {
+	excludes []string
+	includes []string
+}
+
+// withRootIgnores reads the IgnoreFileName file at the root of each source
+// of opts, whose lines are exclude patterns like those of an excludes file,
+// merged with the other excludes of that source. With opts.UseIgnoreFiles the
+// file is applied with gitignore semantics instead, and with
+// opts.IgnoreExcludes it is not read at all.
+func withRootIgnores(opts Options) Options {
+	if opts.UseIgnoreFiles || opts.IgnoreExcludes {
+		return opts
+	}
+	opts.rootIgnores = make(map[string]rootIgnore)
+	for _, source := range opts.sourceDirs() {
+		if ignore, ok := loadRootIgnore(source); ok {
+			opts.rootIgnores[source] = ignore
+		}
+	}
+	return opts
+}
+
+// loadRootIgnore reads the ignore file at the root of source, reporting false
+// when there is none
+func loadRootIgnore(source string) (rootIgnore, bool) {
+	ignorePath := filepath.Join(source, IgnoreFileName)
+	file, err := os.Open(ignorePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			sugar.Warnf("Failed to read ignore file %s: %v", ignorePath, err)
+		}
+		return rootIgnore{}, false
+	}
+	defer file.Close()
+
+	var ignore rootIgnore
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if include, ok := strings.CutPrefix(line, "!"); ok {
+			if include = strings.TrimSpace(include); include != "" {
+				ignore.includes = append(ignore.includes, platform.NormalizePattern(include))
+			}
+			continue
+		}
+		ignore.excludes = append(ignore.excludes, platform.NormalizePattern(line))
+	}
+	if err := scanner.Err(); err != nil {
+		sugar.Warnf("Failed to read ignore file %s: %v", ignorePath, err)
+	}
+
+	sugar.Debugf("Using ignore file: %s (%d patterns)", ignorePath, len(ignore.excludes)+len(ignore.includes))
+	return ignore, true
+}
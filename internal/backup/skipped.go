@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SkippedExtension is appended to the archive path to name the list of
+// source paths left out because of errors, written with Options.SkippedList
+const SkippedExtension = ".skipped.txt"
+
+// SkippedFile is a source path left out of the archive because it could not
+// be read
+type SkippedFile struct {
+	Path   string
+	Reason string
+}
+
+// skippedFile describes path, left out because of err
+func skippedFile(path, reason string, err error) SkippedFile {
+	return SkippedFile{Path: path, Reason: fmt.Sprintf("%s: %v", reason, err)}
+}
+
+// reportSkippedFiles logs how many paths were skipped because of errors,
+// listing them with --verbose, and writes them next to the archive when
+// opts.SkippedList is set. archivePath is empty for a streamed backup.
+func reportSkippedFiles(archivePath string, opts Options, skipped []SkippedFile) error {
+	if len(skipped) == 0 {
+		return nil
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].Path < skipped[j].Path })
+
+	if opts.Verbose {
+		sugar.Warnf("%d files or directories were skipped because of errors", len(skipped))
+	} else {
+		sugar.Warnf("%d files or directories were skipped because of errors (use --verbose to list them)", len(skipped))
+	}
+	for _, file := range skipped {
+		sugar.Debugf("Skipped: %s (%s)", file.Path, file.Reason)
+	}
+	if opts.OnSkipped != nil {
+		opts.OnSkipped(skipped)
+	}
+
+	if !opts.SkippedList || archivePath == "" {
+		return nil
+	}
+	var list strings.Builder
+	for _, file := range skipped {
+		fmt.Fprintf(&list, "%s\t%s\n", file.Path, file.Reason)
+	}
+	listPath := archivePath + SkippedExtension
+	if err := os.WriteFile(listPath, []byte(list.String()), opts.ArchiveMode); err != nil {
+		return fmt.Errorf("failed to write skipped files list: %w", err)
+	}
+	sugar.Infof("Skipped files list: %s", listPath)
+	return nil
+}
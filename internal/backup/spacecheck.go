@@ -0,0 +1,49 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// spaceSafetyFactor is how much free space is required relative to the
+// uncompressed size of the source, as an archive of incompressible files
+// is about as large as the files plus tar headers
+const spaceSafetyFactor = 1.1
+
+// checkFreeSpace estimates the size of the source with a quick walk and
+// fails if the filesystem of backupPath has less free space than that times
+// spaceSafetyFactor, so a full disk does not leave a corrupt archive hours
+// later. With opts.LowSpaceWarning the shortfall is only a warning. Free space
+// that cannot be determined is not treated as an error.
+func checkFreeSpace(ctx context.Context, backupPath string, opts Options) error {
+	dir := filepath.Dir(backupPath)
+	free, err := freeSpace(dir)
+	if err != nil {
+		sugar.Debugf("Skipping free space check of %s: %v", dir, err)
+		return nil
+	}
+
+	stats, err := listFiles(ctx, opts, func(ListEntry) {})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		sugar.Debugf("Skipping free space check, failed to estimate the source size: %v", err)
+		return nil
+	}
+	required := uint64(float64(stats.Bytes) * spaceSafetyFactor)
+	sugar.Infof("Estimated source size: %.2f MB, free space in %s: %.2f MB",
+		float64(stats.Bytes)/1024/1024, dir, float64(free)/1024/1024)
+	if free >= required {
+		return nil
+	}
+
+	err = fmt.Errorf("not enough free space in %s: %.2f MB free, but the source holds %.2f MB and up to %.2f MB may be needed (use --no-space-check to skip this check)",
+		dir, float64(free)/1024/1024, float64(stats.Bytes)/1024/1024, float64(required)/1024/1024)
+	if opts.LowSpaceWarning {
+		sugar.Warnf("%v", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,309 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"backup-home/internal/checksum"
+)
+
+// ManifestExtension is appended to the archive name to name the manifest of a
+// split archive, which lists its parts and their sizes
+const ManifestExtension = ".parts"
+
+// manifestHeader is the first line of a manifest
+const manifestHeader = "# backup-home split archive: concatenate the parts in order"
+
+// partPath returns the path of the nth part (counting from 1) of a split archive
+func partPath(archivePath string, n int) string {
+	return fmt.Sprintf("%s.%03d", archivePath, n)
+}
+
+// archivePart is one file of a split archive
+type archivePart struct {
+	path string
+	size int64
+	// sum is the hex digest of the part when the writer hashed it
+	sum string
+}
+
+// splitWriter writes an archive as sequential parts of at most size bytes,
+// then a manifest listing them. With a checksum algorithm each part is
+// hashed as it is written.
+type splitWriter struct {
+	archivePath string
+	size        int64
+	mode        os.FileMode
+	algo        string
+	current     *os.File
+	hasher      hash.Hash
+	written     int64
+	parts       []archivePart
+}
+
+func newSplitWriter(archivePath string, size int64, mode os.FileMode, algo string) *splitWriter {
+	return &splitWriter{archivePath: archivePath, size: size, mode: mode, algo: algo}
+}
+
+func (w *splitWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.current == nil || w.written == w.size {
+			if err := w.nextPart(); err != nil {
+				return total, err
+			}
+		}
+		chunk := p
+		if remaining := w.size - w.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := w.current.Write(chunk)
+		if w.hasher != nil {
+			w.hasher.Write(chunk[:n])
+		}
+		w.written += int64(n)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// nextPart finishes the current part and starts the next one
+func (w *splitWriter) nextPart() error {
+	if err := w.finishPart(); err != nil {
+		return err
+	}
+	path := partPath(w.archivePath, len(w.parts)+1)
+	file, err := createOutputFile(path, w.mode)
+	if err != nil {
+		return fmt.Errorf("failed to create archive part: %w", err)
+	}
+	sugar.Debugf("Writing archive part: %s", path)
+	w.current = file
+	w.written = 0
+	if w.algo != "" {
+		if w.hasher, err = checksum.New(w.algo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *splitWriter) finishPart() error {
+	if w.current == nil {
+		return nil
+	}
+	path := w.current.Name()
+	err := w.current.Close()
+	w.current = nil
+	if err != nil {
+		return fmt.Errorf("failed to close archive part %s: %w", path, err)
+	}
+	part := archivePart{path: path, size: w.written}
+	if w.hasher != nil {
+		part.sum = hex.EncodeToString(w.hasher.Sum(nil))
+	}
+	w.parts = append(w.parts, part)
+	return nil
+}
+
+// Close finishes the last part and writes the manifest
+func (w *splitWriter) Close() error {
+	if w.current == nil && len(w.parts) == 0 {
+		// An empty archive still gets one part
+		if err := w.nextPart(); err != nil {
+			return err
+		}
+	}
+	if err := w.finishPart(); err != nil {
+		return err
+	}
+
+	var manifest strings.Builder
+	manifest.WriteString(manifestHeader + "\n")
+	for _, part := range w.parts {
+		fmt.Fprintf(&manifest, "%s %d\n", filepath.Base(part.path), part.size)
+	}
+	if err := os.WriteFile(w.archivePath+ManifestExtension, []byte(manifest.String()), w.mode); err != nil {
+		return fmt.Errorf("failed to write archive manifest: %w", err)
+	}
+	sugar.Infof("Archive split into %d parts of up to %.2f MB", len(w.parts), float64(w.size)/1024/1024)
+	return nil
+}
+
+// readManifest returns the parts listed in the manifest of a split archive,
+// resolved relative to the manifest's directory
+func readManifest(manifestPath string) ([]archivePart, error) {
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var parts []archivePart
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed archive manifest line: %q", line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 || fields[0] != filepath.Base(fields[0]) {
+			return nil, fmt.Errorf("malformed archive manifest line: %q", line)
+		}
+		parts = append(parts, archivePart{path: filepath.Join(filepath.Dir(manifestPath), fields[0]), size: size})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read archive manifest: %w", err)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("archive manifest lists no parts: %s", manifestPath)
+	}
+	return parts, nil
+}
+
+// ArchiveParts returns the local files an archive was written to: the archive
+// itself, or the parts of a split archive followed by its manifest. The
+// manifest path is empty when the archive is not split.
+func ArchiveParts(archivePath string) (parts []string, manifest string, err error) {
+	if _, err := os.Stat(archivePath); err == nil {
+		return []string{archivePath}, "", nil
+	}
+	manifest = archivePath + ManifestExtension
+	listed, err := readManifest(manifest)
+	if os.IsNotExist(err) {
+		return nil, "", fmt.Errorf("backup file not found: %s", archivePath)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	for _, part := range listed {
+		parts = append(parts, part.path)
+	}
+	return parts, manifest, nil
+}
+
+// ManifestParts returns the file names of the parts listed in a manifest
+func ManifestParts(manifestPath string) ([]string, error) {
+	parts, err := readManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(parts))
+	for i, part := range parts {
+		names[i] = filepath.Base(part.path)
+	}
+	return names, nil
+}
+
+// archiveFile reads an archive, which may be split into parts, as a single file
+type archiveFile struct {
+	files []*os.File
+	// offsets holds the archive offset each file starts at
+	offsets []int64
+	size    int64
+	pos     int64
+}
+
+// openArchive opens the archive at path, or the split archive whose manifest
+// is path plus ManifestExtension, checking the parts against the manifest
+func openArchive(path string) (*archiveFile, error) {
+	parts := []archivePart{{path: path, size: -1}}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		listed, manifestErr := readManifest(path + ManifestExtension)
+		if manifestErr == nil {
+			parts = listed
+		} else if !os.IsNotExist(manifestErr) {
+			return nil, manifestErr
+		}
+	}
+
+	archive := &archiveFile{}
+	for _, part := range parts {
+		file, err := os.Open(part.path)
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			archive.Close()
+			return nil, err
+		}
+		if part.size >= 0 && info.Size() != part.size {
+			file.Close()
+			archive.Close()
+			return nil, fmt.Errorf("archive part %s is %d bytes but the manifest lists %d", part.path, info.Size(), part.size)
+		}
+		archive.files = append(archive.files, file)
+		archive.offsets = append(archive.offsets, archive.size)
+		archive.size += info.Size()
+	}
+	return archive, nil
+}
+
+// Size returns the total size of the archive
+func (a *archiveFile) Size() int64 {
+	return a.size
+}
+
+func (a *archiveFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= a.size {
+		return 0, io.EOF
+	}
+	// Find the last part starting at or before off
+	i := sort.Search(len(a.offsets), func(i int) bool { return a.offsets[i] > off }) - 1
+
+	total := 0
+	for len(p) > 0 && i < len(a.files) {
+		n, err := a.files[i].ReadAt(p, off-a.offsets[i])
+		total += n
+		off += int64(n)
+		p = p[n:]
+		if err != nil && err != io.EOF {
+			return total, err
+		}
+		if len(p) > 0 {
+			i++
+		}
+	}
+	if len(p) > 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+func (a *archiveFile) Read(p []byte) (int, error) {
+	n, err := a.ReadAt(p, a.pos)
+	a.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (a *archiveFile) Close() error {
+	var firstErr error
+	for _, file := range a.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,113 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"backup-home/internal/logging"
+)
+
+func TestSplitWriter(t *testing.T) {
+	if err := logging.InitLogger(false); err != nil {
+		t.Fatal(err)
+	}
+	sugar = logging.GetSugar()
+
+	tests := []struct {
+		name     string
+		partSize int64
+		// writes are the sizes of the successive writes
+		writes    []int
+		wantParts []int64
+	}{
+		{"empty archive", 10, nil, []int64{0}},
+		{"smaller than a part", 10, []int{4}, []int64{4}},
+		{"exactly one part", 10, []int{10}, []int64{10}},
+		{"writes filling parts exactly", 10, []int{10, 10}, []int64{10, 10}},
+		{"write straddling a boundary", 10, []int{7, 7}, []int64{10, 4}},
+		{"write spanning several parts", 10, []int{3, 25}, []int64{10, 10, 8}},
+		{"one byte parts", 1, []int{2, 1}, []int64{1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archivePath := filepath.Join(t.TempDir(), "backup.tar.gz")
+			writer := newSplitWriter(archivePath, tt.partSize, 0600, "")
+			var want []byte
+			for _, size := range tt.writes {
+				data := make([]byte, size)
+				if _, err := rand.Read(data); err != nil {
+					t.Fatal(err)
+				}
+				n, err := writer.Write(data)
+				if err != nil || n != size {
+					t.Fatalf("Write(%d bytes) = %d, %v", size, n, err)
+				}
+				want = append(want, data...)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			parts, manifest, err := ArchiveParts(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest != archivePath+ManifestExtension {
+				t.Errorf("manifest = %q, want %q", manifest, archivePath+ManifestExtension)
+			}
+			if len(parts) != len(tt.wantParts) {
+				t.Fatalf("archive has %d parts, want %d", len(parts), len(tt.wantParts))
+			}
+			for i, part := range parts {
+				if part != partPath(archivePath, i+1) {
+					t.Errorf("part %d is %s, want %s", i+1, part, partPath(archivePath, i+1))
+				}
+				info, err := os.Stat(part)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() != tt.wantParts[i] {
+					t.Errorf("part %d is %d bytes, want %d", i+1, info.Size(), tt.wantParts[i])
+				}
+			}
+
+			archive, err := openArchive(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer archive.Close()
+			if archive.Size() != int64(len(want)) {
+				t.Fatalf("archive size = %d, want %d", archive.Size(), len(want))
+			}
+			got, err := io.ReadAll(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatal("reading the parts in order does not return the written data")
+			}
+
+			// Reads at every offset, including ones across part boundaries
+			for off := 0; off < len(want); off++ {
+				for _, size := range []int{1, 3, int(tt.partSize) + 2} {
+					buf := make([]byte, size)
+					n, err := archive.ReadAt(buf, int64(off))
+					end := min(off+size, len(want))
+					if n != end-off || !bytes.Equal(buf[:n], want[off:end]) {
+						t.Fatalf("ReadAt(%d bytes at %d) = %d bytes, want %d matching", size, off, n, end-off)
+					}
+					if end-off < size && err != io.EOF {
+						t.Fatalf("short ReadAt at %d returned %v, want io.EOF", off, err)
+					}
+					if end-off == size && err != nil {
+						t.Fatalf("ReadAt(%d bytes at %d): %v", size, off, err)
+					}
+				}
+			}
+		})
+	}
+}
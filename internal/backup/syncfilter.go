@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"backup-home/internal/logging"
+)
+
+// SyncFilterRules translates the exclude and include patterns of opts into
+// rclone filter rules ("+ pattern" or "- pattern") for mirroring the source
+// instead of archiving it. Includes come first so they take precedence, as in
+// the archivers. Each pattern also covers everything below a matching
+// directory. The ignore file at the root of the source is read here too.
+func SyncFilterRules(opts Options) []string {
+	if opts.IgnoreExcludes {
+		return nil
+	}
+	sugar = logging.GetSugar()
+	opts = withRootIgnores(opts)
+	var rules []string
+	for _, include := range getIncludePatterns(opts) {
+		pattern := rclonePattern(include)
+		rules = append(rules, "+ "+pattern, "+ "+pattern+"/**")
+	}
+	for _, exclude := range getExcludePatterns(opts) {
+		pattern := rclonePattern(exclude)
+		rules = append(rules, "- "+pattern, "- "+pattern+"/**")
+	}
+	return rules
+}
+
+// rclonePattern converts an exclude pattern into rclone's glob syntax, which
+// shares * and ** with it. A "./" pattern is anchored at the source root,
+// except that a leading "./**/" becomes an unanchored rclone pattern, which
+// matches at any depth including the root; a Windows pattern without a
+// directory part matches names at any depth.
+func rclonePattern(pattern string) string {
+	pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+	if runtime.GOOS == "windows" {
+		if strings.Contains(pattern, "/") {
+			return "/" + strings.TrimPrefix(pattern, "/")
+		}
+		return pattern
+	}
+	pattern = strings.TrimPrefix(pattern, ".")
+	if strings.HasPrefix(pattern, "/**/") {
+		return strings.TrimPrefix(pattern, "/**/")
+	}
+	return pattern
+}
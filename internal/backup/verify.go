@@ -0,0 +1,217 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"backup-home/internal/crypt"
+	"backup-home/internal/logging"
+)
+
+// VerifyStats describes an archive checked by VerifyArchive
+type VerifyStats struct {
+	Format  string
+	Entries int
+	// Bytes is the total size of the entries read back
+	Bytes int64
+}
+
+// VerifyArchive re-reads a local archive created by CreateBackup,
+// decompressing every entry in full, so a truncated or corrupt archive is
+// found before the local copy is deleted. Zip entries are checked against
+// their stored CRC-32, and the gzip, zstd, xz and bzip2 readers detect
+// truncation and checksum mismatches. The format is detected from the file
+// contents; an encrypted archive needs its passphrase, and a split archive
+// is read from the parts listed in its manifest.
+func VerifyArchive(path, passphrase string) (VerifyStats, error) {
+	sugar = logging.GetSugar()
+	var stats VerifyStats
+
+	file, err := openArchive(path)
+	if err != nil {
+		return stats, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	buffered := bufio.NewReader(file)
+	encrypted := false
+	if header, _ := buffered.Peek(8); crypt.IsEncrypted(header) {
+		if passphrase == "" {
+			return stats, fmt.Errorf("archive is encrypted: a passphrase is required")
+		}
+		reader, err := crypt.NewReader(buffered, passphrase)
+		if err != nil {
+			return stats, err
+		}
+		buffered = bufio.NewReader(reader)
+		encrypted = true
+	}
+
+	stats.Format, err = detectStreamFormat(buffered)
+	if err != nil {
+		return stats, err
+	}
+	sugar.Infof("Verifying %s archive: %s", stats.Format, path)
+
+	switch {
+	case stats.Format == FormatZip && encrypted:
+		// Reading a zip needs random access, so decrypt it to a temp file
+		zipPath, err := decryptToTemp(buffered, path)
+		if err != nil {
+			return stats, err
+		}
+		defer os.Remove(zipPath)
+		decrypted, err := openArchive(zipPath)
+		if err != nil {
+			return stats, fmt.Errorf("failed to open decrypted archive: %w", err)
+		}
+		defer decrypted.Close()
+		stats.Entries, stats.Bytes, err = readZip(decrypted, decrypted.Size())
+	case stats.Format == FormatZip:
+		stats.Entries, stats.Bytes, err = readZip(file, file.Size())
+	default:
+		stats.Entries, stats.Bytes, err = readTar(buffered, stats.Format)
+		if err == nil && encrypted {
+			// Read to the end so the final encrypted chunk is authenticated too
+			_, err = io.Copy(io.Discard, buffered)
+		}
+	}
+	return stats, err
+}
+
+// verifyArchive re-reads the archive at path, decrypting it if needed and
+// decompressing every entry, and checks that it holds the expected number of entries
+func verifyArchive(path string, opts Options, expectedEntries int) error {
+	sugar.Infof("Verifying archive: %s", path)
+
+	file, err := openArchive(path)
+	if err != nil {
+		return fmt.Errorf("archive verification failed: failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if opts.Encrypt {
+		reader, err = crypt.NewReader(file, opts.Passphrase)
+		if err != nil {
+			return fmt.Errorf("archive verification failed: %w", err)
+		}
+	}
+	buffered := bufio.NewReader(reader)
+
+	// Trust the file contents over the requested format
+	detected, err := detectStreamFormat(buffered)
+	if err != nil {
+		return fmt.Errorf("archive verification failed: %w", err)
+	}
+	if detected != opts.Format {
+		return fmt.Errorf("archive verification failed: expected %s archive but found %s", opts.Format, detected)
+	}
+
+	var entries int
+	switch {
+	case opts.Format == FormatZip && opts.Encrypt:
+		// Reading a zip needs random access, so an encrypted one is only
+		// checked for successful decryption
+		if _, err := io.Copy(io.Discard, buffered); err != nil {
+			return fmt.Errorf("archive verification failed: %w", err)
+		}
+		sugar.Infof("Archive verified: decrypted successfully (zip entries are not checked when encrypted)")
+		return nil
+	case opts.Format == FormatZip:
+		entries, err = verifyZip(file, file.Size())
+	default:
+		entries, err = verifyTar(buffered, opts.Format)
+		if err == nil && opts.Encrypt {
+			// Read to the end so the final encrypted chunk is authenticated too
+			_, err = io.Copy(io.Discard, buffered)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("archive verification failed: %w", err)
+	}
+
+	if entries != expectedEntries {
+		return fmt.Errorf("archive verification failed: found %d entries, expected %d", entries, expectedEntries)
+	}
+
+	sugar.Infof("Archive verified: %d entries decompressed successfully", entries)
+	return nil
+}
+
+// verifyTar streams a compressed tar archive and returns its entry count
+func verifyTar(r io.Reader, format string) (int, error) {
+	entries, _, err := readTar(r, format)
+	return entries, err
+}
+
+// readTar streams a compressed tar archive, reading every entry in full, and
+// returns the entry count and content size
+func readTar(r io.Reader, format string) (int, int64, error) {
+	decompressor, err := tarCodecs[format].newReader(r)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create %s reader: %w", format, err)
+	}
+	defer decompressor.Close()
+
+	tarReader := tar.NewReader(decompressor)
+	buf := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buf)
+
+	entries := 0
+	var bytes int64
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, bytes, fmt.Errorf("failed to read tar entry %d: %w", entries+1, err)
+		}
+		n, err := io.CopyBuffer(io.Discard, tarReader, buf)
+		bytes += n
+		if err != nil {
+			return entries, bytes, fmt.Errorf("failed to read content of %s: %w", header.Name, err)
+		}
+		entries++
+	}
+	return entries, bytes, nil
+}
+
+// verifyZip reads every entry of a zip archive, which also checks CRC-32
+// checksums, and returns the entry count
+func verifyZip(r io.ReaderAt, size int64) (int, error) {
+	entries, _, err := readZip(r, size)
+	return entries, err
+}
+
+// readZip reads every entry of a zip archive in full, checking CRC-32
+// checksums, and returns the entry count and content size
+func readZip(r io.ReaderAt, size int64) (int, int64, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	buf := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buf)
+
+	var bytes int64
+	for i, entry := range zipReader.File {
+		reader, err := entry.Open()
+		if err != nil {
+			return i, bytes, fmt.Errorf("failed to open %s: %w", entry.Name, err)
+		}
+		n, err := io.CopyBuffer(io.Discard, reader, buf)
+		reader.Close()
+		bytes += n
+		if err != nil {
+			return i, bytes, fmt.Errorf("failed to read content of %s: %w", entry.Name, err)
+		}
+	}
+	return len(zipReader.File), bytes, nil
+}
@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// forEachSource calls fn for each source directory of opts in turn, with opts
+// narrowed to that directory and the prefix of its archive entries: none for
+// a single source, or its name when there are several. It stops at the first
+// error.
+func forEachSource(opts Options, fn func(opts Options, prefix string) error) error {
+	if len(opts.Sources) == 0 {
+		return fn(opts, "")
+	}
+	for _, source := range opts.Sources {
+		sourceOpts := opts
+		sourceOpts.Source, sourceOpts.Sources = source, nil
+		if err := fn(sourceOpts, sourceName(source)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceName returns the top-level directory a source is archived under
+// when there are several, its base name; empty for a filesystem root
+func sourceName(source string) string {
+	name := filepath.Base(filepath.Clean(source))
+	if name == "." || name == string(filepath.Separator) || strings.HasSuffix(name, ":") {
+		return ""
+	}
+	return name
+}
+
+// entryName returns the archive name of relPath below a source whose entries
+// are prefixed with prefix
+func entryName(prefix, relPath string) string {
+	if prefix == "" {
+		return relPath
+	}
+	return filepath.Join(prefix, relPath)
+}
+
+// walkSource walks opts.Source like filepath.Walk, or only the paths listed
+// in opts.FilesFrom when set. With opts.FollowSymlinks
+// a symlink is reported with the info of its target, and a symlinked
+// directory is descended into under the link's own path, so reading a
+// reported path follows the link. A link whose target cannot be resolved,
+// or that leads back into a directory being walked, is reported as a link.
+// The walk stops with ctx's error once ctx is canceled.
+func walkSource(ctx context.Context, opts Options, walkFn filepath.WalkFunc) error {
+	fn := func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return walkFn(path, info, err)
+	}
+	for _, root := range walkRoots(opts) {
+		if err := walkRoot(root, opts.FollowSymlinks, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkRoot walks the tree at root, following symlinks if requested
+func walkRoot(root string, followSymlinks bool, fn filepath.WalkFunc) error {
+	if !followSymlinks {
+		return filepath.Walk(root, fn)
+	}
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return filepath.Walk(root, fn)
+	}
+	w := &symlinkWalker{fn: fn}
+	return w.walk(root, real)
+}
+
+// symlinkWalker follows symlinks while walking
+type symlinkWalker struct {
+	fn filepath.WalkFunc
+	// active holds the resolved roots of the walks in progress, the source
+	// and every followed directory link leading to the current path
+	active []string
+}
+
+// walk walks the real directory, reporting its paths below shown instead
+func (w *symlinkWalker) walk(shown, real string) error {
+	w.active = append(w.active, real)
+	defer func() { w.active = w.active[:len(w.active)-1] }()
+
+	return filepath.Walk(real, func(path string, info os.FileInfo, err error) error {
+		shownPath := shown + strings.TrimPrefix(path, real)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return w.fn(shownPath, info, err)
+		}
+
+		target, statErr := os.Stat(path)
+		if statErr != nil {
+			sugar.Debugf("Not following broken symlink %s: %v", shownPath, statErr)
+			return w.fn(shownPath, info, nil)
+		}
+		if !target.IsDir() {
+			return w.fn(shownPath, target, nil)
+		}
+
+		realTarget, evalErr := filepath.EvalSymlinks(path)
+		if evalErr != nil {
+			return w.fn(shownPath, info, nil)
+		}
+		if w.loops(path, realTarget) {
+			sugar.Debugf("Not following symlink %s: it leads back to %s, which is already being archived", shownPath, realTarget)
+			return w.fn(shownPath, info, nil)
+		}
+		err = w.walk(shownPath, realTarget)
+		if err == filepath.SkipDir {
+			// The link itself was excluded
+			return nil
+		}
+		return err
+	})
+}
+
+// loops reports whether following a directory link at path to realTarget
+// would walk a directory that contains the link or one being walked
+func (w *symlinkWalker) loops(path, realTarget string) bool {
+	if isWithin(path, realTarget) {
+		return true
+	}
+	for _, root := range w.active {
+		if isWithin(root, realTarget) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin reports whether path is dir or below it
+func isWithin(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
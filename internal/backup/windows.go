@@ -1,224 +1,19 @@
 package backup
 
 import (
-	"archive/zip"
-	"bufio"
-	"fmt"
-	"io"
-	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
-	"sync"
-	"time"
-
-	"backup-home/internal/logging"
-	"backup-home/internal/platform"
-
-	"github.com/klauspost/compress/zstd"
 )
 
-var bufferPool = sync.Pool{
-	New: func() interface{} {
-		return make([]byte, 32*1024) // 32KB buffers
-	},
-}
-
-func createWindowsArchive(source, backupPath string, compressionLevel int, verbose bool, ignoreExcludes bool, skipOnError bool) error {
-	// Initialize logger (this is safe to call multiple times)
-	if err := logging.InitLogger(verbose); err != nil {
-		return fmt.Errorf("failed to initialize logger: %w", err)
-	}
-
-	// Get the sugar reference for this package
-	sugar = logging.GetSugar()
-	outFile, err := os.Create(backupPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer outFile.Close()
-
-	// Create a buffered writer to improve I/O performance
-	bufferedWriter := bufio.NewWriterSize(outFile, 1024*1024) // 1MB buffer
-	defer bufferedWriter.Flush()
-
-	// Create a new zip archive
-	zipWriter := zip.NewWriter(bufferedWriter)
-	defer zipWriter.Close()
-
-	// Configure compression
-	zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
-		return zstd.NewWriter(out,
-			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compressionLevel)),
-			zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0)),
-			zstd.WithWindowSize(32*1024*1024),
-			zstd.WithZeroFrames(true),
-		)
-	})
-
-	// Create worker pool for parallel processing
-	numWorkers := runtime.GOMAXPROCS(0)
-	filesChan := make(chan *fileToProcess, numWorkers*2)
-	errorsChan := make(chan error, numWorkers)
-	var wg sync.WaitGroup
-
-	// Add mutex for zip writer
-	var zipMutex sync.Mutex
-
-	// Start worker goroutines
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for file := range filesChan {
-				// Lock the zip writer during file addition
-				zipMutex.Lock()
-				err := addFileToZip(zipWriter, file.path, file.info, file.relPath, skipOnError)
-				zipMutex.Unlock()
-
-				if err != nil && !skipOnError {
-					errorsChan <- err
-				}
-			}
-		}()
-	}
-
-	// Walk the directory and send files to workers
-	startTime := time.Now()
-	lastUpdate := time.Now()
-	updateInterval := 5 * time.Second
-	var totalSize int64
-
-	var excludePatterns []string
-	var displayPatterns []string
-	
-	if !ignoreExcludes {
-		excludePatterns = platform.GetExcludePatterns()
-		for _, pattern := range excludePatterns {
-			// Keep Windows backslashes for display
-			displayPatterns = append(displayPatterns, pattern)
-		}
-		sugar.Infof("Using exclude patterns: [%s]", strings.Join(displayPatterns, ", "))
-	}
-
-	go func() {
-		err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				sugar.Debugf("Error accessing path %s: %v", path, err)
-				return nil
-			}
-
-			relPath, err := filepath.Rel(source, path)
-			if err != nil {
-				return nil
-			}
-
-			if !ignoreExcludes && isExcluded(relPath, excludePatterns) {
-				if info.IsDir() {
-					sugar.Debugf("Excluding directory: %s", relPath)
-					return filepath.SkipDir
-				}
-				sugar.Debugf("Excluding file: %s", relPath)
-				return nil
-			}
-
-			if verbose {
-				sugar.Debugf("Including: %s", relPath)
-			}
-
-			if info.Mode().IsRegular() {
-				totalSize += info.Size()
-				filesChan <- &fileToProcess{
-					path:    path,
-					info:    info,
-					relPath: relPath,
-				}
-			}
-
-			// Progress update
-			if time.Since(lastUpdate) > updateInterval {
-				speed := float64(totalSize) / time.Since(startTime).Seconds() / (1024 * 1024)
-				sugar.Infof("Archive size: %.2f MB (%.2f MB/s)", float64(totalSize)/(1024*1024), speed)
-				lastUpdate = time.Now()
-			}
-
-			return nil
-		})
-		close(filesChan)
-	}()
-
-	// Wait for workers to finish
-	wg.Wait()
-	close(errorsChan)
-
-	// Check for any errors
-	for err := range errorsChan {
-		if err != nil && !skipOnError {
-			// Error already includes file path from addFileToZip
-			return fmt.Errorf("error during archiving: %w", err)
-		}
-	}
-
-	return nil
-}
-
-type fileToProcess struct {
-	path    string
-	info    os.FileInfo
-	relPath string
-}
-
-// Helper function for adding files to zip
-func addFileToZip(zipWriter *zip.Writer, path string, info os.FileInfo, relPath string, skipOnError bool) error {
-	// Create zip header
-	header, err := zip.FileInfoHeader(info)
-	if err != nil {
-		if skipOnError {
-			sugar.Warnf("Skipping file due to header creation error: %s (%v)", path, err)
-			return nil
-		}
-		return fmt.Errorf("failed to create zip header for %s: %w", path, err)
-	}
-	header.Name = relPath
-	header.Method = zip.Deflate
-
-	writer, err := zipWriter.CreateHeader(header)
-	if err != nil {
-		if skipOnError {
-			sugar.Warnf("Skipping file due to header write error: %s (%v)", path, err)
-			return nil
-		}
-		return fmt.Errorf("failed to create zip entry for %s: %w", path, err)
-	}
-
-	if info.Mode().IsRegular() {
-		file, err := os.Open(path)
-		if err != nil {
-			// Instead of returning error, log it and skip the file
-			sugar.Warnf("Skipping file due to access denied: %s", path)
-			return nil
-		}
-		defer file.Close()
-
-		buf := bufferPool.Get().([]byte)
-		defer bufferPool.Put(buf)
-
-		_, err = io.CopyBuffer(writer, file, buf)
-		if err != nil {
-			// Log copy errors but include file path in error message
-			sugar.Warnf("Failed to copy file %s: %v", path, err)
-			if skipOnError {
-				return nil
-			}
-			return fmt.Errorf("failed to write file content for %s: %w", path, err)
-		}
-	}
-
-	return nil
+// isExcluded reports whether path matches one of excludePatterns
+func isExcluded(path string, excludePatterns []string) bool {
+	_, ok := windowsExclude(path, excludePatterns)
+	return ok
 }
 
-// Add this helper function
-func isExcluded(path string, excludePatterns []string) bool {
+// windowsExclude returns the first of excludePatterns matching path, using
+// the prefix and file name matching of Windows excludes
+func windowsExclude(path string, excludePatterns []string) (string, bool) {
 	// Convert Windows path to forward slashes for consistent matching
 	normalizedPath := filepath.ToSlash(path)
 
@@ -229,13 +24,20 @@ func isExcluded(path string, excludePatterns []string) bool {
 		// Check if the path starts with or matches the pattern
 		if strings.HasPrefix(normalizedPath, normalizedPattern) ||
 			strings.Contains(normalizedPath, "/"+normalizedPattern) {
-			return true
+			return pattern, true
 		}
 
 		// Try matching with wildcard patterns
 		if matched, _ := filepath.Match(normalizedPattern, normalizedPath); matched {
-			return true
+			return pattern, true
+		}
+
+		// Patterns without a directory part (e.g. "*.iso") also match file names at any depth
+		if !strings.Contains(normalizedPattern, "/") {
+			if matched, _ := filepath.Match(strings.ToLower(normalizedPattern), strings.ToLower(filepath.Base(normalizedPath))); matched {
+				return pattern, true
+			}
 		}
 	}
-	return false
+	return "", false
 }
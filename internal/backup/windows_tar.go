@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"backup-home/internal/logging"
+	"backup-home/internal/progress"
+)
+
+// createWindowsTarArchive writes a gzip-compressed tar archive using the
+// Windows exclude matching, so a Windows backup can be restored with tar
+func createWindowsTarArchive(ctx context.Context, out io.Writer, opts Options) (archiveStats, error) {
+	var stats archiveStats
+
+	// Initialize logger (this is safe to call multiple times)
+	if err := logging.InitLogger(opts.Verbose); err != nil {
+		return stats, fmt.Errorf("failed to initialize logger: %w", err)
+	}
+
+	// Get the sugar reference for this package
+	sugar = logging.GetSugar()
+	// Count compressed bytes for progress reporting
+	counter := &countingWriter{writer: out}
+
+	compressor, err := newTarCompressor(counter, opts)
+	if err != nil {
+		return stats, fmt.Errorf("failed to create %s writer: %w", opts.Format, err)
+	}
+
+	tarWriter := tar.NewWriter(compressor)
+
+	// Read files ahead on worker goroutines while entries are written in walk order
+	pipeline := newTarPipeline(ctx, tarWriter, opts, &stats)
+
+	startTime := time.Now()
+	lastUpdate := time.Now()
+	updateInterval := progress.Interval()
+
+	logPatterns(opts)
+	err = forEachSource(opts, func(opts Options, prefix string) error {
+		excludes := newExcludeMatcher(opts)
+		ignores := newIgnoreFiles(opts)
+		gitDirty := newGitDirty(opts)
+		return walkSource(ctx, opts, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				sugar.Debugf("Error accessing path %s: %v", path, err)
+				pipeline.skip(path, "access error", err)
+				return nil
+			}
+
+			relPath, err := filepath.Rel(opts.Source, path)
+			if err != nil {
+				return nil
+			}
+
+			if relPath == "." {
+				return nil
+			}
+
+			if pattern, excluded, descend := excludes.match(relPath, info.IsDir()); excluded {
+				if descend {
+					sugar.Debugf("Excluding directory: %s (searching it for includes)", relPath)
+					return nil
+				}
+				recordExclusion(&stats, opts, pattern, path, info)
+				if info.IsDir() {
+					sugar.Debugf("Excluding directory: %s", relPath)
+					return filepath.SkipDir
+				}
+				sugar.Debugf("Excluding file: %s", relPath)
+				return nil
+			}
+
+			if ignores.ignored(path, relPath, info.IsDir()) {
+				sugar.Debugf("Ignoring: %s (%s)", relPath, IgnoreFileName)
+				recordExclusion(&stats, opts, IgnoreFileName, path, info)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if gitDirty.skipped(path, relPath, info.IsDir()) {
+				sugar.Debugf("Skipping: %s (no uncommitted changes in git)", relPath)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			// Symlinks and junctions (e.g. "Application Data") often point back into
+			// the profile or are access-denied, so they are skipped rather than read
+			if info.Mode()&(os.ModeSymlink|os.ModeIrregular) != 0 {
+				sugar.Debugf("Skipping link or reparse point: %s", relPath)
+				return nil
+			}
+
+			if unchangedSince(info, opts) {
+				return nil
+			}
+			if excludedBySize(info, relPath, opts) {
+				return nil
+			}
+
+			if opts.Verbose {
+				sugar.Debugf("Including: %s", relPath)
+			}
+
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				if opts.SkipOnError {
+					sugar.Warnf("Skipping file due to header creation error: %s (%v)", path, err)
+					pipeline.skip(path, "header creation error", err)
+					return nil
+				}
+				return fmt.Errorf("failed to create tar header for %s: %w", path, err)
+			}
+			header.Name = filepath.ToSlash(entryName(prefix, relPath))
+			header.Mode = windowsTarMode(info)
+
+			if err := pipeline.submit(path, header); err != nil {
+				return err
+			}
+
+			// Progress reporting
+			if time.Since(lastUpdate) >= updateInterval {
+				sizeMB := float64(counter.Count()) / 1024 / 1024
+				sugar.Infof("Archive size: %.2f MB (%.2f MB/s)", sizeMB, sizeMB/time.Since(startTime).Seconds())
+				progress.Report(progress.TypeProgress, progress.PhaseArchive, counter.Count(), 0, startTime)
+				lastUpdate = time.Now()
+			}
+
+			return nil
+		})
+	})
+	if closeErr := pipeline.close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		err = fmt.Errorf("failed to walk directory: %w", err)
+	}
+	if closeErr := closeArchiveWriters(tarWriter.Close, compressor.Close); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to finish archive: %w", closeErr)
+	}
+	return stats, err
+}
+
+// windowsTarMode maps Windows file attributes to conventional Unix permissions,
+// since Windows only reports a read-only flag
+func windowsTarMode(info os.FileInfo) int64 {
+	if info.IsDir() {
+		return 0755
+	}
+	if info.Mode().Perm()&0200 == 0 {
+		return 0444
+	}
+	return 0644
+}
@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"archive/tar"
+	"strings"
+)
+
+// xattrPAXPrefix is the PAX record prefix GNU tar and bsdtar use for
+// extended attributes
+const xattrPAXPrefix = "SCHILY.xattr."
+
+// addXattrs stores the extended attributes of path in the PAX records of
+// header. Attributes that cannot be read are logged and left out.
+func addXattrs(header *tar.Header, path string) {
+	attrs, err := readXattrs(path)
+	if err != nil {
+		sugar.Debugf("Failed to read extended attributes of %s: %v", path, err)
+	}
+	if len(attrs) == 0 {
+		return
+	}
+	if header.PAXRecords == nil {
+		header.PAXRecords = make(map[string]string, len(attrs))
+	}
+	for name, value := range attrs {
+		header.PAXRecords[xattrPAXPrefix+name] = value
+	}
+	header.Format = tar.FormatPAX
+}
+
+// xattrsFromHeader returns the extended attributes stored in a tar header
+func xattrsFromHeader(header *tar.Header) map[string]string {
+	var attrs map[string]string
+	for key, value := range header.PAXRecords {
+		name, ok := strings.CutPrefix(key, xattrPAXPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		attrs[name] = value
+	}
+	return attrs
+}
+
+// restoreXattrs sets the extended attributes of a restored path, logging
+// the ones the target filesystem or platform refuses
+func restoreXattrs(path string, attrs map[string]string) {
+	for name, value := range attrs {
+		if err := writeXattr(path, name, value); err != nil {
+			sugar.Debugf("Failed to set extended attribute %s on %s: %v", name, path, err)
+		}
+	}
+}
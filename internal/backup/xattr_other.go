@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+
+package backup
+
+import "errors"
+
+var errXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func writeXattr(path, name, value string) error {
+	return errXattrUnsupported
+}
@@ -0,0 +1,92 @@
+//go:build linux || darwin || freebsd
+
+package backup
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of path without following a
+// symlink. On Linux only the user namespace is read, as the others need
+// privileges to restore or belong to the system (security labels, ACLs).
+func readXattrs(path string) (map[string]string, error) {
+	names, err := listXattrs(path)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	attrs := make(map[string]string, len(names))
+	for _, name := range names {
+		if runtime.GOOS == "linux" && !strings.HasPrefix(name, "user.") {
+			continue
+		}
+		value, err := getXattr(path, name)
+		if err != nil {
+			return attrs, err
+		}
+		attrs[name] = string(value)
+	}
+	return attrs, nil
+}
+
+// listXattrs returns the attribute names of path, growing the buffer if
+// attributes are added between the size query and the read
+func listXattrs(path string) ([]string, error) {
+	for {
+		size, err := unix.Llistxattr(path, nil)
+		if err != nil || size == 0 {
+			return nil, ignoreXattrUnsupported(err)
+		}
+		buf := make([]byte, size)
+		size, err = unix.Llistxattr(path, buf)
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, ignoreXattrUnsupported(err)
+		}
+
+		var names []string
+		for _, name := range bytes.Split(buf[:size], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		size, err = unix.Lgetxattr(path, name, buf)
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
+
+func writeXattr(path, name, value string) error {
+	return unix.Lsetxattr(path, name, []byte(value), 0)
+}
+
+// ignoreXattrUnsupported treats a filesystem without extended attributes
+// as a path without any
+func ignoreXattrUnsupported(err error) error {
+	if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+		return nil
+	}
+	return err
+}
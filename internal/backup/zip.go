@@ -0,0 +1,289 @@
+package backup
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"backup-home/internal/logging"
+	"backup-home/internal/progress"
+
+	"github.com/klauspost/compress/flate"
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 32*1024) // 32KB buffers
+	},
+}
+
+// createZipArchive writes a zip archive of the regular files in the source,
+// which opens with the standard unzip tools of every platform. Symlinks,
+// empty directories and permissions beyond the zip defaults are not kept.
+func createZipArchive(ctx context.Context, out io.Writer, opts Options) (archiveStats, error) {
+	var stats archiveStats
+
+	// Initialize logger (this is safe to call multiple times)
+	if err := logging.InitLogger(opts.Verbose); err != nil {
+		return stats, fmt.Errorf("failed to initialize logger: %w", err)
+	}
+
+	// Get the sugar reference for this package
+	sugar = logging.GetSugar()
+
+	// Create a buffered writer to improve I/O performance
+	bufferedWriter := bufio.NewWriterSize(out, 1024*1024) // 1MB buffer
+
+	// Create a new zip archive
+	zipWriter := zip.NewWriter(bufferedWriter)
+
+	// Configure compression. Entries are compressed with the standard Deflate
+	// method, or stored at level 0, so the archive opens in any zip tool.
+	zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, opts.CompressionLevel)
+	})
+
+	// Create worker pool for parallel processing
+	numWorkers := opts.workers()
+	if opts.Reproducible {
+		// Entries are written in the order the workers take the lock, so
+		// only a single worker keeps them in walk order
+		numWorkers = 1
+	}
+	filesChan := make(chan *fileToProcess, numWorkers*2)
+	errorsChan := make(chan error, numWorkers)
+	var wg sync.WaitGroup
+
+	// Add mutex for zip writer
+	var zipMutex sync.Mutex
+
+	// Start worker goroutines
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range filesChan {
+				// Drain the queue without archiving once the backup is canceled
+				if ctx.Err() != nil {
+					continue
+				}
+				// Lock the zip writer during file addition
+				zipMutex.Lock()
+				err := addFileToZip(zipWriter, file.path, file.info, file.relPath, opts.CompressionLevel, opts.SkipOnError, opts.Reproducible, &stats)
+				if err == nil && opts.CheckChanges {
+					stats.Files = append(stats.Files, fileRecord{path: file.path, size: file.info.Size(), modTime: file.info.ModTime()})
+				}
+				if err == nil && opts.Manifest {
+					stats.Contents = append(stats.Contents, ContentsEntry{
+						Path:    filepath.ToSlash(file.relPath),
+						Size:    file.info.Size(),
+						Mode:    file.info.Mode().String(),
+						ModTime: file.info.ModTime(),
+					})
+				}
+				zipMutex.Unlock()
+
+				if err != nil && !opts.SkipOnError {
+					errorsChan <- err
+				}
+			}
+		}()
+	}
+
+	// Walk the directory and send files to workers
+	startTime := time.Now()
+	lastUpdate := time.Now()
+	updateInterval := progress.Interval()
+	var totalSize int64
+
+	logPatterns(opts)
+	var walkErr error
+	go func() {
+		walkErr = forEachSource(opts, func(opts Options, prefix string) error {
+			excludes := newExcludeMatcher(opts)
+			ignores := newIgnoreFiles(opts)
+			gitDirty := newGitDirty(opts)
+			return walkSource(ctx, opts, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					sugar.Debugf("Error accessing path %s: %v", path, err)
+					zipMutex.Lock()
+					stats.Skipped = append(stats.Skipped, skippedFile(path, "access error", err))
+					zipMutex.Unlock()
+					return nil
+				}
+
+				relPath, err := filepath.Rel(opts.Source, path)
+				if err != nil {
+					return nil
+				}
+
+				if pattern, excluded, descend := excludes.match(relPath, info.IsDir()); excluded {
+					if descend {
+						sugar.Debugf("Excluding directory: %s (searching it for includes)", relPath)
+						return nil
+					}
+					zipMutex.Lock()
+					recordExclusion(&stats, opts, pattern, path, info)
+					zipMutex.Unlock()
+					if info.IsDir() {
+						sugar.Debugf("Excluding directory: %s", relPath)
+						return filepath.SkipDir
+					}
+					sugar.Debugf("Excluding file: %s", relPath)
+					return nil
+				}
+
+				if ignores.ignored(path, relPath, info.IsDir()) {
+					sugar.Debugf("Ignoring: %s (%s)", relPath, IgnoreFileName)
+					zipMutex.Lock()
+					recordExclusion(&stats, opts, IgnoreFileName, path, info)
+					zipMutex.Unlock()
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				if gitDirty.skipped(path, relPath, info.IsDir()) {
+					sugar.Debugf("Skipping: %s (no uncommitted changes in git)", relPath)
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				if unchangedSince(info, opts) {
+					return nil
+				}
+				if excludedBySize(info, relPath, opts) {
+					return nil
+				}
+
+				if opts.Verbose {
+					sugar.Debugf("Including: %s", relPath)
+				}
+
+				if info.Mode().IsRegular() {
+					totalSize += info.Size()
+					filesChan <- &fileToProcess{
+						path:    path,
+						info:    info,
+						relPath: entryName(prefix, relPath),
+					}
+				}
+
+				// Progress update
+				if time.Since(lastUpdate) > updateInterval {
+					speed := float64(totalSize) / time.Since(startTime).Seconds() / (1024 * 1024)
+					sugar.Infof("Archive size: %.2f MB (%.2f MB/s)", float64(totalSize)/(1024*1024), speed)
+					progress.Report(progress.TypeProgress, progress.PhaseArchive, totalSize, 0, startTime)
+					lastUpdate = time.Now()
+				}
+
+				return nil
+			})
+		})
+		close(filesChan)
+	}()
+
+	// Wait for workers to finish
+	wg.Wait()
+	close(errorsChan)
+
+	// The walk goroutine closes filesChan only after it returns
+	var err error
+	if walkErr != nil {
+		err = fmt.Errorf("failed to walk directory: %w", walkErr)
+	}
+
+	// Check for any errors
+	for workerErr := range errorsChan {
+		if workerErr != nil && !opts.SkipOnError && err == nil {
+			// Error already includes file path from addFileToZip
+			err = fmt.Errorf("error during archiving: %w", workerErr)
+		}
+	}
+
+	// The central directory is written on close, and the buffer flushed after it
+	if closeErr := closeArchiveWriters(zipWriter.Close, bufferedWriter.Flush); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to finish archive: %w", closeErr)
+	}
+	return stats, err
+}
+
+type fileToProcess struct {
+	path    string
+	info    os.FileInfo
+	relPath string
+}
+
+// Helper function for adding files to zip, stored uncompressed at level 0,
+// with a fixed modification time when reproducible is set. Written entries
+// and bytes are added to stats.
+func addFileToZip(zipWriter *zip.Writer, path string, info os.FileInfo, relPath string, level int, skipOnError, reproducible bool, stats *archiveStats) error {
+	// Create zip header
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		if skipOnError {
+			sugar.Warnf("Skipping file due to header creation error: %s (%v)", path, err)
+			stats.Skipped = append(stats.Skipped, skippedFile(path, "header creation error", err))
+			return nil
+		}
+		return fmt.Errorf("failed to create zip header for %s: %w", path, err)
+	}
+	// Zip entry names always use forward slashes
+	header.Name = filepath.ToSlash(relPath)
+	header.Method = zip.Deflate
+	if level == StoreCompressionLevel {
+		header.Method = zip.Store
+	}
+	if reproducible {
+		makeZipHeaderReproducible(header)
+	}
+
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		if skipOnError {
+			sugar.Warnf("Skipping file due to header write error: %s (%v)", path, err)
+			stats.Skipped = append(stats.Skipped, skippedFile(path, "header write error", err))
+			return nil
+		}
+		return fmt.Errorf("failed to create zip entry for %s: %w", path, err)
+	}
+	stats.Entries++
+
+	if info.Mode().IsRegular() {
+		file, err := os.Open(path)
+		if err != nil {
+			// Instead of returning error, log it and skip the file
+			sugar.Warnf("Skipping file due to access denied: %s", path)
+			stats.Skipped = append(stats.Skipped, skippedFile(path, "open error", err))
+			return nil
+		}
+		defer file.Close()
+
+		buf := bufferPool.Get().([]byte)
+		defer bufferPool.Put(buf)
+
+		written, err := io.CopyBuffer(writer, file, buf)
+		stats.Bytes += written
+		if err != nil {
+			// Log copy errors but include file path in error message
+			sugar.Warnf("Failed to copy file %s: %v", path, err)
+			if skipOnError {
+				stats.Skipped = append(stats.Skipped, skippedFile(path, "content write error", err))
+				return nil
+			}
+			return fmt.Errorf("failed to write file content for %s: %w", path, err)
+		}
+		stats.RegularFiles++
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestZipRoundTrip extracts the zip archive with archive/zip's default
+// readers, as any standard zip tool would, and compares every file
+func TestZipRoundTrip(t *testing.T) {
+	source := t.TempDir()
+	want := map[string][]byte{
+		"notes.txt":            []byte("plain text\n"),
+		"docs/report.md":       bytes.Repeat([]byte("compressible line\n"), 4096),
+		"docs/nested/data.raw": {0, 1, 2, 3, 255, 254, 253},
+		"empty":                {},
+	}
+	for name, data := range want {
+		path := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, level := range []int{StoreCompressionLevel, 6} {
+		t.Run(fmt.Sprintf("level%d", level), func(t *testing.T) {
+			opts, err := prepareOptions(Options{
+				Source:           source,
+				Format:           FormatZip,
+				CompressionLevel: level,
+				ArchiveMode:      0600,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			archivePath := filepath.Join(t.TempDir(), "backup.zip")
+			if _, err := createArchive(context.Background(), archivePath, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := zip.OpenReader(archivePath)
+			if err != nil {
+				t.Fatalf("archive/zip cannot open the archive: %v", err)
+			}
+			defer reader.Close()
+
+			got := make(map[string][]byte)
+			for _, file := range reader.File {
+				if file.Method != zip.Deflate && file.Method != zip.Store {
+					t.Errorf("%s uses compression method %d, not Deflate or Store", file.Name, file.Method)
+				}
+				rc, err := file.Open()
+				if err != nil {
+					t.Fatalf("opening %s: %v", file.Name, err)
+				}
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatalf("reading %s: %v", file.Name, err)
+				}
+				got[file.Name] = data
+			}
+			for name, data := range want {
+				if !bytes.Equal(got[name], data) {
+					t.Errorf("%s: extracted %d bytes, want %d", name, len(got[name]), len(data))
+				}
+			}
+			if len(got) != len(want) {
+				t.Errorf("archive holds %d files, want %d", len(got), len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// Supported checksum algorithms, named after their sidecar file extension
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+	BLAKE3 = "blake3"
+	// MD5 is not collision resistant, but is the hash many storage backends
+	// and NAS tools verify with
+	MD5 = "md5"
+)
+
+// DefaultAlgorithm is used when no algorithm is requested
+const DefaultAlgorithm = SHA256
+
+var algorithms = map[string]func() hash.Hash{
+	SHA256: sha256.New,
+	SHA512: sha512.New,
+	BLAKE3: func() hash.Hash { return blake3.New(32, nil) },
+	MD5:    md5.New,
+}
+
+// Algorithms returns the supported checksum algorithm names
+func Algorithms() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns a hash for the named algorithm
+func New(algo string) (hash.Hash, error) {
+	newHash, ok := algorithms[algo]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q (supported: %s)", algo, strings.Join(Algorithms(), ", "))
+	}
+	return newHash(), nil
+}
+
+// SidecarPath returns the path of the checksum file stored next to an archive
+func SidecarPath(archivePath, algo string) string {
+	return archivePath + "." + algo
+}
+
+// AlgorithmFromSidecar returns the algorithm of a checksum file from its extension
+func AlgorithmFromSidecar(sidecarPath string) (string, error) {
+	algo := strings.TrimPrefix(filepath.Ext(sidecarPath), ".")
+	if _, ok := algorithms[algo]; !ok {
+		return "", fmt.Errorf("unrecognized checksum file extension: %s", sidecarPath)
+	}
+	return algo, nil
+}
+
+// Sum hashes everything read from r and returns the hex digest
+func Sum(r io.Reader, algo string) (string, error) {
+	h, err := New(algo)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// File returns the hex digest of the file at path
+func File(path, algo string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	sum, err := Sum(file, algo)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return sum, nil
+}
+
+// WriteSidecar hashes the archive and writes its checksum file in the format
+// used by sha256sum and friends. It returns the checksum file path.
+func WriteSidecar(archivePath, algo string) (string, error) {
+	sum, err := File(archivePath, algo)
+	if err != nil {
+		return "", err
+	}
+	return WriteSidecarSum(archivePath, algo, sum)
+}
+
+// WriteSidecarSum writes the checksum file of an archive whose digest is
+// already known, e.g. because it was hashed while being written
+func WriteSidecarSum(archivePath, algo, sum string) (string, error) {
+	sidecarPath := SidecarPath(archivePath, algo)
+	if err := os.WriteFile(sidecarPath, []byte(SidecarLine(sum, filepath.Base(archivePath))), 0644); err != nil {
+		return "", fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return sidecarPath, nil
+}
+
+// SidecarLine formats a checksum file entry the way sha256sum does
+func SidecarLine(sum, name string) string {
+	return fmt.Sprintf("%s  %s\n", sum, name)
+}
+
+// ReadSidecar returns the digest and file name recorded in a checksum file
+func ReadSidecar(sidecarPath string) (sum, name string, err error) {
+	data, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read checksum file: %w", err)
+	}
+	sum, name, err = ParseSidecar(data)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", err, sidecarPath)
+	}
+	return sum, name, nil
+}
+
+// ParseSidecar returns the digest and file name in the contents of a
+// checksum file
+func ParseSidecar(data []byte) (sum, name string, err error) {
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("malformed checksum file")
+	}
+	return fields[0], strings.TrimPrefix(fields[1], "*"), nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"backup-home/internal/platform"
+
+	"github.com/mitchellh/go-homedir"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds settings loaded from the user config file
+type Config struct {
+	Presets map[string]Preset `yaml:"presets"`
+}
+
+// Preset is a user-defined exclude preset. A preset that shares its name with
+// a built-in one replaces it; listing the built-in under Extends keeps its
+// patterns and adds Excludes on top.
+type Preset struct {
+	Extends  []string `yaml:"extends"`
+	Excludes []string `yaml:"excludes"`
+}
+
+// DefaultPath returns the default config file location
+func DefaultPath() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "backup-home", "config.yaml"), nil
+}
+
+// DefaultExcludesPath returns the location of the excludes file that is used
+// when it exists and no other excludes file is given
+func DefaultExcludesPath() (string, error) {
+	configPath, err := DefaultPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "excludes.txt"), nil
+}
+
+// Load reads the config file at path. A missing file yields an empty config.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// LoadDefault reads the config file from the default location
+func LoadDefault() (*Config, error) {
+	path, err := DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return Load(path)
+}
+
+// PresetNames returns the sorted names of built-in and config-defined presets
+func (c *Config) PresetNames() []string {
+	names := platform.PresetNames()
+	for name := range c.Presets {
+		if _, ok := platform.GetPreset(name); !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PresetPatterns resolves the exclude patterns of the named presets, in order
+func (c *Config) PresetPatterns(names ...string) ([]string, error) {
+	var patterns []string
+	for _, name := range names {
+		resolved, err := c.resolvePreset(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, resolved...)
+	}
+	return patterns, nil
+}
+
+// resolvePreset expands a preset and everything it extends. A preset extending
+// its own name refers to the built-in preset it overrides.
+func (c *Config) resolvePreset(name string, chain []string) ([]string, error) {
+	for _, seen := range chain {
+		if seen == name {
+			return nil, fmt.Errorf("preset cycle detected: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+
+	preset, ok := c.Presets[name]
+	if !ok {
+		builtin, ok := platform.GetPreset(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown preset: %s (available: %s)", name, strings.Join(c.PresetNames(), ", "))
+		}
+		return builtin, nil
+	}
+
+	chain = append(chain, name)
+	var patterns []string
+	for _, parent := range preset.Extends {
+		if parent == name {
+			builtin, ok := platform.GetPreset(name)
+			if !ok {
+				return nil, fmt.Errorf("preset %s extends itself but there is no built-in preset to extend", name)
+			}
+			patterns = append(patterns, builtin...)
+			continue
+		}
+		resolved, err := c.resolvePreset(parent, chain)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, resolved...)
+	}
+	return append(patterns, preset.Excludes...), nil
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// FlagFile holds command line flag values loaded with --config, keyed by
+// flag name without the leading dashes
+type FlagFile struct {
+	Path   string
+	values map[string]interface{}
+}
+
+// LoadFlagFile reads a YAML file, or a TOML file if its name ends in .toml,
+// mapping flag names to values, e.g. "source: /home/user" or
+// "rclone = ['drive:backup']"
+func LoadFlagFile(path string) (*FlagFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values := make(map[string]interface{})
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &values)
+	} else {
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return &FlagFile{Path: path, values: values}, nil
+}
+
+// Names returns the sorted flag names set in the file
+func (f *FlagFile) Names() []string {
+	names := make([]string, 0, len(f.values))
+	for name := range f.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Values returns the value of a flag as the strings to pass to it, one per
+// occurrence: a list sets a repeatable flag once per element
+func (f *FlagFile) Values(name string) ([]string, error) {
+	switch value := f.values[name].(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, element := range value {
+			s, err := flagValue(element)
+			if err != nil {
+				return nil, fmt.Errorf("%s in %s: %w", name, f.Path, err)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		s, err := flagValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s in %s: %w", name, f.Path, err)
+		}
+		return []string{s}, nil
+	}
+}
+
+// flagValue formats a scalar read from the file as a flag argument
+func flagValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("expected a string, number or boolean")
+	case time.Time:
+		// An unquoted YAML date, e.g. for --since
+		if value.Equal(value.Truncate(24 * time.Hour)) {
+			return value.Format("2006-01-02"), nil
+		}
+		return value.Format(time.RFC3339), nil
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LastBackupPath returns the file recording when the last successful backup
+// started, next to the config file
+func LastBackupPath() (string, error) {
+	configPath, err := DefaultPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "last-backup"), nil
+}
+
+// LoadLastBackup returns the start time of the last successful backup, or
+// the zero time if none has been recorded
+func LoadLastBackup() (time.Time, error) {
+	path, err := LastBackupPath()
+	if err != nil {
+		return time.Time{}, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp in %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// SaveLastBackup records t as the start time of the last successful backup
+func SaveLastBackup(t time.Time) error {
+	path, err := LastBackupPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(t.Format(time.RFC3339Nano)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// LastContentsPath returns the copy of the contents manifest of the last
+// successful backup, next to the config file
+func LastContentsPath() (string, error) {
+	configPath, err := DefaultPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "last-manifest.json"), nil
+}
+
+// SaveLastContents keeps a copy of the contents manifest at manifestPath as
+// the one of the last successful backup
+func SaveLastContents(manifestPath string) error {
+	path, err := LastContentsPath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read contents manifest: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	// The manifest lists every path of the home directory
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
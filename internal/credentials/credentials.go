@@ -0,0 +1,85 @@
+// Package credentials stores the secrets of a backup setup in the system
+// keychain (macOS Keychain, Secret Service on Linux, Credential Manager on
+// Windows), so they stay out of the command line and shell history
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// Service is the keychain service every secret is stored under
+const Service = "backup-home"
+
+// Secrets a credential can hold
+const (
+	SSHPassword      = "ssh-password"
+	SSHKeyPassphrase = "ssh-key-passphrase"
+	S3SecretKey      = "s3-secret-key"
+	// RclonePassword decrypts an encrypted rclone configuration
+	RclonePassword = "rclone-config-pass"
+)
+
+// Fields returns the secrets a credential can hold
+func Fields() []string {
+	return []string{SSHPassword, SSHKeyPassphrase, S3SecretKey, RclonePassword}
+}
+
+// account names the keychain entry holding field of the credential name
+func account(name, field string) string {
+	return name + "/" + field
+}
+
+// Check rejects an empty credential name or an unknown field
+func Check(name, field string) error {
+	if name == "" {
+		return fmt.Errorf("credential name must not be empty")
+	}
+	if !slices.Contains(Fields(), field) {
+		return fmt.Errorf("unknown credential field %q: must be one of %s", field, strings.Join(Fields(), ", "))
+	}
+	return nil
+}
+
+// Get returns field of the credential name, or "" when it is not stored
+func Get(name, field string) (string, error) {
+	if err := Check(name, field); err != nil {
+		return "", err
+	}
+	secret, err := keyring.Get(Service, account(name, field))
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s of credential %s from the keychain: %w", field, name, err)
+	}
+	return secret, nil
+}
+
+// Set stores secret as field of the credential name, replacing any stored one
+func Set(name, field, secret string) error {
+	if err := Check(name, field); err != nil {
+		return err
+	}
+	if err := keyring.Set(Service, account(name, field), secret); err != nil {
+		return fmt.Errorf("failed to store %s of credential %s in the keychain: %w", field, name, err)
+	}
+	return nil
+}
+
+// Delete removes field of the credential name; a missing entry is not an
+// error
+func Delete(name, field string) error {
+	if err := Check(name, field); err != nil {
+		return err
+	}
+	err := keyring.Delete(Service, account(name, field))
+	if err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("failed to delete %s of credential %s from the keychain: %w", field, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Extension is appended to the file name of encrypted archives
+const Extension = ".enc"
+
+// PassphraseEnv is the environment variable read when no passphrase is given
+const PassphraseEnv = "BACKUP_HOME_PASSPHRASE"
+
+// The encrypted file starts with a self-describing header:
+//
+//	magic (8) | scrypt logN, r, p (1 each) | salt (16) | nonce prefix (7)
+//
+// followed by the plaintext split into chunks of chunkSize bytes, each sealed
+// with AES-256-GCM. A chunk's nonce is the prefix, a 4-byte chunk counter and
+// a final-chunk flag, so reordered, dropped or truncated chunks fail to decrypt.
+var magic = []byte("BHENC\x00\x00\x01")
+
+const (
+	chunkSize       = 64 * 1024
+	saltSize        = 16
+	noncePrefixSize = 7
+	keySize         = 32
+	headerSize      = 8 + 3 + saltSize + noncePrefixSize
+
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+)
+
+// ErrWrongPassphrase is returned when the first chunk fails to authenticate
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted file")
+
+// IsEncrypted reports whether header starts with the encrypted file magic
+func IsEncrypted(header []byte) bool {
+	return bytes.HasPrefix(header, magic)
+}
+
+// writer encrypts everything written to it in fixed-size chunks
+type writer struct {
+	out         io.Writer
+	aead        cipher.AEAD
+	noncePrefix []byte
+	counter     uint32
+	buf         []byte
+	sealed      []byte
+	closed      bool
+}
+
+// NewWriter writes the header to w and returns a writer that encrypts to w
+// with a key derived from passphrase. Close must be called to write the final
+// chunk.
+func NewWriter(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("encryption passphrase is empty")
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[8], header[9], header[10] = scryptLogN, scryptR, scryptP
+	if _, err := rand.Read(header[11:]); err != nil {
+		return nil, fmt.Errorf("failed to generate salt and nonce: %w", err)
+	}
+	salt := header[11 : 11+saltSize]
+	noncePrefix := header[11+saltSize:]
+
+	aead, err := newAEAD(passphrase, salt, scryptLogN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &writer{
+		out:         w,
+		aead:        aead,
+		noncePrefix: noncePrefix,
+		buf:         make([]byte, 0, chunkSize),
+		sealed:      make([]byte, 0, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encrypted writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, so the last chunk
+		// is always sealed by Close with the final flag
+		if len(w.buf) == chunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the final chunk. It does not close the underlying writer.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+func (w *writer) seal(final bool) error {
+	nonce := chunkNonce(w.noncePrefix, w.counter, final)
+	w.sealed = w.aead.Seal(w.sealed[:0], nonce, w.buf, nil)
+	if _, err := w.out.Write(w.sealed); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.counter++
+	return nil
+}
+
+// reader decrypts a stream written by writer
+type reader struct {
+	in          *bufio.Reader
+	aead        cipher.AEAD
+	noncePrefix []byte
+	counter     uint32
+	sealed      []byte
+	plain       []byte
+	pos         int
+	done        bool
+}
+
+// NewReader reads the header from r and returns a reader of the decrypted
+// content. Decryption errors, including a wrong passphrase, are returned by Read.
+func NewReader(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if !IsEncrypted(header) {
+		return nil, fmt.Errorf("not an encrypted backup-home archive")
+	}
+	salt := header[11 : 11+saltSize]
+	noncePrefix := append([]byte(nil), header[11+saltSize:]...)
+
+	aead, err := newAEAD(passphrase, salt, int(header[8]), int(header[9]), int(header[10]))
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{
+		in:          bufio.NewReaderSize(r, chunkSize+aead.Overhead()+1),
+		aead:        aead,
+		noncePrefix: noncePrefix,
+		sealed:      make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for r.pos == len(r.plain) {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// open reads and decrypts the next chunk. A chunk is final when nothing
+// follows it.
+func (r *reader) open() error {
+	n, err := io.ReadFull(r.in, r.sealed)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return fmt.Errorf("encrypted file is truncated")
+		}
+		return err
+	}
+	final := err == io.ErrUnexpectedEOF
+	if !final {
+		if _, err := r.in.Peek(1); err == io.EOF {
+			final = true
+		}
+	}
+
+	nonce := chunkNonce(r.noncePrefix, r.counter, final)
+	r.plain, err = r.aead.Open(r.sealed[:0], nonce, r.sealed[:n], nil)
+	if err != nil {
+		if r.counter == 0 {
+			return ErrWrongPassphrase
+		}
+		return fmt.Errorf("encrypted chunk %d failed to authenticate: file is corrupted or truncated", r.counter)
+	}
+	r.pos = 0
+	r.counter++
+	r.done = final
+	return nil
+}
+
+// chunkNonce builds the 12-byte GCM nonce of a chunk
+func chunkNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// newAEAD derives the AES-256 key from the passphrase with scrypt
+func newAEAD(passphrase string, salt []byte, logN, r, p int) (cipher.AEAD, error) {
+	if logN < 10 || logN > 24 {
+		return nil, fmt.Errorf("invalid scrypt parameters in encryption header")
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<logN, r, p, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,94 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// encrypt returns plain encrypted with passphrase
+func encrypt(t *testing.T, plain []byte, passphrase string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewWriter(&out, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd-sized writes cross the chunk boundaries
+	for data := plain; len(data) > 0; {
+		n := min(len(data), 10000)
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// decrypt returns the content of encrypted, decrypted with passphrase
+func decrypt(encrypted []byte, passphrase string) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(encrypted), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, chunkSize, chunkSize + 1, 3*chunkSize + 100} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			plain := randomBytes(t, size)
+			encrypted := encrypt(t, plain, "passphrase")
+			if !IsEncrypted(encrypted) {
+				t.Fatal("encrypted output does not start with the magic")
+			}
+			got, err := decrypt(encrypted, "passphrase")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("decrypted %d bytes, want the %d bytes written", len(got), len(plain))
+			}
+		})
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	encrypted := encrypt(t, randomBytes(t, 1000), "passphrase")
+	if _, err := decrypt(encrypted, "other"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("decrypting with the wrong passphrase returned %v, want %v", err, ErrWrongPassphrase)
+	}
+}
+
+func TestTruncatedAtChunkBoundary(t *testing.T) {
+	encrypted := encrypt(t, randomBytes(t, 3*chunkSize+100), "passphrase")
+	// Two whole chunks remain, neither sealed as the final one
+	sealedChunk := chunkSize + 16
+	truncated := encrypted[:headerSize+2*sealedChunk]
+	if _, err := decrypt(truncated, "passphrase"); err == nil {
+		t.Fatal("decrypting a stream truncated at a chunk boundary succeeded")
+	}
+}
+
+func TestCorruptedChunk(t *testing.T) {
+	encrypted := encrypt(t, randomBytes(t, 2*chunkSize), "passphrase")
+	encrypted[headerSize+chunkSize+100] ^= 1
+	if _, err := decrypt(encrypted, "passphrase"); err == nil {
+		t.Fatal("decrypting a stream with a flipped ciphertext byte succeeded")
+	}
+}
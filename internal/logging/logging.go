@@ -1,17 +1,39 @@
 package logging
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var (
-	sugar        *zap.SugaredLogger
-	logger       *zap.Logger
-	loggerOnce   sync.Once
-	currentLevel zap.AtomicLevel
+	sugar         *zap.SugaredLogger
+	logger        *zap.Logger
+	loggerOnce    sync.Once
+	currentLevel  zap.AtomicLevel
+	currentFormat = FormatConsole
+	// logFile, when set by SetFile, receives a copy of every entry
+	logFile       *lumberjack.Logger
+	logFileFormat string
+)
+
+// Rotation of the log file set by SetFile
+const (
+	logFileMaxSizeMB  = 10
+	logFileMaxBackups = 5
+)
+
+// Log output formats
+const (
+	// FormatConsole is human-readable output with colored levels
+	FormatConsole = "console"
+	// FormatJSON writes one JSON object per entry, for log aggregators
+	FormatJSON = "json"
 )
 
 // ANSI color codes
@@ -30,7 +52,7 @@ const (
 func getColoredLevelEncoder() zapcore.LevelEncoder {
 	return func(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
 		var levelStr string
-		
+
 		switch l {
 		case zapcore.DebugLevel:
 			levelStr = colorCyan + "DEBUG" + colorReset
@@ -49,7 +71,7 @@ func getColoredLevelEncoder() zapcore.LevelEncoder {
 		default:
 			levelStr = colorGray + l.String() + colorReset
 		}
-		
+
 		enc.AppendString(levelStr)
 	}
 }
@@ -58,8 +80,6 @@ func getColoredLevelEncoder() zapcore.LevelEncoder {
 func InitLogger(verbose bool) error {
 	var err error
 	loggerOnce.Do(func() {
-		// Create a user-friendly console logger configuration
-		config := zap.NewDevelopmentConfig()
 		currentLevel = zap.NewAtomicLevelAt(zap.InfoLevel)
 
 		// Set the log level based on verbose flag
@@ -67,15 +87,7 @@ func InitLogger(verbose bool) error {
 			currentLevel = zap.NewAtomicLevelAt(zap.DebugLevel)
 		}
 
-		config.Level = currentLevel
-		
-		// Configure custom encoder with colors
-		config.EncoderConfig.EncodeLevel = getColoredLevelEncoder()
-		config.EncoderConfig.TimeKey = "timestamp"
-		config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-		
-		// Use the same user-friendly format for both modes
-		logger, err = config.Build()
+		logger, err = buildLogger(currentFormat)
 		if err != nil {
 			return
 		}
@@ -93,6 +105,115 @@ func InitLogger(verbose bool) error {
 	return err
 }
 
+// SetFormat switches the logger to FormatConsole or FormatJSON, keeping its
+// level. Loggers obtained from GetSugar before the switch keep the old format.
+func SetFormat(format string) error {
+	if format != FormatConsole && format != FormatJSON {
+		return fmt.Errorf("unsupported log format %q (supported: %s, %s)", format, FormatConsole, FormatJSON)
+	}
+	if err := InitLogger(false); err != nil {
+		return err
+	}
+	if format == currentFormat {
+		return nil
+	}
+	return rebuildLogger(format)
+}
+
+// SetFile copies every log entry to the file at path, in format (FormatJSON,
+// or FormatConsole without colors). The file is rotated when it grows past
+// 10 MB, keeping the last 5 compressed rotations.
+func SetFile(path, format string) error {
+	if format != FormatConsole && format != FormatJSON {
+		return fmt.Errorf("unsupported log file format %q (supported: %s, %s)", format, FormatConsole, FormatJSON)
+	}
+	if err := InitLogger(false); err != nil {
+		return err
+	}
+
+	// Fail now rather than on the first write if the file cannot be written
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	file.Close()
+
+	logFile = &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    logFileMaxSizeMB,
+		MaxBackups: logFileMaxBackups,
+		Compress:   true,
+	}
+	logFileFormat = format
+	return rebuildLogger(currentFormat)
+}
+
+// rebuildLogger replaces the logger with one writing in format
+func rebuildLogger(format string) error {
+	newLogger, err := buildLogger(format)
+	if err != nil {
+		return err
+	}
+	_ = logger.Sync()
+	logger = newLogger
+	sugar = logger.Sugar()
+	currentFormat = format
+	return nil
+}
+
+// buildLogger creates a logger writing in format at currentLevel, teed to
+// the log file if one is set
+func buildLogger(format string) (*zap.Logger, error) {
+	l, err := buildStderrLogger(format)
+	if err != nil || logFile == nil {
+		return l, err
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "timestamp"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	var encoder zapcore.Encoder
+	if logFileFormat == FormatJSON {
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	} else {
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	}
+	fileCore := zapcore.NewCore(encoder, zapcore.AddSync(logFile), currentLevel)
+
+	return l.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, fileCore)
+	})), nil
+}
+
+// buildStderrLogger creates the logger writing to stderr in format
+func buildStderrLogger(format string) (*zap.Logger, error) {
+	if format == FormatJSON {
+		// Machine-readable output without sampling, so no entry is dropped
+		config := zap.NewProductionConfig()
+		config.Level = currentLevel
+		config.Sampling = nil
+		config.EncoderConfig.TimeKey = "timestamp"
+		config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		return config.Build()
+	}
+
+	// Create a user-friendly console logger configuration
+	config := zap.NewDevelopmentConfig()
+	config.Level = currentLevel
+
+	// Configure custom encoder with colors
+	config.EncoderConfig.EncodeLevel = getColoredLevelEncoder()
+	config.EncoderConfig.TimeKey = "timestamp"
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	// Use the same user-friendly format for both modes
+	return config.Build()
+}
+
 // GetLogger returns the package-level logger
 func GetLogger() *zap.Logger {
 	return logger
@@ -103,7 +224,7 @@ func GetSugar() *zap.SugaredLogger {
 	return sugar
 }
 
-// SyncLogger flushes any buffered log entries
+// SyncLogger flushes any buffered log entries, on stderr and in the log file
 func SyncLogger() {
 	if logger != nil {
 		_ = logger.Sync() // ignoring sync error as it's expected during shutdown
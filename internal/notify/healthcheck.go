@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PingTimeout bounds a healthcheck ping. It is short so an unreachable
+// monitoring service never holds up a backup.
+const PingTimeout = 10 * time.Second
+
+// maxPingBody is the most of an error sent with a failure ping, below the
+// limit healthchecks.io stores
+const maxPingBody = 10000
+
+// PingStart tells the healthcheck at checkURL that a run started, by
+// requesting checkURL/start
+func PingStart(checkURL string) error {
+	return ping(checkURL, "/start", "")
+}
+
+// PingResult tells the healthcheck at checkURL how a run ended: checkURL
+// itself on success, or checkURL/fail with the error as body when err is not
+// nil
+func PingResult(checkURL string, err error) error {
+	if err != nil {
+		body := err.Error()
+		if len(body) > maxPingBody {
+			body = body[:maxPingBody]
+		}
+		return ping(checkURL, "/fail", body)
+	}
+	return ping(checkURL, "", "")
+}
+
+// ping POSTs body to checkURL with suffix appended to its path, following
+// the healthchecks.io convention. Any status other than 2xx is an error.
+func ping(checkURL, suffix, body string) error {
+	u, err := url.Parse(checkURL)
+	if err != nil {
+		return fmt.Errorf("invalid healthcheck URL: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + suffix
+
+	ctx, cancel := context.WithTimeout(context.Background(), PingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid healthcheck URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("healthcheck ping failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("healthcheck ping failed: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Package notify reports the outcome of a backup run to a webhook, a command
+// or a result file, so unattended runs can be monitored
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Timeout bounds how long a webhook request or command may take
+const Timeout = time.Minute
+
+// Run statuses
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// Result describes a finished backup run
+type Result struct {
+	Status       string        `json:"status"`
+	Host         string        `json:"host"`
+	Source       string        `json:"source"`
+	Archive      string        `json:"archive,omitempty"`
+	Bytes        int64         `json:"bytes"`
+	Started      time.Time     `json:"started"`
+	Finished     time.Time     `json:"finished"`
+	Duration     float64       `json:"duration_seconds"`
+	Destinations []Destination `json:"destinations,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// Destination describes the transfer to one destination of a run
+type Destination struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	Duration   float64 `json:"duration_seconds"`
+	Throughput float64 `json:"bytes_per_second"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// StatusSkipped is the status of a destination that already had the backup
+const StatusSkipped = "skipped"
+
+// NewDestination describes a transfer of bytes to name that took duration and
+// ended with err, which is nil on success
+func NewDestination(name string, bytes int64, duration time.Duration, err error) Destination {
+	dest := Destination{
+		Name:     name,
+		Status:   StatusSuccess,
+		Bytes:    bytes,
+		Duration: duration.Seconds(),
+	}
+	if duration > 0 {
+		dest.Throughput = float64(bytes) / duration.Seconds()
+	}
+	if err != nil {
+		dest.Status = StatusFailure
+		dest.Error = err.Error()
+	}
+	return dest
+}
+
+// NewResult describes a run of source that started at started and ended with
+// err, which is nil on success
+func NewResult(source string, started time.Time, err error) Result {
+	host, _ := os.Hostname()
+	finished := time.Now()
+	result := Result{
+		Status:   StatusSuccess,
+		Host:     host,
+		Source:   source,
+		Started:  started,
+		Finished: finished,
+		Duration: finished.Sub(started).Seconds(),
+	}
+	if err != nil {
+		result.Status = StatusFailure
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Webhook POSTs the result as JSON to url. Any status other than 2xx is an error.
+func Webhook(url string, result Result) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid notification URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("notification request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification request failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// WriteFile writes the result as indented JSON to path, replacing the file
+// of an earlier run
+func WriteFile(path string, result Result) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write result file: %w", err)
+	}
+	return nil
+}
+
+// Command runs command with the system shell, describing the result in
+// BACKUP_HOME_* environment variables. Its output goes to stderr.
+func Command(command string, result Result) error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), Env(result)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("notification command failed: %w", err)
+	}
+	return nil
+}
+
+// Env returns the environment variables describing result for a command
+func Env(result Result) []string {
+	return []string{
+		"BACKUP_HOME_STATUS=" + result.Status,
+		"BACKUP_HOME_HOST=" + result.Host,
+		"BACKUP_HOME_SOURCE=" + result.Source,
+		"BACKUP_HOME_ARCHIVE=" + result.Archive,
+		"BACKUP_HOME_BYTES=" + strconv.FormatInt(result.Bytes, 10),
+		"BACKUP_HOME_DURATION=" + strconv.FormatFloat(result.Duration, 'f', 0, 64),
+		"BACKUP_HOME_ERROR=" + result.Error,
+	}
+}
@@ -0,0 +1,91 @@
+package platform
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Directives that may appear as the first pattern line of an excludes file
+const (
+	// AppendDirective adds the file's patterns to the built-in ones (the default)
+	AppendDirective = "@append"
+	// ReplaceDirective uses only the file's patterns
+	ReplaceDirective = "@replace"
+)
+
+// LoadExcludePatterns reads an excludes file with one pattern per line, where
+// blank lines and lines starting with # are ignored. The patterns are added to
+// the built-in platform patterns unless the first pattern line is @replace.
+// Lines starting with ! are include patterns, returned separately, that
+// re-include paths the excludes leave out.
+func LoadExcludePatterns(path string) (patterns, includes []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open excludes file: %w", err)
+	}
+	defer file.Close()
+
+	replace := false
+	first := true
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "@") {
+			if !first {
+				return nil, nil, fmt.Errorf("%s:%d: directive %s must come before any pattern", path, lineNum, line)
+			}
+			switch line {
+			case AppendDirective:
+			case ReplaceDirective:
+				replace = true
+			default:
+				return nil, nil, fmt.Errorf("%s:%d: unknown directive %s (expected %s or %s)", path, lineNum, line, AppendDirective, ReplaceDirective)
+			}
+			first = false
+			continue
+		}
+		first = false
+		if include, ok := strings.CutPrefix(line, "!"); ok {
+			if include = strings.TrimSpace(include); include == "" {
+				return nil, nil, fmt.Errorf("%s:%d: empty include pattern", path, lineNum)
+			}
+			includes = append(includes, NormalizePattern(include))
+			continue
+		}
+		patterns = append(patterns, NormalizePattern(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read excludes file: %w", err)
+	}
+
+	if replace {
+		return patterns, includes, nil
+	}
+	return append(GetExcludePatterns(), patterns...), includes, nil
+}
+
+// NormalizePattern converts a gitignore-like pattern into the form used by
+// the built-in patterns. On macOS and Linux those are relative to the source
+// and start with "./"; a pattern without a slash matches at any depth.
+func NormalizePattern(pattern string) string {
+	if runtime.GOOS == "windows" {
+		return pattern
+	}
+	switch {
+	case strings.HasPrefix(pattern, "./"):
+		return pattern
+	case strings.HasPrefix(pattern, "/"):
+		return "." + pattern
+	case strings.Contains(pattern, "/"):
+		return "./" + pattern
+	default:
+		return "./**/" + pattern
+	}
+}
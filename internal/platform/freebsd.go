@@ -0,0 +1,47 @@
+package platform
+
+// getFreeBSDExcludes returns the default exclude patterns on FreeBSD
+func getFreeBSDExcludes() []string {
+	return []string{
+		"./**/*.bin",
+		"./**/.build",
+		"./.cache",
+		"./.cargo",
+		"./compat",
+		"./**/*.core",
+		"./Downloads",
+		"./go",
+		"./**/*.la",
+		"./.linux",
+		"./.local/share/Trash",
+		"./**/node_modules",
+		"./.npm",
+		"./**/*.o",
+		"./.rustup",
+		"./**/*.so",
+		"./**/*.so.*",
+		"./**/target",
+		"./.Trash",
+		"./**/.venv",
+		"./.vscode/extensions",
+		"./**/__worktrees",
+	}
+}
+
+// getFreeBSDCommonExcludes returns trash and cache patterns for the common preset
+func getFreeBSDCommonExcludes() []string {
+	return []string{
+		"./.Trash",
+		"./.local/share/Trash",
+		"./.cache",
+		"./.mozilla/firefox/*/cache2",
+		"./.config/chromium/*/Cache",
+		"./.npm/_cacache",
+		"./.yarn/cache",
+		"./.local/share/pnpm/store",
+		"./.cargo/registry",
+		"./.gradle/caches",
+		"./.m2/repository",
+		"./go/pkg/mod",
+	}
+}
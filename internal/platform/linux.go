@@ -51,3 +51,23 @@ func getLinuxExcludes() []string {
 		"./**/__worktrees",
 	}
 }
+
+// getLinuxCommonExcludes returns trash and cache patterns for the common preset
+func getLinuxCommonExcludes() []string {
+	return []string{
+		"./.Trash",
+		"./.local/share/Trash",
+		"./.cache",
+		"./.mozilla/firefox/*/cache2",
+		"./.config/google-chrome/*/Cache",
+		"./.config/chromium/*/Cache",
+		"./.npm/_cacache",
+		"./.yarn/cache",
+		"./.local/share/pnpm/store",
+		"./.cargo/registry",
+		"./.gradle/caches",
+		"./.m2/repository",
+		"./go/pkg/mod",
+		"./.nuget/packages",
+	}
+}
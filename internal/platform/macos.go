@@ -72,3 +72,22 @@ func getMacOSExcludes() []string {
 		"./**/.DS_Store",
 	}
 }
+
+// getMacOSCommonExcludes returns trash and cache patterns for the common preset
+func getMacOSCommonExcludes() []string {
+	return []string{
+		"./.Trash",
+		"./.cache",
+		"./Library/Caches",
+		"./Library/Application Support/Google/Chrome/*/Cache",
+		"./Library/Application Support/Firefox/Profiles/*/cache2",
+		"./.npm/_cacache",
+		"./.yarn/cache",
+		"./Library/pnpm/store",
+		"./.cargo/registry",
+		"./.gradle/caches",
+		"./.m2/repository",
+		"./go/pkg/mod",
+		"./.nuget/packages",
+	}
+}
@@ -1,10 +1,15 @@
 package platform
 
 import (
+	"fmt"
 	"os"
 	"runtime"
 )
 
+// TempDirEnv is the environment variable naming the directory for temporary
+// backup files, overriding the system's temporary directory
+const TempDirEnv = "BACKUP_HOME_TMPDIR"
+
 // GetExcludePatterns returns platform-specific exclude patterns
 func GetExcludePatterns() []string {
 	switch runtime.GOOS {
@@ -14,12 +19,34 @@ func GetExcludePatterns() []string {
 		return getMacOSExcludes()
 	case "linux":
 		return getLinuxExcludes()
+	case "freebsd":
+		return getFreeBSDExcludes()
 	default:
 		return []string{}
 	}
 }
 
-// GetTempDir returns the system's temporary directory
+// GetTempDir returns the directory for temporary backup files: $BACKUP_HOME_TMPDIR
+// if set, otherwise the system's temporary directory ($TMPDIR on Unix)
 func GetTempDir() (string, error) {
-	return os.TempDir(), nil
+	dir := os.Getenv(TempDirEnv)
+	if dir == "" {
+		return os.TempDir(), nil
+	}
+	if err := CheckTempDir(dir); err != nil {
+		return "", fmt.Errorf("invalid $%s: %w", TempDirEnv, err)
+	}
+	return dir, nil
+}
+
+// CheckTempDir returns an error unless dir is an existing directory
+func CheckTempDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
 }
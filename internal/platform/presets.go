@@ -0,0 +1,108 @@
+package platform
+
+import (
+	"runtime"
+	"sort"
+)
+
+// Built-in exclude preset names
+const (
+	CommonPreset    = "common"
+	DeveloperPreset = "developer"
+	MediaPreset     = "media"
+)
+
+// builtinPresets maps preset names to their platform-specific pattern providers
+var builtinPresets = map[string]func() []string{
+	CommonPreset:    getCommonExcludes,
+	DeveloperPreset: getDeveloperExcludes,
+	MediaPreset:     getMediaExcludes,
+}
+
+// GetPreset returns the exclude patterns of a built-in preset for the current platform
+func GetPreset(name string) ([]string, bool) {
+	provider, ok := builtinPresets[name]
+	if !ok {
+		return nil, false
+	}
+	return provider(), true
+}
+
+// PresetNames returns the sorted names of all built-in presets
+func PresetNames() []string {
+	names := make([]string, 0, len(builtinPresets))
+	for name := range builtinPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getCommonExcludes returns trash, cache, browser cache and package manager
+// cache patterns for the current platform
+func getCommonExcludes() []string {
+	switch runtime.GOOS {
+	case "windows":
+		return getWindowsCommonExcludes()
+	case "darwin":
+		return getMacOSCommonExcludes()
+	case "linux":
+		return getLinuxCommonExcludes()
+	case "freebsd":
+		return getFreeBSDCommonExcludes()
+	default:
+		return []string{}
+	}
+}
+
+// getDeveloperExcludes returns dependency and build output patterns that can
+// be regenerated from source
+func getDeveloperExcludes() []string {
+	if runtime.GOOS == "windows" {
+		return []string{
+			"node_modules",
+			".venv",
+			"venv",
+			"__pycache__",
+			"target",
+			".gradle",
+			".terraform",
+			"go\\pkg",
+			".cargo\\registry",
+			".rustup",
+		}
+	}
+	return []string{
+		"./**/node_modules",
+		"./**/.venv",
+		"./**/venv",
+		"./**/__pycache__",
+		"./**/*.pyc",
+		"./**/target",
+		"./**/.build",
+		"./**/.gradle",
+		"./**/.terraform",
+		"./**/.direnv",
+		"./**/result",
+		"./go/pkg",
+		"./.cargo/registry",
+		"./.rustup",
+	}
+}
+
+// getMediaExcludes returns patterns for large media and disk image files
+func getMediaExcludes() []string {
+	extensions := []string{
+		"*.mp4", "*.mkv", "*.mov", "*.avi", "*.webm",
+		"*.mp3", "*.flac", "*.wav",
+		"*.iso", "*.dmg", "*.img", "*.vmdk", "*.qcow2",
+	}
+	if runtime.GOOS == "windows" {
+		return extensions
+	}
+	patterns := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		patterns = append(patterns, "./**/"+ext)
+	}
+	return patterns
+}
@@ -47,3 +47,26 @@ func getWindowsExcludes() []string {
 		"go",
 	}
 }
+
+// getWindowsCommonExcludes returns trash and cache patterns for the common preset
+func getWindowsCommonExcludes() []string {
+	return []string{
+		"AppData\\Local\\Temp",
+		"AppData\\Local\\CrashDumps",
+		"AppData\\Local\\Google\\Chrome\\User Data\\Default\\Cache",
+		"AppData\\Local\\Microsoft\\Edge\\User Data\\Default\\Cache",
+		"AppData\\Local\\Mozilla\\Firefox\\Profiles",
+		"AppData\\Local\\npm-cache",
+		"AppData\\Local\\Yarn\\Cache",
+		"AppData\\Local\\pnpm\\store",
+		"AppData\\Local\\pip\\Cache",
+		"AppData\\Local\\NuGet\\Cache",
+		"AppData\\Local\\go-build",
+		".cargo\\registry",
+		".gradle\\caches",
+		".m2\\repository",
+		".nuget\\packages",
+		"go\\pkg\\mod",
+		"scoop\\cache",
+	}
+}
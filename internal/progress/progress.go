@@ -0,0 +1,108 @@
+// Package progress writes machine-readable progress events as JSON Lines,
+// separate from the human-readable logs, for programs wrapping the command
+// line such as GUIs drawing progress bars
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	// TypeProgress reports how far a phase has got
+	TypeProgress = "progress"
+	// TypeDone reports that a phase finished, with its final byte count
+	TypeDone = "done"
+)
+
+// Phases of a run
+const (
+	// PhaseArchive is writing the archive, counting compressed bytes
+	PhaseArchive = "archive"
+	// PhaseUpload is sending the archive to a destination
+	PhaseUpload = "upload"
+)
+
+// Event is one line of the progress stream
+type Event struct {
+	Type string `json:"type"`
+	// Phase is what is in progress: archive, upload, download or sync
+	Phase string `json:"phase"`
+	Bytes int64  `json:"bytes"`
+	// Total is the number of bytes expected, omitted when not known
+	Total int64 `json:"total,omitempty"`
+	// Speed is the average since the phase started, in bytes per second
+	Speed float64   `json:"speed"`
+	Time  time.Time `json:"time"`
+}
+
+// DefaultInterval is how often progress is reported unless SetInterval
+// changes it
+const DefaultInterval = 5 * time.Second
+
+var (
+	mu       sync.Mutex
+	out      io.Writer
+	interval = DefaultInterval
+)
+
+// SetInterval sets how often archiving and transfers report their progress,
+// in the log and as events
+func SetInterval(d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	interval = d
+}
+
+// Interval returns how often progress is reported
+func Interval() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	return interval
+}
+
+// SetOutput makes w receive every event, one JSON object per line; nil
+// turns the events off
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// Report writes an event of type eventType for phase, which began at start,
+// when an output is set. Write errors are ignored so that a wrapper closing
+// its end does not fail the backup.
+func Report(eventType, phase string, bytes, total int64, start time.Time) {
+	ReportResumed(eventType, phase, 0, bytes, total, start)
+}
+
+// ReportResumed is Report for a transfer resumed at offset, whose bytes
+// include the offset while its speed counts only what was sent since start
+func ReportResumed(eventType, phase string, offset, bytes, total int64, start time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	if out == nil {
+		return
+	}
+
+	now := time.Now()
+	event := Event{
+		Type:  eventType,
+		Phase: strings.ToLower(phase),
+		Bytes: bytes,
+		Total: total,
+		Time:  now,
+	}
+	if elapsed := now.Sub(start).Seconds(); elapsed > 0 {
+		event.Speed = float64(bytes-offset) / elapsed
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	out.Write(append(line, '\n'))
+}
@@ -0,0 +1,109 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Policy is a grandfather-father-son retention policy: the newest backup of
+// each of the last Daily days, Weekly ISO weeks, Monthly months and Yearly
+// years is kept. Last and Within additionally keep the Last newest backups
+// and every backup dated within Within of now.
+type Policy struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+	Last    int
+	Within  time.Duration
+}
+
+// Validate rejects policies that would delete every backup
+func (p Policy) Validate() error {
+	if p.Daily < 0 || p.Weekly < 0 || p.Monthly < 0 || p.Yearly < 0 || p.Last < 0 || p.Within < 0 {
+		return fmt.Errorf("retention counts must not be negative")
+	}
+	if p.Daily == 0 && p.Weekly == 0 && p.Monthly == 0 && p.Yearly == 0 && p.Last == 0 && p.Within == 0 {
+		return fmt.Errorf("retention policy keeps nothing; set at least one of daily, weekly, monthly, yearly, last or within")
+	}
+	return nil
+}
+
+// Backup is a dated backup known by name
+type Backup struct {
+	Name string
+	Time time.Time
+}
+
+// ParseBackups turns directory names into backups using the date layout.
+// Names that do not match the layout are returned separately and should be
+// left alone.
+func ParseBackups(names []string, layout string) (backups []Backup, unmatched []string) {
+	for _, name := range names {
+		t, err := time.Parse(layout, name)
+		if err != nil {
+			unmatched = append(unmatched, name)
+			continue
+		}
+		backups = append(backups, Backup{Name: name, Time: t})
+	}
+	return backups, unmatched
+}
+
+// Apply splits backups into those the policy keeps and those to remove,
+// both sorted newest first
+func (p Policy) Apply(backups []Backup) (keep, remove []Backup) {
+	sorted := append([]Backup(nil), backups...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	kept := make([]bool, len(sorted))
+	p.mark(sorted, kept, p.Daily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	p.mark(sorted, kept, p.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	p.mark(sorted, kept, p.Monthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+	p.mark(sorted, kept, p.Yearly, func(t time.Time) string {
+		return t.Format("2006")
+	})
+	cutoff := time.Now().Add(-p.Within)
+	for i, backup := range sorted {
+		if i < p.Last || (p.Within > 0 && backup.Time.After(cutoff)) {
+			kept[i] = true
+		}
+	}
+
+	for i, backup := range sorted {
+		if kept[i] {
+			keep = append(keep, backup)
+		} else {
+			remove = append(remove, backup)
+		}
+	}
+	return keep, remove
+}
+
+// mark keeps the newest backup of each of the count most recent periods
+func (p Policy) mark(sorted []Backup, kept []bool, count int, period func(time.Time) string) {
+	last := ""
+	periods := 0
+	for i, backup := range sorted {
+		if periods >= count {
+			return
+		}
+		key := period(backup.Time)
+		if key == last {
+			continue
+		}
+		last = key
+		kept[i] = true
+		periods++
+	}
+}
@@ -0,0 +1,130 @@
+package retention
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+const testLayout = "2006-01-02T15:04"
+
+func names(backups []Backup) []string {
+	var out []string
+	for _, backup := range backups {
+		out = append(out, backup.Name)
+	}
+	return out
+}
+
+func TestApply(t *testing.T) {
+	now := time.Now().UTC()
+	ago := func(d time.Duration) string {
+		return now.Add(-d).Format(testLayout)
+	}
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name      string
+		policy    Policy
+		dirs      []string
+		keep      []string
+		remove    []string
+		unmatched []string
+	}{
+		{
+			name:   "daily keeps the newest backup of each day",
+			policy: Policy{Daily: 2},
+			dirs:   []string{"2024-05-02T01:00", "2024-05-03T08:00", "2024-05-01T12:00", "2024-05-03T10:00", "2024-05-02T23:00"},
+			keep:   []string{"2024-05-03T10:00", "2024-05-02T23:00"},
+			remove: []string{"2024-05-03T08:00", "2024-05-02T01:00", "2024-05-01T12:00"},
+		},
+		{
+			name:   "weekly keeps the newest backup of each ISO week",
+			policy: Policy{Weekly: 2},
+			dirs:   []string{"2024-05-15T00:00", "2024-05-13T00:00", "2024-05-12T00:00", "2024-05-06T00:00", "2024-04-30T00:00"},
+			keep:   []string{"2024-05-15T00:00", "2024-05-12T00:00"},
+			remove: []string{"2024-05-13T00:00", "2024-05-06T00:00", "2024-04-30T00:00"},
+		},
+		{
+			name:   "an ISO week spans the turn of the year",
+			policy: Policy{Weekly: 1},
+			dirs:   []string{"2025-01-02T00:00", "2024-12-30T00:00", "2024-12-29T00:00"},
+			keep:   []string{"2025-01-02T00:00"},
+			remove: []string{"2024-12-30T00:00", "2024-12-29T00:00"},
+		},
+		{
+			name:   "monthly keeps the newest backup of each month",
+			policy: Policy{Monthly: 2},
+			dirs:   []string{"2024-05-31T00:00", "2024-05-01T00:00", "2024-04-15T00:00", "2024-03-20T00:00"},
+			keep:   []string{"2024-05-31T00:00", "2024-04-15T00:00"},
+			remove: []string{"2024-05-01T00:00", "2024-03-20T00:00"},
+		},
+		{
+			name:   "yearly keeps the newest backup of each year",
+			policy: Policy{Yearly: 2},
+			dirs:   []string{"2024-06-01T00:00", "2024-01-01T00:00", "2023-12-31T00:00", "2022-07-01T00:00"},
+			keep:   []string{"2024-06-01T00:00", "2023-12-31T00:00"},
+			remove: []string{"2024-01-01T00:00", "2022-07-01T00:00"},
+		},
+		{
+			name:   "periods combine",
+			policy: Policy{Daily: 1, Monthly: 2, Yearly: 2},
+			dirs:   []string{"2024-05-10T00:00", "2024-05-09T00:00", "2024-04-30T00:00", "2024-03-01T00:00", "2023-11-01T00:00", "2022-01-01T00:00"},
+			keep:   []string{"2024-05-10T00:00", "2024-04-30T00:00", "2023-11-01T00:00"},
+			remove: []string{"2024-05-09T00:00", "2024-03-01T00:00", "2022-01-01T00:00"},
+		},
+		{
+			name:   "last keeps the newest backups",
+			policy: Policy{Last: 2},
+			dirs:   []string{"2024-05-01T00:00", "2024-05-01T06:00", "2024-04-01T00:00"},
+			keep:   []string{"2024-05-01T06:00", "2024-05-01T00:00"},
+			remove: []string{"2024-04-01T00:00"},
+		},
+		{
+			name:   "within keeps every backup newer than the age",
+			policy: Policy{Within: 7 * day},
+			dirs:   []string{ago(10 * day), ago(time.Hour), ago(3 * day), ago(2 * time.Hour)},
+			keep:   []string{ago(time.Hour), ago(2 * time.Hour), ago(3 * day)},
+			remove: []string{ago(10 * day)},
+		},
+		{
+			name:      "names not matching the layout are never removed",
+			policy:    Policy{Daily: 1},
+			dirs:      []string{"latest", "2024-05-02T00:00", "2024-13-01T00:00", "2024-05-01T00:00", "notes.txt", "2024-05-01"},
+			keep:      []string{"2024-05-02T00:00"},
+			remove:    []string{"2024-05-01T00:00"},
+			unmatched: []string{"latest", "2024-13-01T00:00", "notes.txt", "2024-05-01"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			backups, unmatched := ParseBackups(tt.dirs, testLayout)
+			if !slices.Equal(unmatched, tt.unmatched) {
+				t.Errorf("unmatched = %v, want %v", unmatched, tt.unmatched)
+			}
+			keep, remove := tt.policy.Apply(backups)
+			if got := names(keep); !slices.Equal(got, tt.keep) {
+				t.Errorf("keep = %v, want %v", got, tt.keep)
+			}
+			if got := names(remove); !slices.Equal(got, tt.remove) {
+				t.Errorf("remove = %v, want %v", got, tt.remove)
+			}
+			for _, name := range unmatched {
+				if slices.Contains(names(remove), name) {
+					t.Errorf("%s does not match the layout but is removed", name)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, policy := range []Policy{{}, {Daily: -1}, {Yearly: -1, Last: 3}} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("policy %+v is accepted", policy)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var snapshotDatePattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}-\d{6}`)
+
+// createAPFSSnapshot creates a Time Machine local snapshot and mounts the
+// snapshot of the volume holding source read-only. A source on another
+// filesystem, or on a volume tmutil does not snapshot, is ErrUnsupported.
+func createAPFSSnapshot(source string) (*Snapshot, error) {
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err := filepath.EvalSymlinks(source); err == nil {
+		source = resolved
+	}
+
+	if _, err := exec.LookPath("tmutil"); err != nil {
+		return nil, fmt.Errorf("%w: tmutil command not found", ErrUnsupported)
+	}
+
+	volume, fsType, err := volumeOf(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the volume of %s: %w", source, err)
+	}
+	if fsType != "apfs" {
+		return nil, fmt.Errorf("%w on %s filesystems", ErrUnsupported, fsType)
+	}
+	relPath := volumeRelPath(volume, source)
+
+	output, err := run("tmutil", "localsnapshot")
+	if err != nil {
+		return nil, err
+	}
+	date := snapshotDatePattern.FindString(output)
+	if date == "" {
+		return nil, fmt.Errorf("could not find snapshot date in tmutil output: %s", output)
+	}
+	deleteSnapshot := func() error {
+		_, err := run("tmutil", "deletelocalsnapshots", date)
+		return err
+	}
+
+	// tmutil only snapshots the volumes Time Machine backs up, which may not
+	// include an external one
+	snapshots, err := run("tmutil", "listlocalsnapshots", volume)
+	if err != nil || !strings.Contains(snapshots, date) {
+		deleteSnapshot()
+		return nil, fmt.Errorf("%w: tmutil did not snapshot volume %s", ErrUnsupported, volume)
+	}
+
+	mountPoint, err := os.MkdirTemp("", "backup-home-snapshot-")
+	if err != nil {
+		deleteSnapshot()
+		return nil, fmt.Errorf("failed to create snapshot mount point: %w", err)
+	}
+	snapshotName := fmt.Sprintf("com.apple.TimeMachine.%s.local", date)
+	if _, err := run("mount_apfs", "-o", "rdonly,nobrowse", "-s", snapshotName, volume, mountPoint); err != nil {
+		os.Remove(mountPoint)
+		deleteSnapshot()
+		return nil, err
+	}
+
+	return &Snapshot{
+		Path: filepath.Join(mountPoint, relPath),
+		release: func() error {
+			if _, err := run("umount", mountPoint); err != nil {
+				return err
+			}
+			os.Remove(mountPoint)
+			return deleteSnapshot()
+		},
+	}, nil
+}
+
+// volumeRelPath returns source relative to the root of its volume. Paths such
+// as /Users are firmlinked into the data volume at /System/Volumes/Data, so
+// they lie on it without its prefix.
+func volumeRelPath(volume, source string) string {
+	if rel, err := filepath.Rel(volume, source); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return strings.TrimPrefix(source, "/")
+}
@@ -0,0 +1,63 @@
+package snapshot
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// createBtrfsSnapshot snapshots the Btrfs subvolume containing source into a
+// read-only subvolume next to it
+func createBtrfsSnapshot(source string) (*Snapshot, error) {
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := exec.LookPath("btrfs"); err != nil {
+		return nil, fmt.Errorf("%w: btrfs command not found", ErrUnsupported)
+	}
+	fsType, err := run("stat", "-f", "-c", "%T", source)
+	if err != nil {
+		return nil, err
+	}
+	if fsType != "btrfs" {
+		return nil, fmt.Errorf("%w on %s filesystems", ErrUnsupported, fsType)
+	}
+
+	subvolume, err := btrfsSubvolume(source)
+	if err != nil {
+		return nil, err
+	}
+	relPath, err := filepath.Rel(subvolume, source)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotPath := filepath.Join(subvolume, fmt.Sprintf(".backup-home-snapshot-%d", time.Now().Unix()))
+	if _, err := run("btrfs", "subvolume", "snapshot", "-r", subvolume, snapshotPath); err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{
+		Path: filepath.Join(snapshotPath, relPath),
+		release: func() error {
+			_, err := run("btrfs", "subvolume", "delete", snapshotPath)
+			return err
+		},
+	}, nil
+}
+
+// btrfsSubvolume returns the root of the subvolume containing path by walking
+// up until btrfs recognizes a directory as a subvolume
+func btrfsSubvolume(path string) (string, error) {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if _, err := run("btrfs", "subvolume", "show", dir); err == nil {
+			return dir, nil
+		}
+		if dir == filepath.Dir(dir) {
+			return "", fmt.Errorf("no Btrfs subvolume found containing %s", path)
+		}
+	}
+}
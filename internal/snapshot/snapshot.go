@@ -0,0 +1,52 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ErrUnsupported is returned when the source cannot be snapshotted on this
+// platform or filesystem
+var ErrUnsupported = errors.New("filesystem snapshots are not supported")
+
+// Snapshot is a read-only point-in-time view of a source directory
+type Snapshot struct {
+	// Path is the source directory as seen inside the snapshot
+	Path    string
+	release func() error
+}
+
+// Create takes a filesystem snapshot of the volume holding source: an APFS
+// local snapshot on macOS, a read-only Btrfs subvolume snapshot on Linux or a
+// Volume Shadow Copy on Windows. The snapshot must be released after use.
+func Create(source string) (*Snapshot, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return createAPFSSnapshot(source)
+	case "linux":
+		return createBtrfsSnapshot(source)
+	case "windows":
+		return createVSSSnapshot(source)
+	default:
+		return nil, fmt.Errorf("%w on %s", ErrUnsupported, runtime.GOOS)
+	}
+}
+
+// Release unmounts and deletes the snapshot
+func (s *Snapshot) Release() error {
+	return s.release()
+}
+
+// run executes a command and returns its trimmed output, including the output
+// in the error when the command fails
+func run(name string, args ...string) (string, error) {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	trimmed := strings.TrimSpace(string(output))
+	if err != nil {
+		return trimmed, fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, trimmed)
+	}
+	return trimmed, nil
+}
@@ -0,0 +1,13 @@
+package snapshot
+
+import "golang.org/x/sys/unix"
+
+// volumeOf returns the mount point and filesystem type of the volume holding
+// path
+func volumeOf(path string) (mountPoint, fsType string, err error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return "", "", err
+	}
+	return unix.ByteSliceToString(stat.Mntonname[:]), unix.ByteSliceToString(stat.Fstypename[:]), nil
+}
@@ -0,0 +1,13 @@
+//go:build !darwin
+
+package snapshot
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// volumeOf is only needed for APFS snapshots, which exist on macOS alone
+func volumeOf(path string) (mountPoint, fsType string, err error) {
+	return "", "", fmt.Errorf("%w on %s", ErrUnsupported, runtime.GOOS)
+}
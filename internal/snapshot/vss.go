@@ -0,0 +1,74 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// vssCreateScript creates a shadow copy of the volume given as the first
+// argument and prints its ID and device path
+const vssCreateScript = `$result = Invoke-CimMethod -ClassName Win32_ShadowCopy -MethodName Create -Arguments @{Volume = $args[0]; Context = 'ClientAccessible'}
+if ($result.ReturnValue -ne 0) { throw "Win32_ShadowCopy.Create failed with code $($result.ReturnValue)" }
+$shadow = Get-CimInstance Win32_ShadowCopy | Where-Object ID -eq $result.ShadowID
+"$($shadow.ID) $($shadow.DeviceObject)"`
+
+// vssDeleteScript deletes the shadow copy whose ID is the first argument
+const vssDeleteScript = `Get-CimInstance Win32_ShadowCopy | Where-Object ID -eq $args[0] | Remove-CimInstance`
+
+// createVSSSnapshot creates a Volume Shadow Copy of the volume holding source
+// and links it into a temporary directory, so files locked by running
+// applications can be read. Creating shadow copies needs an elevated prompt.
+func createVSSSnapshot(source string) (*Snapshot, error) {
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+
+	drive := filepath.VolumeName(source)
+	if len(drive) != 2 || drive[1] != ':' {
+		return nil, fmt.Errorf("%w: %s is not on a local drive", ErrUnsupported, source)
+	}
+	powershell, err := exec.LookPath("powershell")
+	if err != nil {
+		return nil, fmt.Errorf("%w: powershell command not found", ErrUnsupported)
+	}
+
+	output, err := run(powershell, "-NoProfile", "-NonInteractive", "-Command", vssCreateScript, drive+`\`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shadow copy (an elevated prompt is required): %w", err)
+	}
+	id, device, ok := strings.Cut(output, " ")
+	if !ok || !strings.HasPrefix(device, `\\?\GLOBALROOT\`) {
+		return nil, fmt.Errorf("could not find shadow copy in powershell output: %s", output)
+	}
+	deleteShadow := func() error {
+		_, err := run(powershell, "-NoProfile", "-NonInteractive", "-Command", vssDeleteScript, id)
+		return err
+	}
+
+	// The shadow copy device cannot be walked by path directly, so it is
+	// reached through a directory symlink
+	linkDir, err := os.MkdirTemp("", "backup-home-snapshot-")
+	if err != nil {
+		deleteShadow()
+		return nil, fmt.Errorf("failed to create snapshot link directory: %w", err)
+	}
+	link := filepath.Join(linkDir, "volume")
+	if err := os.Symlink(device+`\`, link); err != nil {
+		os.Remove(linkDir)
+		deleteShadow()
+		return nil, fmt.Errorf("failed to link shadow copy: %w", err)
+	}
+
+	return &Snapshot{
+		Path: filepath.Join(link, strings.TrimPrefix(source, drive)),
+		release: func() error {
+			os.Remove(link)
+			os.Remove(linkDir)
+			return deleteShadow()
+		},
+	}, nil
+}
@@ -0,0 +1,186 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"backup-home/internal/logging"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"golang.org/x/time/rate"
+)
+
+// bwRangePattern matches one HH:MM-HH:MM:rate range of a bandwidth schedule
+var bwRangePattern = regexp.MustCompile(`^(\d{1,2}:\d{2})-(\d{1,2}:\d{2}):(.+)$`)
+
+// bwCheckInterval is how often an upload looks up its limit in the schedule
+const bwCheckInterval = time.Minute
+
+// ParseBwSchedule parses a bandwidth schedule of comma separated
+// HH:MM-HH:MM:rate ranges, e.g. "08:00-23:00:2M,23:00-08:00:off", into
+// rclone's timetable, where a rate is a size per second or "off". Hours no
+// range covers are unlimited. A single rate, or a timetable in rclone's
+// own --bwlimit syntax, is accepted too.
+func ParseBwSchedule(schedule string) (fs.BwTimetable, error) {
+	var timetable fs.BwTimetable
+	ranges := strings.Split(schedule, ",")
+	if !bwRangePattern.MatchString(strings.TrimSpace(ranges[0])) {
+		if err := timetable.Set(schedule); err != nil {
+			return nil, fmt.Errorf("invalid bandwidth schedule %q: %w", schedule, err)
+		}
+		return timetable, nil
+	}
+
+	// Each range starts a slot at its start time, and its end starts an
+	// unlimited one unless another range begins there
+	slots := make(map[string]string)
+	var ends []string
+	for _, r := range ranges {
+		match := bwRangePattern.FindStringSubmatch(strings.TrimSpace(r))
+		if match == nil {
+			return nil, fmt.Errorf("invalid bandwidth schedule range %q: expected HH:MM-HH:MM:rate", r)
+		}
+		start, err := bwClock(match[1])
+		if err != nil {
+			return nil, err
+		}
+		end, err := bwClock(match[2])
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := slots[start]; ok {
+			return nil, fmt.Errorf("invalid bandwidth schedule: two ranges start at %s", start)
+		}
+		slots[start] = match[3]
+		ends = append(ends, end)
+	}
+	for _, end := range ends {
+		if _, ok := slots[end]; !ok {
+			slots[end] = "off"
+		}
+	}
+
+	starts := make([]string, 0, len(slots))
+	for start := range slots {
+		starts = append(starts, start)
+	}
+	sort.Strings(starts)
+	entries := make([]string, 0, len(starts))
+	for _, start := range starts {
+		entries = append(entries, start+","+slots[start])
+	}
+	if err := timetable.Set(strings.Join(entries, " ")); err != nil {
+		return nil, fmt.Errorf("invalid bandwidth schedule %q: %w", schedule, err)
+	}
+	return timetable, nil
+}
+
+// bwClock validates a time of day and formats it as HH:MM
+func bwClock(clock string) (string, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return "", fmt.Errorf("invalid time of day %q in bandwidth schedule", clock)
+	}
+	return t.Format("15:04"), nil
+}
+
+// uploadLimitAt returns the upload bandwidth of schedule at t in bytes per
+// second, or zero when uploads are unlimited then
+func uploadLimitAt(schedule fs.BwTimetable, t time.Time) int64 {
+	if len(schedule) == 0 {
+		return 0
+	}
+	limit := schedule.LimitAt(t).Bandwidth.Tx
+	if limit <= 0 {
+		return 0
+	}
+	return int64(limit)
+}
+
+// bwLimitedReader slows reads down to the upload bandwidth of a schedule,
+// looking the limit up again every bwCheckInterval so that a long upload
+// follows the schedule
+type bwLimitedReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	schedule fs.BwTimetable
+	limiter  *rate.Limiter
+	limit    int64
+	checked  time.Time
+}
+
+// newBwLimitedReader limits r to the schedule, returning r itself when there
+// is no schedule
+func newBwLimitedReader(ctx context.Context, r io.Reader, schedule fs.BwTimetable) io.Reader {
+	if len(schedule) == 0 {
+		return r
+	}
+	return &bwLimitedReader{ctx: ctx, reader: r, schedule: schedule, limit: -1}
+}
+
+// update looks up the current limit and adjusts the limiter when it changed
+func (r *bwLimitedReader) update(now time.Time) {
+	r.checked = now
+	limit := uploadLimitAt(r.schedule, now)
+	if limit == r.limit {
+		return
+	}
+	r.limit = limit
+	if limit == 0 {
+		r.limiter = nil
+		logging.GetSugar().Infof("Bandwidth limit: off")
+		return
+	}
+	// Allow a burst of up to a second of data, and at least one full read
+	burst := int(max(limit, 32*1024))
+	r.limiter = rate.NewLimiter(rate.Limit(limit), burst)
+	logging.GetSugar().Infof("Bandwidth limit: %s/s", fs.SizeSuffix(limit).ByteUnit())
+}
+
+func (r *bwLimitedReader) Read(p []byte) (int, error) {
+	if now := time.Now(); now.Sub(r.checked) >= bwCheckInterval {
+		r.update(now)
+	}
+	if r.limiter == nil {
+		return r.reader.Read(p)
+	}
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// rcloneBwTicker starts rclone's schedule ticker once per process
+var rcloneBwTicker sync.Once
+
+// applyRcloneBwLimit makes the bandwidth schedule of config rclone's global
+// --bwlimit, once librclone is initialized. librclone may have started its
+// limiter earlier in the run, for a listing or check, without the schedule,
+// so the limit in effect now is set on the limiter directly, and rclone's
+// ticker is started to follow a schedule of several slots.
+func applyRcloneBwLimit(config RcloneConfig) {
+	if len(config.BwLimit) == 0 {
+		return
+	}
+	ctx := context.Background()
+	fs.GetConfig(ctx).BwLimit = config.BwLimit
+	accounting.TokenBucket.SetBwLimit(config.BwLimit.LimitAt(time.Now()).Bandwidth)
+	if len(config.BwLimit) > 1 {
+		rcloneBwTicker.Do(func() {
+			accounting.TokenBucket.StartTokenTicker(ctx)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package upload
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBwSchedule(t *testing.T) {
+	const (
+		k = 1024
+		m = 1024 * 1024
+	)
+	// at returns a time of day on a fixed date
+	at := func(clock string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", "2024-05-15 "+clock)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+
+	tests := []struct {
+		schedule string
+		// limits maps times of day to the upload limit then, 0 for unlimited
+		limits map[string]int64
+	}{
+		{"1M", map[string]int64{"00:00": m, "12:00": m}},
+		{"off", map[string]int64{"12:00": 0}},
+		{"08:00-23:00:2M,23:00-08:00:off", map[string]int64{"07:59": 0, "08:00": 2 * m, "22:59": 2 * m, "23:30": 0}},
+		// Hours no range covers are unlimited, also before the first range
+		{"09:00-17:00:512k", map[string]int64{"08:00": 0, "12:00": 512 * k, "17:00": 0}},
+		{"8:30-9:00:1M, 22:00-06:00:4M", map[string]int64{"08:45": m, "09:30": 0, "23:00": 4 * m, "05:00": 4 * m}},
+		// rclone's own --bwlimit timetable syntax
+		{"08:00,512k 12:00,off", map[string]int64{"10:00": 512 * k, "13:00": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			timetable, err := ParseBwSchedule(tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for clock, want := range tt.limits {
+				if got := uploadLimitAt(timetable, at(clock)); got != want {
+					t.Errorf("limit at %s = %d, want %d", clock, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseBwScheduleInvalid(t *testing.T) {
+	for _, schedule := range []string{
+		"fast",
+		"08:00-25:00:1M",
+		"08:60-09:00:1M",
+		"08:00-23:00:2M,garbage",
+		"08:00-23:00:2M,23:00-08:00",
+		"08:00-12:00:1M,08:00-10:00:2M",
+		"08:00-12:00:quick",
+	} {
+		if _, err := ParseBwSchedule(schedule); err == nil {
+			t.Errorf("ParseBwSchedule(%q) succeeded", schedule)
+		}
+	}
+}
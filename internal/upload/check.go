@@ -0,0 +1,140 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"backup-home/internal/logging"
+
+	"github.com/pkg/sftp"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/librclone/librclone"
+)
+
+// checkContent is written to the test file of a destination check
+const checkContent = "backup-home destination check\n"
+
+// checkFileName returns the name of the test file written and removed by a
+// destination check, unique to this process
+func checkFileName() string {
+	return fmt.Sprintf(".backup-home-check-%d", os.Getpid())
+}
+
+// remoteCheckCommand returns the shell command that writes and removes the
+// test file name in dir or, when dir does not exist yet, in its nearest
+// existing parent, where the upload would create it. It prints the directory
+// written to.
+func remoteCheckCommand(dir, name string) string {
+	return fmt.Sprintf(`d=%s; while [ ! -d "$d" ]; do d=$(dirname -- "$d"); done; f="$d"/%s; printf %%s %s > "$f" && rm -f -- "$f" && printf '%%s\n' "$d"`,
+		shellQuote(dir), shellQuote(name), shellQuote(checkContent))
+}
+
+// CheckSSH connects to the SSH destination of config the way its upload
+// method does and confirms the upload directory is writable by creating and
+// removing a small file, without uploading anything. A directory that does
+// not exist yet is not created; its nearest existing parent is checked.
+func CheckSSH(ctx context.Context, config SSHConfig) error {
+	sugar := logging.GetSugar()
+	dir := remoteDir(config)
+	sugar.Infof("Checking SSH destination %s@%s:%s", config.User, config.Host, dir)
+
+	var checked string
+	if config.Method == "" || config.Method == SSHMethodBinary {
+		output, err := exec.CommandContext(ctx, "ssh", sshCommandArgs(config, remoteCheckCommand(dir, checkFileName()))...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to write to %s: %w: %s", dir, err, lastLine(string(output)))
+		}
+		checked = lastLine(string(output))
+	} else {
+		sshClient, sftpClient, err := connectSFTP(ctx, config)
+		if err != nil {
+			return err
+		}
+		defer sshClient.Close()
+		defer sftpClient.Close()
+		defer closeOnCancel(ctx, sshClient)()
+
+		if checked, err = checkSFTPWrite(sftpClient, dir); err != nil {
+			return err
+		}
+	}
+
+	if checked != dir {
+		sugar.Infof("SSH destination %s is reachable; %s does not exist yet, and %s is writable", config.Host, dir, checked)
+	} else {
+		sugar.Infof("SSH destination %s is reachable and %s is writable", config.Host, dir)
+	}
+	return nil
+}
+
+// checkSFTPWrite writes and removes the test file in dir, or in its nearest
+// existing parent, and returns the directory written to
+func checkSFTPWrite(client *sftp.Client, dir string) (string, error) {
+	for {
+		if info, err := client.Stat(dir); err == nil && info.IsDir() {
+			break
+		}
+		parent := path.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no existing directory found above %s", dir)
+		}
+		dir = parent
+	}
+
+	testFile := path.Join(dir, checkFileName())
+	file, err := client.Create(testFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to write to %s: %w", dir, err)
+	}
+	_, err = io.WriteString(file, checkContent)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := client.Remove(testFile); removeErr != nil && err == nil {
+		err = fmt.Errorf("failed to remove test file %s: %w", testFile, removeErr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write to %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// CheckRclone initializes the rclone destination of config, which confirms
+// its configuration and credentials, and writes and removes a small file at
+// its root, without uploading anything
+func CheckRclone(ctx context.Context, config RcloneConfig, verbose bool) error {
+	if err := initRclone(verbose); err != nil {
+		return err
+	}
+	defer logging.SyncLogger()
+	defer librclone.Finalize()
+
+	sugar.Infof("Checking rclone destination %s", config.Destination)
+	ctx, err := withRcloneFlags(ctx, config)
+	if err != nil {
+		return err
+	}
+	f, err := fs.NewFs(ctx, config.Destination)
+	if err != nil {
+		return fmt.Errorf("failed to open rclone destination: %w", err)
+	}
+
+	name := checkFileName()
+	obj, err := operations.Rcat(ctx, f, name, io.NopCloser(strings.NewReader(checkContent)), time.Now(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to write to %s: %w", config.Destination, err)
+	}
+	if err := obj.Remove(ctx); err != nil {
+		return fmt.Errorf("failed to remove test file %s from %s: %w", name, config.Destination, err)
+	}
+
+	sugar.Infof("Rclone destination %s is reachable and writable", config.Destination)
+	return nil
+}
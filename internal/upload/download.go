@@ -0,0 +1,135 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"backup-home/internal/logging"
+
+	"github.com/rclone/rclone/librclone/librclone"
+)
+
+// statResponse is the reply of operations/stat; Item is null when nothing
+// exists at the path
+type statResponse struct {
+	Item *struct {
+		Size  int64 `json:"Size"`
+		IsDir bool  `json:"IsDir"`
+	} `json:"item"`
+}
+
+// DownloadFromSSH downloads remotePath over SFTP to localPath. A relative
+// remotePath is taken from config.RemotePath.
+func DownloadFromSSH(remotePath, localPath string, config SSHConfig) error {
+	sugar := logging.GetSugar()
+
+	if !path.IsAbs(remotePath) {
+		remotePath = path.Join(config.RemotePath, remotePath)
+	}
+	sugar.Infof("Downloading %s@%s:%s", config.User, config.Host, remotePath)
+	startTime := time.Now()
+
+	sshClient, sftpClient, err := connectSFTP(context.Background(), config)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+	defer sftpClient.Close()
+
+	remoteFile, err := sftpClient.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to open remote file %s: %w", remotePath, err)
+	}
+	defer remoteFile.Close()
+
+	fileInfo, err := remoteFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat remote file: %w", err)
+	}
+	if fileInfo.IsDir() {
+		return fmt.Errorf("%s is a directory, not a backup archive", remotePath)
+	}
+
+	localFile, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	defer localFile.Close()
+
+	progressReader := &progressReader{
+		reader:    remoteFile,
+		total:     fileInfo.Size(),
+		startTime: startTime,
+		sugar:     sugar,
+		action:    "Download",
+	}
+	if _, err := io.Copy(localFile, progressReader); err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	return localFile.Close()
+}
+
+// DownloadFromRclone copies remotePath, relative to config.Destination, to
+// localPath
+func DownloadFromRclone(remotePath, localPath string, config RcloneConfig) error {
+	sugar = logging.GetSugar()
+
+	sugar.Infof("Downloading %s from %s", remotePath, config.Destination)
+	startTime := time.Now()
+
+	startRclone()
+	defer librclone.Finalize()
+
+	// Check the file exists first, and get its size for progress reporting
+	statJSON, err := json.Marshal(remoteRequest{Fs: config.Destination, Remote: remotePath})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	out, status := librclone.RPC("operations/stat", string(statJSON))
+	if status != 0 && status != 200 {
+		return fmt.Errorf("rclone stat failed: %w", rcloneError(status, out))
+	}
+	var stat statResponse
+	if err := json.Unmarshal([]byte(out), &stat); err != nil {
+		return fmt.Errorf("failed to parse rclone stat response: %w", err)
+	}
+	if stat.Item == nil {
+		return fmt.Errorf("%s not found on %s", remotePath, config.Destination)
+	}
+	if stat.Item.IsDir {
+		return fmt.Errorf("%s is a directory, not a backup archive", remotePath)
+	}
+
+	reqJSON, err := json.Marshal(copyFileRequest{
+		SrcFs:     config.Destination,
+		SrcRemote: remotePath,
+		DstFs:     filepath.Dir(localPath),
+		DstRemote: filepath.Base(localPath),
+		Async:     true,
+		Config:    config.Flags,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	out, status = librclone.RPC("operations/copyfile", string(reqJSON))
+	if status != 0 && status != 200 {
+		return fmt.Errorf("rclone copy failed: %w", rcloneError(status, out))
+	}
+	var job jobRequest
+	if err := json.Unmarshal([]byte(out), &job); err != nil {
+		return fmt.Errorf("failed to parse rclone job response: %w", err)
+	}
+	if err := waitForRcloneJob(context.Background(), job.JobID, stat.Item.Size, startTime, "Download"); err != nil {
+		return fmt.Errorf("rclone copy failed: %w", err)
+	}
+
+	sugar.Infof("Download completed: %.2f MB", float64(stat.Item.Size)/1024/1024)
+	return nil
+}
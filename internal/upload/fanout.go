@@ -0,0 +1,72 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"backup-home/internal/logging"
+)
+
+// hostResult records the outcome of uploading to one SSH host
+type hostResult struct {
+	host     string
+	err      error
+	duration time.Duration
+}
+
+// UploadToSSHHosts uploads the same file to each host, sharing the rest of
+// config. Up to parallel uploads run at once, and each is retried up to
+// retries times on transient failures. Every host is attempted even if
+// some fail; a per-host summary is logged and an error is returned if any
+// upload failed. Hosts not yet started are skipped once ctx is canceled.
+func UploadToSSHHosts(ctx context.Context, localPath string, config SSHConfig, hosts []string, parallel, retries int, verbose bool) error {
+	sugar := logging.GetSugar()
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]hostResult, len(hosts))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, host string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			hostConfig := config
+			hostConfig.Host = host
+			startTime := time.Now()
+			sugar.Infof("Uploading to host %d of %d: %s", i+1, len(hosts), host)
+			err := RetrySSH(ctx, retries, hostConfig, func(config SSHConfig) error {
+				return UploadToSSH(ctx, localPath, config, verbose)
+			})
+			if err != nil {
+				sugar.Errorf("Upload to %s failed: %v", host, err)
+			}
+			results[i] = hostResult{host: host, err: err, duration: time.Since(startTime)}
+		}(i, host)
+	}
+	wg.Wait()
+
+	var failures []string
+	sugar.Infof("Upload summary:")
+	for _, result := range results {
+		if result.err != nil {
+			sugar.Infof("  %s: FAILED after %s (%v)", result.host, result.duration.Round(time.Second), result.err)
+			failures = append(failures, fmt.Sprintf("%s: %v", result.host, result.err))
+		} else {
+			sugar.Infof("  %s: ok (%s)", result.host, result.duration.Round(time.Second))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("upload failed for %d of %d hosts: %s", len(failures), len(hosts), strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package upload
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"backup-home/internal/logging"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyMode selects how the SSH server's host key is checked
+type HostKeyMode int
+
+const (
+	// HostKeyStrict only accepts host keys listed in known_hosts
+	HostKeyStrict HostKeyMode = iota
+	// HostKeyAcceptNew adds the key of a host missing from known_hosts on
+	// first use, but still rejects a key that differs from a known one
+	HostKeyAcceptNew
+	// HostKeyInsecure accepts any host key
+	HostKeyInsecure
+)
+
+// knownHostsMutex serializes appends to known_hosts by concurrent uploads
+var knownHostsMutex sync.Mutex
+
+// knownHostsPath returns the user's OpenSSH known_hosts file
+func knownHostsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+// hostKeyCallback returns the host key check selected by config.HostKey,
+// backed by ~/.ssh/known_hosts, and the host key algorithms to offer the
+// server, which go in ssh.ClientConfig.HostKeyAlgorithms
+func hostKeyCallback(config SSHConfig) (ssh.HostKeyCallback, []string, error) {
+	if config.HostKey == HostKeyInsecure {
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+
+	path, err := knownHostsPath()
+	if err != nil {
+		return nil, nil, err
+	}
+	return knownHostsCallback(path, config)
+}
+
+// knownHostsCallback is hostKeyCallback for the known_hosts file at path
+func knownHostsCallback(path string, config SSHConfig) (ssh.HostKeyCallback, []string, error) {
+	if config.HostKey == HostKeyAcceptNew {
+		// knownhosts.New needs the file to exist
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create known_hosts file: %w", err)
+		}
+		file.Close()
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, fmt.Errorf("%s does not exist: connect once with ssh to add the host key, or use --ssh-accept-new", path)
+		}
+		return nil, nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	algorithms, err := knownHostKeyAlgorithms(callback, sshAddr(config))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("host key for %s does not match %s, someone could be intercepting the connection: %w", hostname, path, err)
+		}
+		if config.HostKey != HostKeyAcceptNew {
+			return fmt.Errorf("host key for %s is not in %s: connect once with ssh to add it, or use --ssh-accept-new", hostname, path)
+		}
+		return addKnownHost(path, hostname, remote, key)
+	}, algorithms, nil
+}
+
+// sshAddr returns the host:port the Go SSH methods dial for config
+func sshAddr(config SSHConfig) string {
+	port := config.Port
+	if port == "" {
+		port = DefaultSSHPort
+	}
+	return net.JoinHostPort(config.Host, port)
+}
+
+// knownHostKeyAlgorithms returns the host key algorithms for the key types
+// callback knows for addr, or nil for a host it does not know. Without them
+// x/crypto/ssh asks for ECDSA or RSA first, so a host recorded only with its
+// ed25519 key, as OpenSSH usually does, would present a key known_hosts does
+// not list and be rejected as if its key had changed.
+func knownHostKeyAlgorithms(callback ssh.HostKeyCallback, addr string) ([]string, error) {
+	// Any key not in known_hosts makes the check list the ones that are
+	_, probe, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key probe: %w", err)
+	}
+	signer, err := ssh.NewSignerFromKey(probe)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key probe: %w", err)
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(callback(addr, &net.TCPAddr{}, signer.PublicKey()), &keyErr) {
+		return nil, nil
+	}
+
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		keyAlgorithms := []string{known.Key.Type()}
+		// An RSA key signs with SHA-2 on current servers
+		if known.Key.Type() == ssh.KeyAlgoRSA {
+			keyAlgorithms = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		for _, algorithm := range keyAlgorithms {
+			if !slices.Contains(algorithms, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms, nil
+}
+
+// addKnownHost appends the host key of a newly trusted host to known_hosts
+func addKnownHost(path, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts: %w", err)
+	}
+	defer file.Close()
+
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if remote != nil && knownhosts.Normalize(remote.String()) != addresses[0] {
+		addresses = append(addresses, knownhosts.Normalize(remote.String()))
+	}
+	if _, err := fmt.Fprintln(file, knownhosts.Line(addresses, key)); err != nil {
+		return fmt.Errorf("failed to add host key to known_hosts: %w", err)
+	}
+
+	logging.GetSugar().Warnf("Permanently added %s key for %s to %s (fingerprint %s)", key.Type(), hostname, path, ssh.FingerprintSHA256(key))
+	return nil
+}
+
+// hostKeyOptions returns the ssh and scp binary options matching
+// config.HostKey. Strict checking leaves the user's ssh configuration in charge.
+func hostKeyOptions(config SSHConfig) []string {
+	switch config.HostKey {
+	case HostKeyAcceptNew:
+		return []string{"-o", "StrictHostKeyChecking=accept-new"}
+	case HostKeyInsecure:
+		return []string{"-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null"}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,127 @@
+package upload
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startTestSSHServer serves SSH handshakes on a local port with the given
+// host keys and returns its address
+func startTestSSHServer(t *testing.T, hostKeys ...ssh.Signer) string {
+	t.Helper()
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	for _, key := range hostKeys {
+		serverConfig.AddHostKey(key)
+	}
+	return serveTestSSH(t, serverConfig)
+}
+
+// serveTestSSH serves SSH handshakes with serverConfig on a local port and
+// returns its address
+func serveTestSSH(t *testing.T, serverConfig *ssh.ServerConfig) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sshConn, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					return
+				}
+				defer sshConn.Close()
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					newChannel.Reject(ssh.Prohibited, "test server")
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestKnownHostsEd25519Only(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSigner, err := ssh.NewSignerFromKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSigner, err := ssh.NewSignerFromKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server offers ECDSA too, which x/crypto/ssh prefers over ed25519
+	addr := startTestSSHServer(t, ecSigner, edSigner)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, edSigner.PublicKey())
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := SSHConfig{Host: host, Port: port, User: "test", HostKey: HostKeyStrict}
+	callback, algorithms, err := knownHostsCallback(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(algorithms) != 1 || algorithms[0] != ssh.KeyAlgoED25519 {
+		t.Fatalf("host key algorithms = %v, want [%s]", algorithms, ssh.KeyAlgoED25519)
+	}
+
+	client, err := dialSSH(context.Background(), sshAddr(config), &ssh.ClientConfig{
+		User:              config.User,
+		HostKeyCallback:   callback,
+		HostKeyAlgorithms: algorithms,
+		Timeout:           5 * time.Second,
+	}, 0)
+	if err != nil {
+		t.Fatalf("connecting to a host known by its ed25519 key: %v", err)
+	}
+	client.Close()
+}
+
+func TestKnownHostKeyAlgorithmsUnknownHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, algorithms, err := knownHostsCallback(path, SSHConfig{Host: "backup.example", Port: "22"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if algorithms != nil {
+		t.Fatalf("host key algorithms for an unknown host = %v, want the default", algorithms)
+	}
+}
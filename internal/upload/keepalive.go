@@ -0,0 +1,122 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"backup-home/internal/logging"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultKeepAlive is how often an SSH connection is probed while a
+// transfer keeps it busy or idle
+const DefaultKeepAlive = 30 * time.Second
+
+// keepAliveMaxMissed is how many unanswered probes in a row close the
+// connection, like OpenSSH's ServerAliveCountMax
+const keepAliveMaxMissed = 3
+
+// dialSSH connects like ssh.Dial, with TCP keepalive and SSH keepalive
+// requests every keepAlive; zero leaves both at their defaults. The SSH
+// handshake is bounded by the client timeout too, so a server that accepts
+// the connection but never answers does not hang the dial. The dial stops
+// when ctx is canceled.
+func dialSSH(ctx context.Context, addr string, clientConfig *ssh.ClientConfig, keepAlive time.Duration) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: clientConfig.Timeout, KeepAlive: keepAlive}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if clientConfig.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(clientConfig.Timeout))
+	}
+	stop := closeOnCancel(ctx, conn)
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if !stop() {
+		err = errors.Join(err, ctx.Err())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sshConn, chans, reqs)
+	startKeepAlive(client, keepAlive)
+	return client, nil
+}
+
+// startKeepAlive sends a keepalive request every interval until the client
+// is closed, so idle connections are not dropped by NAT or firewalls. After
+// keepAliveMaxMissed unanswered requests the connection is closed, failing
+// the transfer instead of letting it hang. Zero disables keepalive.
+func startKeepAlive(client *ssh.Client, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	sugar := logging.GetSugar()
+
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		missed := 0
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+			}
+
+			reply := make(chan error, 1)
+			go func() {
+				// Servers reject the unknown request, which still counts as an answer
+				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+			select {
+			case <-closed:
+				return
+			case err := <-reply:
+				if err != nil {
+					return
+				}
+				missed = 0
+			case <-time.After(interval):
+				missed++
+				sugar.Debugf("SSH keepalive %d of %d unanswered", missed, keepAliveMaxMissed)
+			}
+
+			if missed >= keepAliveMaxMissed {
+				sugar.Warnf("SSH server %s did not answer %d keepalives, closing the connection", client.RemoteAddr(), missed)
+				client.Close()
+				return
+			}
+		}
+	}()
+}
+
+// keepAliveOptions returns the ssh and scp binary options matching the
+// keepalive interval in config
+func keepAliveOptions(config SSHConfig) []string {
+	if config.KeepAlive <= 0 {
+		return nil
+	}
+	seconds := int(config.KeepAlive.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return []string{
+		"-o", "ServerAliveInterval=" + strconv.Itoa(seconds),
+		"-o", fmt.Sprintf("ServerAliveCountMax=%d", keepAliveMaxMissed),
+	}
+}
@@ -0,0 +1,111 @@
+package upload
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultDateFormat is the Go time layout used for dated backup directories
+const DefaultDateFormat = "2006-01-02"
+
+// DefaultRemoteTemplate renders the hostname/Users/date layout of dated uploads
+const DefaultRemoteTemplate = "{{.Host}}/Users/{{.Date}}"
+
+// RemoteTemplateData holds the values available to a remote directory template
+type RemoteTemplateData struct {
+	// Host is the local hostname
+	Host string
+	// User is the local user name
+	User string
+	// Date is the time of the backup formatted with the date format
+	Date string
+	// Year, Month and Day are zero padded parts of the backup time, e.g.
+	// for year/month folders
+	Year  string
+	Month string
+	Day   string
+	// Time is the time of the backup, for custom layouts like {{.Time.Format "Jan"}}
+	Time time.Time
+}
+
+// RenderRemoteTemplate evaluates tmpl, a Go template such as
+// {{.Host}}/{{.Year}}/{{.Month}}/{{.Date}}, into the subdirectory dated
+// uploads go into. The result must be a relative path below the remote base.
+func RenderRemoteTemplate(tmpl, dateFormat string, now time.Time) (string, error) {
+	if dateFormat == "" {
+		dateFormat = DefaultDateFormat
+	}
+	t, err := template.New("remote").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid remote template: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	data := RemoteTemplateData{
+		Host:  hostname,
+		User:  localUserName(),
+		Date:  now.Format(dateFormat),
+		Year:  now.Format("2006"),
+		Month: now.Format("01"),
+		Day:   now.Format("02"),
+		Time:  now,
+	}
+	var out strings.Builder
+	if err := t.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("invalid remote template: %w", err)
+	}
+
+	subdir := path.Clean(strings.ReplaceAll(out.String(), "\\", "/"))
+	if subdir == "." || path.IsAbs(subdir) || subdir == ".." || strings.HasPrefix(subdir, "../") {
+		return "", fmt.Errorf("remote template %q must render a relative directory below the remote path, got %q", tmpl, out.String())
+	}
+	return subdir, nil
+}
+
+// localUserName returns the name of the user running the backup, without
+// the domain Windows prefixes it with
+func localUserName() string {
+	if current, err := user.Current(); err == nil {
+		name := current.Username
+		if i := strings.LastIndex(name, "\\"); i >= 0 {
+			name = name[i+1:]
+		}
+		return name
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// datedSubdir returns the subdirectory used by the dated layout: subdir if a
+// remote template was rendered, otherwise hostname/Users/date
+func datedSubdir(dateFormat, subdir string) string {
+	if subdir != "" {
+		return subdir
+	}
+	if dateFormat == "" {
+		dateFormat = DefaultDateFormat
+	}
+	return path.Join(hostDir(), time.Now().Format(dateFormat))
+}
+
+// hostDir returns the hostname/Users directory holding this host's dated backups
+func hostDir() string {
+	hostname, _ := os.Hostname()
+	return path.Join(hostname, "Users")
+}
+
+// remoteDir returns the remote directory to upload into:
+// RemotePath/hostname/Users/date, or RemotePath itself in flat mode
+func remoteDir(config SSHConfig) string {
+	if config.Flat {
+		return config.RemotePath
+	}
+	return path.Join(config.RemotePath, datedSubdir(config.DateFormat, config.Subdir))
+}
@@ -0,0 +1,58 @@
+package upload
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRenderRemoteTemplate(t *testing.T) {
+	hostname, _ := os.Hostname()
+	user := localUserName()
+	now := time.Date(2024, 3, 7, 15, 4, 0, 0, time.UTC)
+
+	tests := []struct {
+		tmpl       string
+		dateFormat string
+		want       string
+	}{
+		{DefaultRemoteTemplate, "", hostname + "/Users/2024-03-07"},
+		{"{{.Host}}/{{.Year}}/{{.Month}}/{{.Date}}", "20060102", hostname + "/2024/03/20240307"},
+		{"{{.User}}/{{.Year}}-{{.Month}}-{{.Day}}", "", user + "/2024-03-07"},
+		{`{{.Time.Format "Jan"}}/{{.Date}}`, "2006-01-02_1504", "Mar/2024-03-07_1504"},
+		// The result is cleaned into a slash separated relative path
+		{"backups/./{{.Date}}/", "", "backups/2024-03-07"},
+		{`backups\{{.Day}}`, "", "backups/07"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			got, err := RenderRemoteTemplate(tt.tmpl, tt.dateFormat, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderRemoteTemplate(%q) = %q, want %q", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderRemoteTemplateInvalid(t *testing.T) {
+	now := time.Date(2024, 3, 7, 15, 4, 0, 0, time.UTC)
+	for _, tmpl := range []string{
+		// Unknown placeholders and syntax errors
+		"{{.Hostname}}/{{.Date}}",
+		"{{.Date",
+		"{{.Date | nosuchfunc}}",
+		// Results outside the remote base
+		"",
+		".",
+		"/backups/{{.Date}}",
+		"../{{.Date}}",
+		"{{.Year}}/../..",
+	} {
+		if got, err := RenderRemoteTemplate(tmpl, "", now); err == nil {
+			t.Errorf("RenderRemoteTemplate(%q) = %q, want an error", tmpl, got)
+		}
+	}
+}
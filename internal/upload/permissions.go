@@ -0,0 +1,67 @@
+package upload
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// permissionsCommand returns the remote shell command that applies the
+// configured mode and owner to an uploaded file and, in the dated layout, to
+// its date directory. It returns an empty string when nothing is configured.
+func permissionsCommand(config SSHConfig, remoteFile string) string {
+	targets := []string{remoteFile}
+	if !config.Flat {
+		targets = append(targets, path.Dir(remoteFile))
+	}
+
+	var commands []string
+	if config.Chmod != 0 {
+		commands = append(commands, fmt.Sprintf("chmod %o %s", config.Chmod, shellQuote(remoteFile)))
+		if !config.Flat {
+			commands = append(commands, fmt.Sprintf("chmod %o %s", dirMode(config.Chmod), shellQuote(path.Dir(remoteFile))))
+		}
+	}
+	if config.Chown != "" {
+		quoted := make([]string, len(targets))
+		for i, target := range targets {
+			quoted[i] = shellQuote(target)
+		}
+		commands = append(commands, fmt.Sprintf("chown %s %s", shellQuote(config.Chown), strings.Join(quoted, " ")))
+	}
+	return strings.Join(commands, " && ")
+}
+
+// dirMode adds search permission wherever mode grants read, so a directory
+// given a file mode such as 0640 stays traversable by the same users
+func dirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fixRemotePermissions runs the permission command for remoteFile over an
+// existing SSH connection
+func fixRemotePermissions(client *ssh.Client, config SSHConfig, remoteFile string) error {
+	command := permissionsCommand(config, remoteFile)
+	if command == "" {
+		return nil
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	if output, err := session.CombinedOutput(command); err != nil {
+		return fmt.Errorf("failed to set remote permissions: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"backup-home/internal/checksum"
+	"backup-home/internal/logging"
+
+	"github.com/pkg/sftp"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/librclone/librclone"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxSidecarSize bounds how much of a remote checksum file is read
+const maxSidecarSize = 4096
+
+// rcloneHashTypes maps checksum algorithms to the rclone hash types backends
+// can compute server-side; the others are only compared through the uploaded
+// checksum file
+var rcloneHashTypes = map[string]hash.Type{
+	checksum.MD5:    hash.MD5,
+	checksum.SHA256: hash.SHA256,
+}
+
+// BackupDirs lists and removes this host's dated backup directories on a remote
+type BackupDirs interface {
+	// Location describes where the directories live, for logging
+	Location() string
+	List() ([]string, error)
+	Remove(name string) error
+	// Checksum returns the algo checksum of the file name in the backup
+	// directory dir, or "" if the file or its checksum is not there
+	Checksum(dir, name, algo string) (string, error)
+	Close() error
+}
+
+// sshBackupDirs implements BackupDirs over SFTP
+type sshBackupDirs struct {
+	sshClient  *ssh.Client
+	sftpClient *sftp.Client
+	config     SSHConfig
+	base       string
+}
+
+// NewSSHBackupDirs connects to the SSH host in config. The dated layout is
+// required since flat uploads have no date directories.
+func NewSSHBackupDirs(config SSHConfig) (BackupDirs, error) {
+	if config.Flat {
+		return nil, fmt.Errorf("flat SSH uploads have no date directories to prune")
+	}
+	sshClient, sftpClient, err := connectSFTP(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+	return &sshBackupDirs{
+		sshClient:  sshClient,
+		sftpClient: sftpClient,
+		config:     config,
+		base:       path.Join(config.RemotePath, hostDir()),
+	}, nil
+}
+
+func (d *sshBackupDirs) Location() string {
+	return fmt.Sprintf("%s@%s:%s", d.config.User, d.config.Host, d.base)
+}
+
+func (d *sshBackupDirs) List() ([]string, error) {
+	entries, err := d.sftpClient.ReadDir(d.base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", d.base, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d *sshBackupDirs) Remove(name string) error {
+	if err := d.sftpClient.RemoveAll(path.Join(d.base, name)); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", name, err)
+	}
+	return nil
+}
+
+// Checksum reads the checksum file uploaded next to the file, as SFTP
+// cannot hash remote files
+func (d *sshBackupDirs) Checksum(dir, name, algo string) (string, error) {
+	sidecarPath := checksum.SidecarPath(path.Join(d.base, dir, name), algo)
+	file, err := d.sftpClient.Open(sidecarPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", sidecarPath, err)
+	}
+	defer file.Close()
+	return readSidecar(file, sidecarPath)
+}
+
+func (d *sshBackupDirs) Close() error {
+	d.sftpClient.Close()
+	return d.sshClient.Close()
+}
+
+// rcloneBackupDirs implements BackupDirs with librclone
+type rcloneBackupDirs struct {
+	destination string
+	base        string
+}
+
+type listRequest struct {
+	Fs     string      `json:"fs"`
+	Remote string      `json:"remote"`
+	Opt    listOptions `json:"opt"`
+}
+
+type listOptions struct {
+	DirsOnly bool `json:"dirsOnly"`
+}
+
+type listResponse struct {
+	List []struct {
+		Name  string `json:"Name"`
+		IsDir bool   `json:"IsDir"`
+	} `json:"list"`
+}
+
+// NewRcloneBackupDirs prepares librclone for the destination in config
+func NewRcloneBackupDirs(config RcloneConfig) (BackupDirs, error) {
+	sugar = logging.GetSugar()
+	startRclone()
+	return &rcloneBackupDirs{destination: config.Destination, base: hostDir()}, nil
+}
+
+func (d *rcloneBackupDirs) Location() string {
+	return fmt.Sprintf("%s (on %s)", d.base, d.destination)
+}
+
+func (d *rcloneBackupDirs) List() ([]string, error) {
+	reqJSON, err := json.Marshal(listRequest{Fs: d.destination, Remote: d.base, Opt: listOptions{DirsOnly: true}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	out, status := librclone.RPC("operations/list", string(reqJSON))
+	if status != 0 && status != 200 {
+		return nil, fmt.Errorf("rclone list %s failed: %w", d.base, rcloneError(status, out))
+	}
+
+	var resp listResponse
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse rclone list response: %w", err)
+	}
+	var names []string
+	for _, entry := range resp.List {
+		if entry.IsDir {
+			names = append(names, entry.Name)
+		}
+	}
+	return names, nil
+}
+
+func (d *rcloneBackupDirs) Remove(name string) error {
+	reqJSON, err := json.Marshal(remoteRequest{Fs: d.destination, Remote: path.Join(d.base, name)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	out, status := librclone.RPC("operations/purge", string(reqJSON))
+	if status != 0 && status != 200 {
+		return fmt.Errorf("rclone purge %s failed: %w", name, rcloneError(status, out))
+	}
+	return nil
+}
+
+// Checksum asks the backend for the hash of the file, and reads the checksum
+// file uploaded next to it when the backend does not store that hash
+func (d *rcloneBackupDirs) Checksum(dir, name, algo string) (string, error) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, d.destination)
+	if err != nil {
+		return "", fmt.Errorf("failed to open rclone destination: %w", err)
+	}
+
+	remote := path.Join(d.base, dir, name)
+	obj, err := f.NewObject(ctx, remote)
+	if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorDirNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find %s: %w", remote, err)
+	}
+	if hashType, ok := rcloneHashTypes[algo]; ok && f.Hashes().Contains(hashType) {
+		sum, err := obj.Hash(ctx, hashType)
+		if err == nil && sum != "" {
+			return sum, nil
+		}
+		sugar.Debugf("rclone could not hash %s with %s: %v", remote, algo, err)
+	} else {
+		sugar.Debugf("%s does not support %s hashes, reading the checksum file", d.destination, algo)
+	}
+
+	sidecarPath := checksum.SidecarPath(remote, algo)
+	sidecar, err := f.NewObject(ctx, sidecarPath)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find %s: %w", sidecarPath, err)
+	}
+	reader, err := sidecar.Open(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", sidecarPath, err)
+	}
+	defer reader.Close()
+	return readSidecar(reader, sidecarPath)
+}
+
+func (d *rcloneBackupDirs) Close() error {
+	librclone.Finalize()
+	return nil
+}
+
+// readSidecar returns the checksum recorded in a remote checksum file
+func readSidecar(r io.Reader, sidecarPath string) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSidecarSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", sidecarPath, err)
+	}
+	sum, _, err := checksum.ParseSidecar(data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, sidecarPath)
+	}
+	return sum, nil
+}
@@ -0,0 +1,111 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// normalizeRcloneKey reduces an option name to compare rclone flag names
+// (buffer-size, --buffer-size), config names (buffer_size) and Go field
+// names (BufferSize) with each other
+func normalizeRcloneKey(key string) string {
+	key = strings.ToLower(strings.TrimLeft(key, "-"))
+	return strings.NewReplacer("-", "", "_", "").Replace(key)
+}
+
+// rcloneOptionFields maps normalized option names to the fields of rclone's
+// global configuration
+func rcloneOptionFields() map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	t := reflect.TypeOf(fs.ConfigInfo{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fields[normalizeRcloneKey(field.Name)] = field
+		if name := field.Tag.Get("config"); name != "" {
+			fields[normalizeRcloneKey(name)] = field
+		}
+	}
+	return fields
+}
+
+// ParseRcloneFlags turns key=value pairs named after rclone's global flags,
+// e.g. transfers=8 or buffer-size=64M, into the _config object of an rc
+// request. Unknown options and invalid values are rejected.
+func ParseRcloneFlags(flags []string) (map[string]interface{}, error) {
+	if len(flags) == 0 {
+		return nil, nil
+	}
+
+	fields := rcloneOptionFields()
+	config := make(map[string]interface{}, len(flags))
+	for _, flag := range flags {
+		key, raw, ok := strings.Cut(flag, "=")
+		if !ok {
+			// A bare boolean flag like checksum turns the option on
+			raw = "true"
+		}
+		field, known := fields[normalizeRcloneKey(key)]
+		if !known {
+			return nil, fmt.Errorf("unknown rclone option %q", key)
+		}
+		value, err := rcloneOptionValue(field.Type, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for rclone option %s: %w", key, err)
+		}
+		config[field.Name] = value
+	}
+
+	// Decode into a scratch config to reject values rclone would not accept
+	if err := rc.Reshape(&fs.ConfigInfo{}, config); err != nil {
+		return nil, fmt.Errorf("invalid rclone options: %w", err)
+	}
+	return config, nil
+}
+
+// rcloneOptionValue converts raw to the JSON value decoded into a field of
+// type t. Types with their own JSON decoding, like sizes, take the string.
+func rcloneOptionValue(t reflect.Type, raw string) (interface{}, error) {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		d, err := fs.ParseDuration(raw)
+		return int64(d), err
+	}
+	if _, ok := reflect.New(t).Interface().(json.Unmarshaler); ok {
+		return raw, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(raw, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(raw, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(raw, 64)
+	default:
+		return raw, nil
+	}
+}
+
+// withRcloneFlags returns ctx carrying rclone's global configuration with the
+// options of config applied, for calls made directly rather than through rc
+func withRcloneFlags(ctx context.Context, config RcloneConfig) (context.Context, error) {
+	if len(config.Flags) == 0 {
+		return ctx, nil
+	}
+	ctx, ci := fs.AddConfig(ctx)
+	if err := rc.Reshape(ci, config.Flags); err != nil {
+		return ctx, fmt.Errorf("invalid rclone options: %w", err)
+	}
+	return ctx, nil
+}
@@ -0,0 +1,164 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/rclone/rclone/librclone/librclone"
+)
+
+// RemoteBackup is a file in a dated backup directory on a remote, at
+// Host/Users/Date/Name below the remote base
+type RemoteBackup struct {
+	Host    string
+	Date    string
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// remoteEntry is a directory entry read from a remote
+type remoteEntry struct {
+	name    string
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+// listDatedBackups walks the hostname/Users/date layout with readDir, which
+// lists a directory relative to the remote base. A missing base holds no
+// backups yet, and hosts without a Users directory are skipped.
+func listDatedBackups(readDir func(dir string) ([]remoteEntry, error)) ([]RemoteBackup, error) {
+	hosts, err := readDir("")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []RemoteBackup
+	for _, host := range hosts {
+		if !host.isDir {
+			continue
+		}
+		// Look for Users first, since rclone logs listing a missing directory as an error
+		hostEntries, err := readDir(host.name)
+		if err != nil {
+			return nil, err
+		}
+		if !containsDir(hostEntries, "Users") {
+			continue
+		}
+		usersDir := path.Join(host.name, "Users")
+		dates, err := readDir(usersDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, date := range dates {
+			if !date.isDir {
+				continue
+			}
+			files, err := readDir(path.Join(usersDir, date.name))
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				if file.isDir {
+					continue
+				}
+				backups = append(backups, RemoteBackup{
+					Host:    host.name,
+					Date:    date.name,
+					Name:    file.name,
+					Size:    file.size,
+					ModTime: file.modTime,
+				})
+			}
+		}
+	}
+	return backups, nil
+}
+
+// containsDir reports whether entries hold a directory called name
+func containsDir(entries []remoteEntry, name string) bool {
+	for _, entry := range entries {
+		if entry.isDir && entry.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSSHBackups lists the files of every host's dated backups below
+// config.RemotePath over SFTP
+func ListSSHBackups(ctx context.Context, config SSHConfig) ([]RemoteBackup, error) {
+	if config.Flat {
+		return nil, fmt.Errorf("flat SSH uploads have no date directories to list")
+	}
+	sshClient, sftpClient, err := connectSFTP(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer sshClient.Close()
+	defer sftpClient.Close()
+	defer closeOnCancel(ctx, sshClient)()
+
+	return listDatedBackups(func(dir string) ([]remoteEntry, error) {
+		infos, err := sftpClient.ReadDir(path.Join(config.RemotePath, dir))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", path.Join(config.RemotePath, dir), err)
+		}
+		entries := make([]remoteEntry, 0, len(infos))
+		for _, info := range infos {
+			entries = append(entries, remoteEntry{name: info.Name(), isDir: info.IsDir(), size: info.Size(), modTime: info.ModTime()})
+		}
+		return entries, nil
+	})
+}
+
+type listFilesResponse struct {
+	List []struct {
+		Name    string    `json:"Name"`
+		Size    int64     `json:"Size"`
+		ModTime time.Time `json:"ModTime"`
+		IsDir   bool      `json:"IsDir"`
+	} `json:"list"`
+}
+
+// ListRcloneBackups lists the files of every host's dated backups on the
+// rclone destination in config with operations/list
+func ListRcloneBackups(config RcloneConfig, verbose bool) ([]RemoteBackup, error) {
+	if err := initRclone(verbose); err != nil {
+		return nil, err
+	}
+	defer librclone.Finalize()
+
+	return listDatedBackups(func(dir string) ([]remoteEntry, error) {
+		reqJSON, err := json.Marshal(listRequest{Fs: config.Destination, Remote: dir})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		out, status := librclone.RPC("operations/list", string(reqJSON))
+		if status == 404 {
+			return nil, fmt.Errorf("%s not found on %s: %w", dir, config.Destination, os.ErrNotExist)
+		}
+		if status != 0 && status != 200 {
+			return nil, fmt.Errorf("rclone list %s failed: %w", dir, rcloneError(status, out))
+		}
+
+		var resp listFilesResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			return nil, fmt.Errorf("failed to parse rclone list response: %w", err)
+		}
+		entries := make([]remoteEntry, 0, len(resp.List))
+		for _, item := range resp.List {
+			entries = append(entries, remoteEntry{name: item.Name, isDir: item.IsDir, size: item.Size, modTime: item.ModTime})
+		}
+		return entries, nil
+	})
+}
@@ -0,0 +1,109 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"backup-home/internal/logging"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// remoteFreeCommand returns the shell command printing the free space of the
+// filesystem holding dir in POSIX df format, in 1024-byte blocks
+func remoteFreeCommand(dir string) string {
+	return "df -Pk -- " + shellQuote(dir)
+}
+
+// checkRemoteSpace fails before an upload of localPath when the filesystem
+// holding config.RemotePath has less free space than the file plus
+// config.MinRemoteFree, so a full disk does not leave a truncated file on
+// the server. The free space is read with the SFTP statvfs extension, or
+// df over SSH. Free space that cannot be determined is only a warning.
+func checkRemoteSpace(ctx context.Context, localPath string, config SSHConfig) error {
+	sugar := logging.GetSugar()
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat local file: %w", err)
+	}
+
+	free, err := remoteFreeSpace(ctx, config)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		sugar.Warnf("Could not determine free space on %s:%s, uploading anyway: %v", config.Host, config.RemotePath, err)
+		return nil
+	}
+
+	required := uint64(info.Size()) + uint64(config.MinRemoteFree)
+	sugar.Infof("Free space on %s:%s: %.2f MB (%.2f MB needed)", config.Host, config.RemotePath,
+		float64(free)/1024/1024, float64(required)/1024/1024)
+	if free < required {
+		return fmt.Errorf("not enough free space on %s:%s: %.2f MB free, but the upload needs %.2f MB and %.2f MB must stay free",
+			config.Host, config.RemotePath, float64(free)/1024/1024, float64(info.Size())/1024/1024, float64(config.MinRemoteFree)/1024/1024)
+	}
+	return nil
+}
+
+// remoteFreeSpace returns the bytes available to the SSH user on the
+// filesystem holding config.RemotePath
+func remoteFreeSpace(ctx context.Context, config SSHConfig) (uint64, error) {
+	if config.Method == "" || config.Method == SSHMethodBinary {
+		output, err := exec.CommandContext(ctx, "ssh", sshCommandArgs(config, remoteFreeCommand(config.RemotePath))...).CombinedOutput()
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", err, lastLine(string(output)))
+		}
+		return parseDfOutput(string(output))
+	}
+
+	sshClient, sftpClient, err := connectSFTP(ctx, config)
+	if err != nil {
+		return 0, err
+	}
+	defer sshClient.Close()
+	defer sftpClient.Close()
+	defer closeOnCancel(ctx, sshClient)()
+
+	stat, err := sftpClient.StatVFS(config.RemotePath)
+	if err == nil {
+		return stat.Frsize * stat.Bavail, nil
+	}
+	logging.GetSugar().Debugf("SFTP statvfs failed, running df: %v", err)
+	return remoteDf(sshClient, config.RemotePath)
+}
+
+// remoteDf runs df for dir over client
+func remoteDf(client *ssh.Client, dir string) (uint64, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	output, err := session.CombinedOutput(remoteFreeCommand(dir))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return parseDfOutput(string(output))
+}
+
+// parseDfOutput returns the available bytes from the second line of
+// "df -Pk" output, whose fourth column counts 1024-byte blocks
+func parseDfOutput(output string) (uint64, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) >= 2 {
+		fields := strings.Fields(lines[len(lines)-1])
+		if len(fields) >= 4 {
+			if blocks, err := strconv.ParseUint(fields[3], 10, 64); err == nil {
+				return blocks * 1024, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unexpected df output: %q", strings.TrimSpace(output))
+}
@@ -0,0 +1,78 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"backup-home/internal/logging"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpPacketSize is the size of each SFTP write request
+const sftpPacketSize = 256 * 1024
+
+// RetrySSH is Retry for an SSH upload: every attempt after the first sets
+// config.Resume, so the SFTP methods continue the remote file the failed
+// attempt left instead of sending it all again
+func RetrySSH(ctx context.Context, retries int, config SSHConfig, upload func(config SSHConfig) error) error {
+	attempt := 0
+	return Retry(ctx, retries, func() error {
+		attempt++
+		config.Resume = attempt > 1
+		return upload(config)
+	})
+}
+
+// createRemoteFile opens remotePath on client for an upload of localFile,
+// whose size is size, and returns the offset the upload starts at, with
+// localFile positioned there. Without config.Resume the remote file is
+// created from scratch. With it, a remote file no larger than the local one
+// is continued. Writes are sent concurrently, so a failed attempt may have
+// left holes in its last requests in flight; the upload restarts that far
+// before the end of the remote file.
+func createRemoteFile(client *sftp.Client, remotePath string, localFile *os.File, size int64, config SSHConfig) (*sftp.File, int64, error) {
+	sugar := logging.GetSugar()
+
+	if config.Resume {
+		if info, err := client.Stat(remotePath); err == nil && info.Size() > 0 && info.Size() <= size {
+			offset := max(0, info.Size()-int64(sftpRequests(config))*sftpPacketSize)
+			remoteFile, err := openAt(client, remotePath, localFile, offset)
+			if err == nil {
+				sugar.Infof("Resuming upload at %.2f of %.2f MB (%.2f MB already on the server)",
+					float64(offset)/1024/1024, float64(size)/1024/1024, float64(info.Size())/1024/1024)
+				return remoteFile, offset, nil
+			}
+			sugar.Warnf("Cannot resume the upload, starting over: %v", err)
+			if _, err := localFile.Seek(0, io.SeekStart); err != nil {
+				return nil, 0, fmt.Errorf("failed to seek local file: %w", err)
+			}
+		}
+	}
+
+	remoteFile, err := client.Create(remotePath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create remote file: %w", err)
+	}
+	return remoteFile, 0, nil
+}
+
+// openAt opens remotePath for writing without truncating it and positions it
+// and localFile at offset
+func openAt(client *sftp.Client, remotePath string, localFile *os.File, offset int64) (*sftp.File, error) {
+	remoteFile, err := client.OpenFile(remotePath, os.O_WRONLY)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open remote file: %w", err)
+	}
+	if _, err := remoteFile.Seek(offset, io.SeekStart); err != nil {
+		remoteFile.Close()
+		return nil, fmt.Errorf("failed to seek remote file: %w", err)
+	}
+	if _, err := localFile.Seek(offset, io.SeekStart); err != nil {
+		remoteFile.Close()
+		return nil, fmt.Errorf("failed to seek local file: %w", err)
+	}
+	return remoteFile, nil
+}
@@ -0,0 +1,117 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"backup-home/internal/logging"
+)
+
+// DefaultRetries is how many times a failed upload is retried by default
+const DefaultRetries = 3
+
+// Delays between upload attempts, doubling after each retry
+const (
+	retryInitialDelay = 5 * time.Second
+	retryMaxDelay     = 2 * time.Minute
+)
+
+// transientMessages are error texts, as reported by scp, ssh and rclone, of
+// failures worth retrying
+var transientMessages = []string{
+	"connection reset",
+	"connection refused",
+	"connection closed",
+	"connection timed out",
+	"lost connection",
+	"broken pipe",
+	"i/o timeout",
+	"timed out",
+	"network is unreachable",
+	"no route to host",
+	"temporary failure in name resolution",
+	"unexpected eof",
+}
+
+// permanentMessages are error texts of authentication failures, which are
+// never retried even if they also mention the connection
+var permanentMessages = []string{
+	"permission denied",
+	"unable to authenticate",
+	"authentication failed",
+	"host key verification failed",
+	"no supported methods remain",
+}
+
+// Retry calls upload until it succeeds, fails with an error that is not
+// transient, or has been retried retries times. The delay between attempts
+// doubles each time. Each call to upload must start from scratch, dialing
+// its own connection. Once ctx is canceled no further attempt is made.
+func Retry(ctx context.Context, retries int, upload func() error) error {
+	sugar := logging.GetSugar()
+
+	delay := retryInitialDelay
+	for attempt := 1; ; attempt++ {
+		err := upload()
+		if err == nil || ctx.Err() != nil || !IsTransient(err) {
+			return err
+		}
+		if attempt > retries {
+			if attempt > 1 {
+				return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+
+		sugar.Warnf("Upload attempt %d of %d failed: %v", attempt, retries+1, err)
+		sugar.Infof("Retrying upload in %s (attempt %d of %d)", delay, attempt+1, retries+1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+// IsTransient reports whether err looks like a network failure that may
+// succeed on another attempt, such as a reset connection, a timeout or an
+// unexpected EOF. Authentication failures are not transient.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	message := strings.ToLower(err.Error())
+	for _, permanent := range permanentMessages {
+		if strings.Contains(message, permanent) {
+			return false
+		}
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ETIMEDOUT) || errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.EHOSTUNREACH) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	for _, transient := range transientMessages {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	// Errors from a remote tool often end with a bare EOF
+	return strings.HasSuffix(message, ": eof")
+}
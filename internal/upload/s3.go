@@ -0,0 +1,71 @@
+package upload
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// s3RemoteName names the rclone remote S3Remote defines for an S3 bucket
+const s3RemoteName = "backuphomes3"
+
+// S3Config holds the settings of an S3-compatible bucket, such as AWS S3 or
+// MinIO, uploaded to with rclone's S3 backend without an rclone.conf
+type S3Config struct {
+	// Endpoint is the URL of an S3-compatible server; empty means AWS
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is the directory inside the bucket uploads go into
+	Prefix string
+	// AccessKey and SecretKey authenticate the requests; when both are empty
+	// the standard AWS environment variables and credential files are used
+	AccessKey string
+	SecretKey string
+	// Concurrency is the number of multipart chunks uploaded in parallel;
+	// zero uses rclone's default
+	Concurrency int
+}
+
+// S3Remote defines an rclone remote for config in the environment of this
+// process, so the credentials never appear in a remote path or the logs,
+// and returns the rclone destination of the bucket and prefix. Large files
+// are sent as multipart uploads by rclone's S3 backend.
+func S3Remote(config S3Config) (string, error) {
+	if config.Bucket == "" {
+		return "", fmt.Errorf("an S3 bucket is required")
+	}
+	if (config.AccessKey == "") != (config.SecretKey == "") {
+		return "", fmt.Errorf("the S3 access key and secret key must be given together")
+	}
+
+	settings := map[string]string{
+		"type":     "s3",
+		"provider": "AWS",
+		"region":   config.Region,
+	}
+	if config.Endpoint != "" {
+		settings["provider"] = "Other"
+		settings["endpoint"] = config.Endpoint
+	}
+	if config.AccessKey != "" {
+		settings["access_key_id"] = config.AccessKey
+		settings["secret_access_key"] = config.SecretKey
+	} else {
+		settings["env_auth"] = "true"
+	}
+	if config.Concurrency > 0 {
+		settings["upload_concurrency"] = strconv.Itoa(config.Concurrency)
+	}
+
+	for key, value := range settings {
+		name := "RCLONE_CONFIG_" + strings.ToUpper(s3RemoteName+"_"+key)
+		if err := os.Setenv(name, value); err != nil {
+			return "", fmt.Errorf("failed to configure S3 remote: %w", err)
+		}
+	}
+
+	return s3RemoteName + ":" + path.Join(config.Bucket, strings.Trim(config.Prefix, "/")), nil
+}
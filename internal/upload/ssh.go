@@ -1,20 +1,23 @@
 package upload
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"backup-home/internal/logging"
+	"backup-home/internal/progress"
 
 	"github.com/pkg/sftp"
+	"github.com/rclone/rclone/fs"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
 )
 
 // SSH configuration constants from the PowerShell script
@@ -27,84 +30,140 @@ const (
 
 // SSHConfig holds SSH connection configuration
 type SSHConfig struct {
-	Host       string
-	Port       string
-	User       string
-	Password   string
-	KeyFile    string
-	RemotePath string
+	Host     string
+	Port     string
+	User     string
+	Password string
+	KeyFile  string
+	// CertFile is the certificate of KeyFile; empty uses KeyFile-cert.pub
+	// when it exists
+	CertFile string
+	// KeyPassphrase decrypts an encrypted key file or default key
+	KeyPassphrase string
+	// PromptPassphrase asks for the passphrase of an encrypted key when
+	// KeyPassphrase is empty; nil fails instead
+	PromptPassphrase func(keyFile string) (string, error)
+	RemotePath       string
+	// Flat uploads directly into RemotePath without hostname/date subdirectories
+	Flat bool
+	// DateFormat is the Go time layout of the date subdirectory
+	DateFormat string
+	// Subdir replaces the hostname/Users/date subdirectory, as rendered by
+	// RenderRemoteTemplate; empty uses the default layout
+	Subdir string
+	// Chmod is applied to the uploaded file and its date directory; zero leaves
+	// the remote default
+	Chmod os.FileMode
+	// Chown is a user:group (or :group) owner applied like Chmod
+	Chown string
+	// HostKey selects how the server's host key is verified
+	HostKey HostKeyMode
+	// KeepAlive is how often the connection is probed so it is not dropped
+	// while idle; zero disables keepalive
+	KeepAlive time.Duration
+	// Method selects the upload implementation, one of SSHMethods; empty
+	// uses DefaultSSHMethod
+	Method string
+	// Concurrency is the number of SFTP requests in flight per file; zero
+	// uses defaultSFTPRequests
+	Concurrency int
+	// VerifyUpload compares the SHA-256 of the uploaded file with the local
+	// one after each upload
+	VerifyUpload bool
+	// MinRemoteFree is the number of bytes that must stay free on the remote
+	// filesystem after the upload; zero skips the free space check
+	MinRemoteFree int64
+	// BwLimit is the upload bandwidth schedule, as parsed by
+	// ParseBwSchedule; empty uploads at full speed
+	BwLimit fs.BwTimetable
+	// Resume makes the SFTP methods continue a remote file left by a failed
+	// attempt instead of starting over; RetrySSH sets it for retries
+	Resume bool
 }
 
-// UploadToSSH uploads a backup file to a remote machine via SSH/SFTP
-func UploadToSSH(localPath string, config SSHConfig, verbose bool) error {
-	return UploadToSSHBinary(localPath, config, verbose)
+// defaultSFTPRequests is the conservative number of concurrent SFTP requests per file
+const defaultSFTPRequests = 32
+
+// sftpRequests returns the number of concurrent SFTP requests per file for config
+func sftpRequests(config SSHConfig) int {
+	if config.Concurrency > 0 {
+		return config.Concurrency
+	}
+	return defaultSFTPRequests
 }
 
-// UploadToSSHOriginal is the original SSH implementation (kept for reference)
-func UploadToSSHOriginal(localPath string, config SSHConfig, verbose bool) error {
-	// Get the sugar reference for this package
-	sugar := logging.GetSugar()
+// SSH upload implementations selectable with SSHConfig.Method
+const (
+	// SSHMethodBinary runs the system ssh and scp commands, the fastest
+	// option, which honors ~/.ssh/config but cannot use a password
+	SSHMethodBinary = "binary"
+	// SSHMethodSFTP writes over SFTP with concurrent requests in pure Go
+	SSHMethodSFTP = "sftp"
+	// SSHMethodSCP speaks the SCP protocol in pure Go as a single stream,
+	// for servers without an SFTP subsystem
+	SSHMethodSCP = "scp"
+	// SSHMethodGoph writes over SFTP through the goph client
+	SSHMethodGoph = "goph"
+)
 
-	sugar.Infof("Starting SSH upload to %s@%s:%s", config.User, config.Host, config.Port)
-	startTime := time.Now()
+// DefaultSSHMethod is the SSH upload implementation used unless another is chosen
+const DefaultSSHMethod = SSHMethodBinary
 
-	// Configure SSH client
-	sshConfig := &ssh.ClientConfig{
-		User:            config.User,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, verify host key
-		Timeout:         30 * time.Second,
-	}
+// SSHMethods returns the names of the SSH upload implementations
+func SSHMethods() []string {
+	return []string{SSHMethodBinary, SSHMethodSFTP, SSHMethodSCP, SSHMethodGoph}
+}
 
-	// Configure authentication
-	if config.KeyFile != "" {
-		key, err := os.ReadFile(config.KeyFile)
-		if err != nil {
-			return fmt.Errorf("failed to read SSH key file: %w", err)
-		}
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return fmt.Errorf("failed to parse SSH key: %w", err)
+// UploadToSSH uploads a backup file to a remote machine via SSH, using the
+// implementation selected by config.Method, and verifies it when
+// config.VerifyUpload is set. When config.MinRemoteFree is set, the upload
+// fails early if the remote filesystem cannot hold the file plus that
+// margin. The upload stops when ctx is canceled.
+func UploadToSSH(ctx context.Context, localPath string, config SSHConfig, verbose bool) error {
+	if config.MinRemoteFree > 0 {
+		if err := checkRemoteSpace(ctx, localPath, config); err != nil {
+			return err
 		}
-		sshConfig.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
-	} else if config.Password != "" {
-		sshConfig.Auth = []ssh.AuthMethod{ssh.Password(config.Password)}
-	} else {
-		// Skip SSH agent (it's not working properly with Go SSH library)
-		// Go directly to trying default key locations
-		sugar.Debugf("Checking for SSH keys in default locations")
-		
-		keyAuth, err := tryDefaultKeys()
-		if err != nil {
-			return fmt.Errorf("no SSH keys found in default locations")
-		}
-		
-		sshConfig.Auth = keyAuth
 	}
 
-	// Connect to SSH server
-	addr := fmt.Sprintf("%s:%s", config.Host, config.Port)
-	sshClient, err := ssh.Dial("tcp", addr, sshConfig)
-	if err != nil {
-		return fmt.Errorf("failed to connect to SSH server: %w", err)
+	var err error
+	switch config.Method {
+	case "", SSHMethodBinary:
+		err = UploadToSSHBinary(ctx, localPath, config, verbose)
+	case SSHMethodSFTP:
+		err = UploadToSSHOriginal(ctx, localPath, config, verbose)
+	case SSHMethodSCP:
+		err = UploadToSSHSCP(ctx, localPath, config, verbose)
+	case SSHMethodGoph:
+		err = UploadToSSHGoph(ctx, localPath, config, verbose)
+	default:
+		return fmt.Errorf("unknown SSH method %q (available: %s)", config.Method, strings.Join(SSHMethods(), ", "))
 	}
-	defer sshClient.Close()
+	if err != nil || !config.VerifyUpload {
+		return err
+	}
+	return verifySSHUpload(ctx, localPath, config)
+}
 
-	// Create SFTP client with balanced performance optimizations
-	sftpClient, err := sftp.NewClient(sshClient,
-		sftp.UseConcurrentReads(true),
-		sftp.UseConcurrentWrites(true),
-		sftp.MaxConcurrentRequestsPerFile(32), // Conservative concurrent requests
-		sftp.MaxPacketUnchecked(256*1024),     // 256KB packets (stable size)
-	)
+// UploadToSSHOriginal is the original SSH implementation, writing over SFTP
+// with concurrent requests (SSHMethodSFTP)
+func UploadToSSHOriginal(ctx context.Context, localPath string, config SSHConfig, verbose bool) error {
+	// Get the sugar reference for this package
+	sugar := logging.GetSugar()
+
+	sugar.Infof("Starting SSH upload to %s@%s:%s", config.User, config.Host, config.Port)
+	startTime := time.Now()
+
+	sshClient, sftpClient, err := connectSFTP(ctx, config)
 	if err != nil {
-		return fmt.Errorf("failed to create SFTP client: %w", err)
+		return err
 	}
+	defer sshClient.Close()
 	defer sftpClient.Close()
+	defer closeOnCancel(ctx, sshClient)()
 
 	// Build remote path with date directory structure
-	hostname, _ := os.Hostname()
-	dateDir := time.Now().Format("2006-01-02")
-	remotePath := path.Join(config.RemotePath, hostname, "Users", dateDir)
+	remotePath := remoteDir(config)
 
 	// Create remote directory structure
 	sugar.Debugf("Creating remote directory: %s", remotePath)
@@ -130,20 +189,22 @@ func UploadToSSHOriginal(localPath string, config SSHConfig, verbose bool) error
 	remoteFilePath := path.Join(remotePath, remoteFileName)
 	sugar.Infof("Uploading to: %s", remoteFilePath)
 
-	remoteFile, err := sftpClient.Create(remoteFilePath)
+	remoteFile, offset, err := createRemoteFile(sftpClient, remoteFilePath, localFile, fileInfo.Size(), config)
 	if err != nil {
-		return fmt.Errorf("failed to create remote file: %w", err)
+		return err
 	}
 	defer remoteFile.Close()
 
 	// Copy file content with progress reporting
 	progressReader := &progressReader{
-		reader:    localFile,
-		total:     fileInfo.Size(),
-		startTime: startTime,
-		sugar:     sugar,
+		reader:      &contextReader{ctx: ctx, reader: newBwLimitedReader(ctx, localFile, config.BwLimit)},
+		total:       fileInfo.Size(),
+		offset:      offset,
+		transferred: offset,
+		startTime:   startTime,
+		sugar:       sugar,
 	}
-	
+
 	bytesCopied, err := io.Copy(remoteFile, progressReader)
 	if err != nil {
 		return fmt.Errorf("failed to copy file: %w", err)
@@ -152,7 +213,7 @@ func UploadToSSHOriginal(localPath string, config SSHConfig, verbose bool) error
 	// Calculate and log statistics
 	elapsed := time.Since(startTime).Seconds()
 	fileSizeMB := float64(fileInfo.Size()) / 1024 / 1024
-	mbPerSec := fileSizeMB / elapsed
+	mbPerSec := float64(bytesCopied) / 1024 / 1024 / elapsed
 
 	sugar.Infof("SSH upload completed: %.2f MB transferred (%.2f MB/s)", fileSizeMB, mbPerSec)
 	sugar.Infof("Remote file: %s", remoteFilePath)
@@ -161,98 +222,214 @@ func UploadToSSHOriginal(localPath string, config SSHConfig, verbose bool) error
 	return nil
 }
 
-// sshAgentAuth attempts to connect to SSH agent for authentication
-func sshAgentAuth() (ssh.AuthMethod, error) {
-	agentSock := os.Getenv("SSH_AUTH_SOCK")
-	if agentSock == "" {
-		return nil, fmt.Errorf("SSH_AUTH_SOCK not set")
+// closeOnCancel closes client once ctx is canceled, so a transfer blocked on
+// an unresponsive server fails instead of hanging. stop releases the watch.
+func closeOnCancel(ctx context.Context, client io.Closer) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		client.Close()
+	})
+}
+
+// connectSFTP opens an SSH connection and an SFTP session on top of it,
+// giving up when ctx is canceled. The caller must close both clients.
+func connectSFTP(ctx context.Context, config SSHConfig) (*ssh.Client, *sftp.Client, error) {
+	callback, algorithms, err := hostKeyCallback(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Configure SSH client
+	sshConfig := &ssh.ClientConfig{
+		User:              config.User,
+		HostKeyCallback:   callback,
+		HostKeyAlgorithms: algorithms,
+		Timeout:           30 * time.Second,
+	}
+
+	// Configure authentication
+	if config.KeyFile != "" {
+		auth, err := keyAuth(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		sshConfig.Auth = auth
+	} else if config.Password != "" {
+		sshConfig.Auth = []ssh.AuthMethod{ssh.Password(config.Password)}
+	} else {
+		// Use the SSH agent, or keys in default locations
+		auth, err := keyAuth(config)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sshConfig.Auth = auth
 	}
 
-	conn, err := net.Dial("unix", agentSock)
+	// Connect to SSH server
+	sshClient, err := dialSSH(ctx, sshAddr(config), sshConfig, config.KeepAlive)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+		return nil, nil, fmt.Errorf("failed to connect to SSH server: %w", err)
 	}
 
-	agentClient := agent.NewClient(conn)
-	return ssh.PublicKeysCallback(agentClient.Signers), nil
+	// Create SFTP client with balanced performance optimizations
+	sftpClient, err := sftp.NewClient(sshClient,
+		sftp.UseConcurrentReads(true),
+		sftp.UseConcurrentWrites(true),
+		sftp.MaxConcurrentRequestsPerFile(sftpRequests(config)),
+		sftp.MaxPacketUnchecked(sftpPacketSize), // 256KB packets (stable size)
+	)
+	if err != nil {
+		sshClient.Close()
+		return nil, nil, fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+
+	return sshClient, sftpClient, nil
+
 }
 
-// progressReader wraps an io.Reader to provide upload progress reporting
+// progressReader wraps an io.Reader to provide upload progress reporting.
+// A total of zero or less means the size is not known in advance.
 type progressReader struct {
-	reader      io.Reader
-	total       int64
+	reader io.Reader
+	total  int64
+	// offset is where a resumed transfer started; transferred starts there
+	// too, so the percentage covers the whole file while the speed counts
+	// only what this attempt sent
+	offset      int64
 	transferred int64
 	startTime   time.Time
 	sugar       *zap.SugaredLogger
 	lastReport  time.Time
+	// action names the transfer in progress messages, "Upload" if empty
+	action string
+}
+
+func (pr *progressReader) label() string {
+	if pr.action == "" {
+		return "Upload"
+	}
+	return pr.action
 }
 
 func (pr *progressReader) Read(p []byte) (int, error) {
 	n, err := pr.reader.Read(p)
 	pr.transferred += int64(n)
-	
-	// Report progress every 5 seconds or at completion
+
+	// Report progress every interval or at completion
 	now := time.Now()
-	if now.Sub(pr.lastReport) >= 5*time.Second || pr.transferred == pr.total || err == io.EOF {
+	if now.Sub(pr.lastReport) >= progress.Interval() || pr.transferred == pr.total || err == io.EOF {
 		pr.lastReport = now
-		
+
+		eventType := progress.TypeProgress
+		if pr.transferred == pr.total || err == io.EOF {
+			eventType = progress.TypeDone
+		}
+		progress.ReportResumed(eventType, pr.label(), pr.offset, pr.transferred, pr.total, pr.startTime)
+
 		elapsed := now.Sub(pr.startTime).Seconds()
-		if elapsed > 0 {
+		mbPerSec := float64(pr.transferred-pr.offset) / 1024 / 1024 / elapsed
+		if elapsed > 0 && pr.total <= 0 {
+			transferredMB := float64(pr.transferred) / 1024 / 1024
+			if err == io.EOF {
+				pr.sugar.Infof("%s completed: %.2f MB (%.2f MB/s)", pr.label(), transferredMB, mbPerSec)
+			} else {
+				pr.sugar.Infof("%s progress: %.2f MB (%.2f MB/s)", pr.label(), transferredMB, mbPerSec)
+			}
+		} else if elapsed > 0 {
 			percentage := float64(pr.transferred) / float64(pr.total) * 100
 			transferredMB := float64(pr.transferred) / 1024 / 1024
 			totalMB := float64(pr.total) / 1024 / 1024
-			mbPerSec := transferredMB / elapsed
-			
+
 			if pr.transferred == pr.total || err == io.EOF {
-				pr.sugar.Infof("Upload completed: %.2f MB (%.2f MB/s)", totalMB, mbPerSec)
+				pr.sugar.Infof("%s completed: %.2f MB (%.2f MB/s)", pr.label(), totalMB, mbPerSec)
 			} else {
-				pr.sugar.Infof("Upload progress: %.1f%% (%.2f/%.2f MB, %.2f MB/s)", 
-					percentage, transferredMB, totalMB, mbPerSec)
+				pr.sugar.Infof("%s progress: %.1f%% (%.2f/%.2f MB, %.2f MB/s)",
+					pr.label(), percentage, transferredMB, totalMB, mbPerSec)
 			}
 		}
 	}
-	
+
 	return n, err
 }
 
-// tryDefaultKeys attempts to load SSH keys from default locations
-func tryDefaultKeys() ([]ssh.AuthMethod, error) {
-	var authMethods []ssh.AuthMethod
-	
-	// Get user home directory
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+// contextReader fails every read with ctx's error once ctx is canceled, so
+// a copy from it stops
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
 	}
-	
+	return r.reader.Read(p)
+}
+
+// tryDefaultKeys attempts to load SSH keys from default locations. Encrypted
+// keys are decrypted only when a passphrase is configured or no unencrypted
+// key is found, so a usable key never causes a passphrase prompt. All keys go
+// in a single auth method, since the SSH client only tries the first
+// "publickey" method it is given.
+func tryDefaultKeys(config SSHConfig) ([]ssh.AuthMethod, error) {
+	var signers []ssh.Signer
+	var encrypted []string
+
 	// Common SSH key locations
-	keyPaths := []string{
-		filepath.Join(home, ".ssh", "id_rsa"),
-		filepath.Join(home, ".ssh", "id_ed25519"),
-		filepath.Join(home, ".ssh", "id_ecdsa"),
+	keyPaths, err := defaultKeyPaths()
+	if err != nil {
+		return nil, err
 	}
-	
+
 	for _, keyPath := range keyPaths {
 		if _, err := os.Stat(keyPath); os.IsNotExist(err) {
 			continue
 		}
-		
+
 		key, err := os.ReadFile(keyPath)
 		if err != nil {
 			continue // Skip this key if we can't read it
 		}
-		
+
 		signer, err := ssh.ParsePrivateKey(key)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			encrypted = append(encrypted, keyPath)
+			continue
+		}
 		if err != nil {
 			continue // Skip this key if we can't parse it
 		}
-		
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
+
+		found, err := keySigners(signer, keyPath, "")
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, found...)
 	}
-	
-	if len(authMethods) == 0 {
+
+	if len(signers) == 0 || config.KeyPassphrase != "" {
+		var lastErr error
+		for _, keyPath := range encrypted {
+			signer, err := loadKey(keyPath, config)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			found, err := keySigners(signer, keyPath, "")
+			if err != nil {
+				return nil, err
+			}
+			signers = append(signers, found...)
+		}
+		if len(signers) == 0 && lastErr != nil {
+			return nil, lastErr
+		}
+	}
+
+	if len(signers) == 0 {
 		return nil, fmt.Errorf("no valid SSH keys found in default locations")
 	}
-	
-	return authMethods, nil
-}
\ No newline at end of file
+
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, nil
+}
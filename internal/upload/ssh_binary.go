@@ -1,98 +1,125 @@
 package upload
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"backup-home/internal/logging"
+
+	"github.com/rclone/rclone/fs"
 )
 
 // UploadToSSHBinary uploads using system scp binary for maximum performance verification
-func UploadToSSHBinary(localPath string, config SSHConfig, verbose bool) error {
+func UploadToSSHBinary(ctx context.Context, localPath string, config SSHConfig, verbose bool) error {
 	sugar := logging.GetSugar()
-	
+
 	sugar.Infof("Starting binary scp upload to %s@%s:%s using system scp command", config.User, config.Host, config.Port)
 	startTime := time.Now()
-	
+
 	// Get file info
 	fileInfo, err := os.Stat(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to stat local file: %w", err)
 	}
-	
+
 	// Build remote path with date directory structure
-	hostname, _ := os.Hostname()
-	currentTime := time.Now()
-	dateDir := currentTime.Format("2006-01-02")
-	remotePath := filepath.Join(config.RemotePath, hostname, "Users", dateDir)
-	
+	remotePath := remoteDir(config)
+
 	// Create remote directory first via SSH
-	mkdirArgs := []string{
-		config.User + "@" + config.Host,
-		fmt.Sprintf("mkdir -p %s", remotePath),
-	}
-	
-	if config.Port != "" && config.Port != "22" {
-		mkdirArgs = append([]string{"-p", config.Port}, mkdirArgs...)
-	}
-	if config.KeyFile != "" {
-		mkdirArgs = append([]string{"-i", config.KeyFile}, mkdirArgs...)
-	}
-	
 	sugar.Infof("Creating remote directory: %s", remotePath)
-	mkdirCmd := exec.Command("ssh", mkdirArgs...)
-	if err := mkdirCmd.Run(); err != nil {
-		return fmt.Errorf("failed to create remote directory: %w", err)
+	mkdirCmd := exec.CommandContext(ctx, "ssh", sshCommandArgs(config, fmt.Sprintf("mkdir -p %s", remotePath))...)
+	if output, err := mkdirCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create remote directory: %w: %s", err, strings.TrimSpace(string(output)))
 	}
-	
+
 	// Build scp command arguments
 	fileName := filepath.Base(localPath)
 	remoteTarget := fmt.Sprintf("%s@%s:%s/%s", config.User, config.Host, remotePath, fileName)
-	
-	scpArgs := []string{}
-	
+
+	scpArgs := append(hostKeyOptions(config), keepAliveOptions(config)...)
+
 	// Add port if not default
 	if config.Port != "" && config.Port != "22" {
 		scpArgs = append(scpArgs, "-P", config.Port)
 	}
-	
+
 	// Add key file if specified
-	if config.KeyFile != "" {
-		scpArgs = append(scpArgs, "-i", config.KeyFile)
+	scpArgs = append(scpArgs, keyOptions(config)...)
+
+	// scp cannot change its limit midway, so the schedule's current one holds
+	if limit := uploadLimitAt(config.BwLimit, time.Now()); limit > 0 {
+		sugar.Infof("Bandwidth limit: %s/s for the whole upload (the binary method cannot follow a schedule)", fs.SizeSuffix(limit).ByteUnit())
+		scpArgs = append(scpArgs, "-l", strconv.FormatInt(max(limit*8/1000, 1), 10))
 	}
-	
+
 	// Add verbose flag
 	if verbose {
 		scpArgs = append(scpArgs, "-v")
 	}
-	
+
 	// Add source and destination
 	scpArgs = append(scpArgs, localPath, remoteTarget)
-	
+
 	sugar.Infof("Uploading %s to %s", localPath, remoteTarget)
 	sugar.Infof("File size: %.2f MB", float64(fileInfo.Size())/1024/1024)
 	sugar.Debugf("Running: scp %v", scpArgs)
-	
+
 	// Execute scp command
-	scpCmd := exec.Command("scp", scpArgs...)
+	scpCmd := exec.CommandContext(ctx, "scp", scpArgs...)
 	scpCmd.Stdout = os.Stdout
-	scpCmd.Stderr = os.Stderr
-	
+	// Keep stderr to tell network failures from others when deciding to retry
+	var stderr bytes.Buffer
+	scpCmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+
 	err = scpCmd.Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if err != nil {
-		return fmt.Errorf("scp command failed: %w", err)
+		return fmt.Errorf("scp command failed: %w: %s", err, lastLine(stderr.String()))
 	}
-	
+
 	// Calculate and display upload statistics
 	duration := time.Since(startTime)
 	sizeMB := float64(fileInfo.Size()) / 1024 / 1024
 	mbPerSec := sizeMB / duration.Seconds()
-	
+
 	sugar.Infof("Binary scp upload completed successfully!")
 	sugar.Infof("Uploaded %.2f MB in %s (%.2f MB/s)", sizeMB, duration.Round(time.Second), mbPerSec)
-	
+
+	if command := permissionsCommand(config, path.Join(remotePath, fileName)); command != "" {
+		sugar.Infof("Setting remote permissions: %s", command)
+		output, err := exec.CommandContext(ctx, "ssh", sshCommandArgs(config, command)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to set remote permissions: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+
 	return nil
-}
\ No newline at end of file
+}
+
+// sshCommandArgs returns the ssh binary arguments that run command on the remote host
+func sshCommandArgs(config SSHConfig, command string) []string {
+	args := append(hostKeyOptions(config), keepAliveOptions(config)...)
+	args = append(args, config.User+"@"+config.Host, command)
+	if config.Port != "" && config.Port != "22" {
+		args = append([]string{"-p", config.Port}, args...)
+	}
+	return append(keyOptions(config), args...)
+}
+
+// lastLine returns the last non-empty line of output, which is where scp and
+// ssh print the reason they failed
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
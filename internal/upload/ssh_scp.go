@@ -15,108 +15,99 @@ import (
 )
 
 // UploadToSSHSCP uploads a backup file using native SCP protocol for maximum speed
-func UploadToSSHSCP(localPath string, config SSHConfig, verbose bool) error {
+func UploadToSSHSCP(ctx context.Context, localPath string, config SSHConfig, verbose bool) error {
 	sugar := logging.GetSugar()
-	
+
 	sugar.Infof("Starting SCP upload to %s@%s:%s using native SCP protocol", config.User, config.Host, config.Port)
 	startTime := time.Now()
-	
+
 	// Get file info
 	fileInfo, err := os.Stat(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to stat local file: %w", err)
 	}
-	
+
+	callback, algorithms, err := hostKeyCallback(config)
+	if err != nil {
+		return err
+	}
+
 	// Configure authentication
 	var clientConfig ssh.ClientConfig
-	
+
 	if config.KeyFile != "" {
-		// Use specified key file
-		clientConfig, err = auth.PrivateKey(config.User, config.KeyFile, ssh.InsecureIgnoreHostKey())
+		// Use specified key file, decrypting it if needed
+		keys, err := keyAuth(config)
 		if err != nil {
-			return fmt.Errorf("failed to load SSH key: %w", err)
+			return err
 		}
+		clientConfig = ssh.ClientConfig{User: config.User, Auth: keys, HostKeyCallback: callback}
 		sugar.Debugf("Using SSH key from: %s", config.KeyFile)
 	} else if config.Password != "" {
 		// Use password
-		clientConfig, err = auth.PasswordKey(config.User, config.Password, ssh.InsecureIgnoreHostKey())
+		clientConfig, err = auth.PasswordKey(config.User, config.Password, callback)
 		if err != nil {
 			return fmt.Errorf("failed to configure password authentication: %w", err)
 		}
 		sugar.Debugf("Using password authentication")
 	} else {
-		// Try default key locations
-		sugar.Debugf("Checking for SSH keys in default locations")
-		
-		home, _ := os.UserHomeDir()
-		keyPaths := []string{
-			filepath.Join(home, ".ssh", "id_ed25519"),
-			filepath.Join(home, ".ssh", "id_rsa"),
-			filepath.Join(home, ".ssh", "id_ecdsa"),
-		}
-		
-		for _, keyPath := range keyPaths {
-			if _, err := os.Stat(keyPath); err == nil {
-				clientConfig, err = auth.PrivateKey(config.User, keyPath, ssh.InsecureIgnoreHostKey())
-				if err == nil {
-					sugar.Debugf("Using SSH key: %s", keyPath)
-					break
-				}
-			}
-		}
-		
-		if clientConfig.User == "" {
-			return fmt.Errorf("no SSH keys found in default locations")
+		// Use the SSH agent, or keys in default locations
+		keys, err := keyAuth(config)
+		if err != nil {
+			return err
 		}
+		clientConfig = ssh.ClientConfig{User: config.User, Auth: keys, HostKeyCallback: callback}
 	}
-	
+
+	clientConfig.HostKeyAlgorithms = algorithms
+	clientConfig.Timeout = 30 * time.Second
+
 	// Create SCP client
-	scpClient := scp.NewClient(fmt.Sprintf("%s:%s", config.Host, config.Port), &clientConfig)
-	
+	scpClient := scp.NewClient(sshAddr(config), &clientConfig)
+
 	// Connect to the remote server
 	err = scpClient.Connect()
 	if err != nil {
 		return fmt.Errorf("failed to connect to SSH server: %w", err)
 	}
 	defer scpClient.Close()
-	
+	defer closeOnCancel(ctx, scpClient.SSHClient())()
+	startKeepAlive(scpClient.SSHClient(), config.KeepAlive)
+
 	// Build remote path with date directory structure
-	hostname, _ := os.Hostname()
-	currentTime := time.Now()
-	dateDir := currentTime.Format("2006-01-02")
-	remotePath := filepath.Join(config.RemotePath, hostname, "Users", dateDir)
-	
+	remotePath := remoteDir(config)
+
 	// Create remote directory using SSH session
 	session, err := scpClient.SSHClient().NewSession()
 	if err != nil {
 		return fmt.Errorf("failed to create SSH session: %w", err)
 	}
-	
+
 	sugar.Infof("Creating remote directory: %s", remotePath)
 	_, err = session.CombinedOutput(fmt.Sprintf("mkdir -p %s", remotePath))
 	session.Close()
 	if err != nil {
 		return fmt.Errorf("failed to create remote directory: %w", err)
 	}
-	
+
 	// Build full remote file path
 	fileName := filepath.Base(localPath)
 	remoteFile := filepath.Join(remotePath, fileName)
-	
+
 	// Open local file
 	localFile, err := os.Open(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to open local file: %w", err)
 	}
 	defer localFile.Close()
-	
+
 	sugar.Infof("Uploading %s to %s", localPath, remoteFile)
 	sugar.Infof("File size: %.2f MB", float64(fileInfo.Size())/1024/1024)
-	
+
 	// Upload using SCP protocol with progress tracking
-	err = scpClient.CopyFromFilePassThru(context.Background(), *localFile, remoteFile, "0644", func(r io.Reader, total int64) io.Reader {
+	err = scpClient.CopyFromFilePassThru(ctx, *localFile, remoteFile, "0644", func(r io.Reader, total int64) io.Reader {
 		return &progressReader{
-			reader:    r,
+			reader:    newBwLimitedReader(ctx, r, config.BwLimit),
 			total:     total,
 			startTime: startTime,
 			sugar:     sugar,
@@ -125,15 +116,15 @@ func UploadToSSHSCP(localPath string, config SSHConfig, verbose bool) error {
 	if err != nil {
 		return fmt.Errorf("failed to upload file: %w", err)
 	}
-	
+
 	// Calculate and display upload statistics
 	duration := time.Since(startTime)
 	sizeMB := float64(fileInfo.Size()) / 1024 / 1024
 	mbPerSec := sizeMB / duration.Seconds()
-	
+
 	sugar.Infof("SCP upload completed successfully!")
 	sugar.Infof("Uploaded %.2f MB in %s (%.2f MB/s)", sizeMB, duration.Round(time.Second), mbPerSec)
 	sugar.Infof("Remote path: %s:%s", config.Host, remoteFile)
-	
+
 	return nil
-}
\ No newline at end of file
+}
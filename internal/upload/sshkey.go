@@ -0,0 +1,166 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"backup-home/internal/logging"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// KeyPassphraseEnv is the environment variable read for the passphrase of an
+// encrypted SSH key when none is given on the command line
+const KeyPassphraseEnv = "BACKUP_HOME_SSH_KEY_PASSPHRASE"
+
+// loadKey reads the private key at path, decrypting it with the passphrase
+// of config, or one asked for with config.PromptPassphrase, when it is
+// encrypted
+func loadKey(path string, config SSHConfig) (ssh.Signer, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+
+	passphrase := config.KeyPassphrase
+	if passphrase == "" {
+		if config.PromptPassphrase == nil {
+			return nil, fmt.Errorf("SSH key %s is encrypted: a passphrase is required", path)
+		}
+		if passphrase, err = config.PromptPassphrase(path); err != nil {
+			return nil, fmt.Errorf("failed to read passphrase for SSH key %s: %w", path, err)
+		}
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SSH key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// defaultKeyPaths lists the private keys tried when no key file or password
+// is configured
+func defaultKeyPaths() ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return []string{
+		filepath.Join(home, ".ssh", "id_ed25519"),
+		filepath.Join(home, ".ssh", "id_rsa"),
+		filepath.Join(home, ".ssh", "id_ecdsa"),
+	}, nil
+}
+
+// certSuffix is appended to a private key path to find its certificate, as
+// ssh does
+const certSuffix = "-cert.pub"
+
+// keySigners returns signer, preceded by a signer presenting its certificate
+// when certFile is given or a certificate lies next to keyPath
+func keySigners(signer ssh.Signer, keyPath, certFile string) ([]ssh.Signer, error) {
+	explicit := certFile != ""
+	if !explicit {
+		certFile = keyPath + certSuffix
+	}
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			return []ssh.Signer{signer}, nil
+		}
+		return nil, fmt.Errorf("failed to read SSH certificate: %w", err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH certificate %s: %w", certFile, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an SSH certificate", certFile)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("SSH certificate %s does not belong to key %s: %w", certFile, keyPath, err)
+	}
+	return []ssh.Signer{certSigner, signer}, nil
+}
+
+// agentSigners returns the keys and certificates loaded in the SSH agent at
+// $SSH_AUTH_SOCK. The agent connection is left open, since the signers use
+// it during the handshake.
+func agentSigners() ([]ssh.Signer, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to list SSH agent keys: %w", err)
+	}
+	if len(signers) == 0 {
+		conn.Close()
+	}
+	return signers, nil
+}
+
+// keyAuth returns the public key authentication of config: its key file, or
+// else the keys in the SSH agent, or the keys found in default locations when
+// the agent is not running or holds no keys. A certificate next to a key is
+// offered along with it.
+func keyAuth(config SSHConfig) ([]ssh.AuthMethod, error) {
+	sugar := logging.GetSugar()
+
+	if config.KeyFile != "" {
+		signer, err := loadKey(config.KeyFile, config)
+		if err != nil {
+			return nil, err
+		}
+		signers, err := keySigners(signer, config.KeyFile, config.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, nil
+	}
+
+	signers, err := agentSigners()
+	if err != nil {
+		sugar.Debugf("Not using the SSH agent: %v", err)
+	} else if len(signers) > 0 {
+		sugar.Debugf("Using %d keys from the SSH agent", len(signers))
+		return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, nil
+	}
+
+	sugar.Debugf("Checking for SSH keys in default locations")
+	return tryDefaultKeys(config)
+}
+
+// keyOptions returns the ssh and scp binary options selecting the key file
+// and certificate of config
+func keyOptions(config SSHConfig) []string {
+	var options []string
+	if config.KeyFile != "" {
+		options = append(options, "-i", config.KeyFile)
+	}
+	if config.CertFile != "" {
+		options = append(options, "-o", "CertificateFile="+config.CertFile)
+	}
+	return options
+}
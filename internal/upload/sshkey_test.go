@@ -0,0 +1,90 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TestDefaultKeysOfferEncryptedKey checks that a passphrase-protected default
+// key is offered even when an unencrypted one is found first
+func TestDefaultKeysOfferEncryptedKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	sshDir := filepath.Join(home, ".ssh")
+	if err := os.Mkdir(sshDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	_, plainKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainBlock, err := ssh.MarshalPrivateKey(plainKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sshDir, "id_ed25519"), pem.EncodeToMemory(plainBlock), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, encryptedKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedBlock, err := ssh.MarshalPrivateKeyWithPassphrase(encryptedKey, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sshDir, "id_ecdsa"), pem.EncodeToMemory(encryptedBlock), 0600); err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := ssh.NewPublicKey(encryptedKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		// Only the second, passphrase-protected key may log in
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), accepted.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+	addr := serveTestSSH(t, serverConfig)
+
+	auth, err := tryDefaultKeys(SSHConfig{KeyPassphrase: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := dialSSH(context.Background(), addr, &ssh.ClientConfig{
+		User:            "test",
+		Auth:            auth,
+		HostKeyCallback: ssh.FixedHostKey(hostSigner.PublicKey()),
+		Timeout:         5 * time.Second,
+	}, 0)
+	if err != nil {
+		t.Fatalf("logging in with the passphrase-protected default key: %v", err)
+	}
+	client.Close()
+}
@@ -0,0 +1,137 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"backup-home/internal/logging"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/librclone/librclone"
+)
+
+// StreamToSSH uploads data read from r to a remote file named fileName over
+// SFTP. The size does not need to be known in advance. The upload stops when
+// ctx is canceled.
+func StreamToSSH(ctx context.Context, r io.Reader, fileName string, config SSHConfig, verbose bool) error {
+	sugar := logging.GetSugar()
+
+	sugar.Infof("Starting SSH stream upload to %s@%s:%s", config.User, config.Host, config.Port)
+	startTime := time.Now()
+
+	sshClient, sftpClient, err := connectSFTP(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+	defer sftpClient.Close()
+	defer closeOnCancel(ctx, sshClient)()
+
+	remotePath := remoteDir(config)
+	sugar.Debugf("Creating remote directory: %s", remotePath)
+	if err := sftpClient.MkdirAll(remotePath); err != nil {
+		return fmt.Errorf("failed to create remote directory: %w", err)
+	}
+
+	remoteFilePath := path.Join(remotePath, fileName)
+	sugar.Infof("Streaming to: %s", remoteFilePath)
+
+	remoteFile, err := sftpClient.Create(remoteFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file: %w", err)
+	}
+
+	progressReader := &progressReader{
+		reader:    &contextReader{ctx: ctx, reader: newBwLimitedReader(ctx, r, config.BwLimit)},
+		startTime: startTime,
+		sugar:     sugar,
+	}
+
+	bytesCopied, err := io.Copy(remoteFile, progressReader)
+	closeErr := remoteFile.Close()
+	if err != nil {
+		err = fmt.Errorf("failed to stream file: %w", err)
+	} else if closeErr != nil {
+		err = fmt.Errorf("failed to close remote file: %w", closeErr)
+	}
+	if err != nil {
+		// The archive ends wherever the stream broke off, so it must not be
+		// left where it looks like a finished backup
+		if removeErr := sftpClient.Remove(remoteFilePath); removeErr != nil {
+			sugar.Warnf("Failed to remove incomplete remote file %s: %v", remoteFilePath, removeErr)
+		} else {
+			sugar.Infof("Removed incomplete remote file: %s", remoteFilePath)
+		}
+		return err
+	}
+
+	elapsed := time.Since(startTime).Seconds()
+	sizeMB := float64(bytesCopied) / 1024 / 1024
+	sugar.Infof("SSH stream upload completed: %.2f MB transferred (%.2f MB/s)", sizeMB, sizeMB/elapsed)
+	sugar.Infof("Remote file: %s", remoteFilePath)
+
+	if err := fixRemotePermissions(sshClient, config, remoteFilePath); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// StreamToRclone uploads data read from r to fileName on an rclone destination.
+// The size does not need to be known in advance. The upload stops when ctx is
+// canceled.
+func StreamToRclone(ctx context.Context, r io.Reader, fileName string, config RcloneConfig, verbose bool) error {
+	if err := logging.InitLogger(verbose); err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer logging.SyncLogger()
+
+	sugar = logging.GetSugar()
+
+	sugar.Infof("Streaming backup to: %s", config.Destination)
+	startTime := time.Now()
+
+	// Initialize librclone to load the rclone config
+	startRclone()
+	applyRcloneBwLimit(config)
+	defer librclone.Finalize()
+
+	ctx, err := withRcloneFlags(ctx, config)
+	if err != nil {
+		return err
+	}
+	fdst, err := fs.NewFs(ctx, config.Destination)
+	if err != nil {
+		return fmt.Errorf("failed to open rclone destination: %w", err)
+	}
+
+	dstRemote := fileName
+	if config.Dated {
+		subdir := datedSubdir(config.DateFormat, config.Subdir)
+		if err := rcloneMkdir(config.Destination, subdir); err != nil {
+			return err
+		}
+		dstRemote = path.Join(subdir, fileName)
+	}
+
+	progressReader := &progressReader{
+		reader:    r,
+		startTime: startTime,
+		sugar:     sugar,
+	}
+
+	obj, err := operations.Rcat(ctx, fdst, dstRemote, io.NopCloser(progressReader), time.Now(), nil)
+	if err != nil {
+		return fmt.Errorf("rclone stream upload failed: %w", err)
+	}
+
+	elapsed := time.Since(startTime).Seconds()
+	sizeMB := float64(obj.Size()) / 1024 / 1024
+	sugar.Infof("Upload completed: %.2f MB transferred (%.2f MB/s)", sizeMB, sizeMB/elapsed)
+	sugar.Infof("Remote file: %s (on %s)", dstRemote, config.Destination)
+	return nil
+}
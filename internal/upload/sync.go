@@ -0,0 +1,117 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"backup-home/internal/logging"
+
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/librclone/librclone"
+)
+
+// SyncFilter selects the files of the source a sync mirrors, in the form of
+// rclone's _filter options
+type SyncFilter struct {
+	// Rules are rclone filter rules, "+ pattern" or "- pattern", applied in
+	// order
+	Rules []string `json:"FilterRule,omitempty"`
+	// MinSize and MaxSize leave out files smaller or larger than this many
+	// bytes; zero disables the limit
+	MinSize int64 `json:"MinSize,omitempty"`
+	MaxSize int64 `json:"MaxSize,omitempty"`
+	// IgnoreCase matches the rules case-insensitively, as on Windows
+	IgnoreCase bool `json:"IgnoreCase,omitempty"`
+}
+
+type syncRequest struct {
+	SrcFs              string                 `json:"srcFs"`
+	DstFs              string                 `json:"dstFs"`
+	CreateEmptySrcDirs bool                   `json:"createEmptySrcDirs"`
+	Async              bool                   `json:"_async"`
+	Config             map[string]interface{} `json:"_config,omitempty"`
+	Filter             SyncFilter             `json:"_filter"`
+}
+
+// SyncToRclone mirrors the source directory file by file to an rclone
+// destination with rclone's sync, instead of uploading an archive: files
+// missing from the source, or left out by filter, are deleted from the
+// destination. With config.Dated each run mirrors into a new dated
+// subdirectory. When ctx is canceled the sync job is stopped.
+func SyncToRclone(ctx context.Context, source string, config RcloneConfig, filter SyncFilter, verbose bool) error {
+	if err := logging.InitLogger(verbose); err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer logging.SyncLogger()
+	sugar = logging.GetSugar()
+
+	destination := config.Destination
+	if config.Dated {
+		destination = rcloneJoin(destination, datedSubdir(config.DateFormat, config.Subdir))
+	}
+	sugar.Infof("Mirroring %s to: %s", source, destination)
+	startTime := time.Now()
+
+	startRclone()
+	applyRcloneBwLimit(config)
+	defer librclone.Finalize()
+
+	reqJSON, err := json.Marshal(syncRequest{
+		SrcFs:              source,
+		DstFs:              destination,
+		CreateEmptySrcDirs: true,
+		Async:              true,
+		Config:             config.Flags,
+		Filter:             filter,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	out, status := librclone.RPC("sync/sync", string(reqJSON))
+	if status != 0 && status != 200 {
+		return fmt.Errorf("rclone sync failed: %w", rcloneError(status, out))
+	}
+	var job jobRequest
+	if err := json.Unmarshal([]byte(out), &job); err != nil {
+		return fmt.Errorf("failed to parse rclone job response: %w", err)
+	}
+	if err := waitForRcloneJob(ctx, job.JobID, 0, startTime, "Sync"); err != nil {
+		return fmt.Errorf("rclone sync failed: %w", err)
+	}
+
+	sugar.Infof("Sync completed in %s: %s mirrors %s", time.Since(startTime).Round(time.Second), destination, source)
+	return nil
+}
+
+// CheckSyncDestination refuses to mirror into the root of a remote, or of
+// the bucket given with --s3: the sync deletes everything there that is not
+// in the source, including archives and any other data
+func CheckSyncDestination(destination string) error {
+	remoteName, remotePath, err := fspath.SplitFs(destination)
+	if err != nil {
+		return fmt.Errorf("invalid rclone destination %q: %w", destination, err)
+	}
+	remotePath = strings.Trim(remotePath, "/")
+	if remoteName == s3RemoteName+":" {
+		// The first element is the bucket
+		_, remotePath, _ = strings.Cut(remotePath, "/")
+	}
+	if remotePath == "" {
+		return fmt.Errorf("%s is the root of the remote; rclone sync deletes everything there that is not in the source, so mirror into a subdirectory", destination)
+	}
+	return nil
+}
+
+// rcloneJoin appends the slash-separated remote path to an rclone
+// destination such as "drive:" or "drive:backup"
+func rcloneJoin(destination, remote string) string {
+	if strings.HasSuffix(destination, ":") {
+		return destination + remote
+	}
+	return path.Join(destination, remote)
+}
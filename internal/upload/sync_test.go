@@ -0,0 +1,28 @@
+package upload
+
+import "testing"
+
+func TestCheckSyncDestination(t *testing.T) {
+	tests := []struct {
+		destination string
+		ok          bool
+	}{
+		{"drive:", false},
+		{"drive:/", false},
+		{"drive:mirror", true},
+		{"drive:backups/mirror/", true},
+		{"/srv/mirror", true},
+		// For --s3 the first element is the bucket
+		{s3RemoteName + ":bucket", false},
+		{s3RemoteName + ":bucket/", false},
+		{s3RemoteName + ":bucket/mirror", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.destination, func(t *testing.T) {
+			err := CheckSyncDestination(tt.destination)
+			if (err == nil) != tt.ok {
+				t.Fatalf("CheckSyncDestination(%q) = %v, want ok %v", tt.destination, err, tt.ok)
+			}
+		})
+	}
+}
@@ -1,14 +1,19 @@
 package upload
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"backup-home/internal/logging"
+	"backup-home/internal/progress"
 
+	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/librclone/librclone"
 	"go.uber.org/zap"
 )
@@ -16,39 +21,131 @@ import (
 // Initialize sugar variable at package level for convenience
 var sugar *zap.SugaredLogger
 
+// RcloneConfig holds rclone upload configuration
+type RcloneConfig struct {
+	Destination string
+	// Dated uploads into hostname/Users/date subdirectories like the SSH upload
+	Dated bool
+	// DateFormat is the Go time layout of the date subdirectory
+	DateFormat string
+	// Subdir replaces the hostname/Users/date subdirectory of dated uploads,
+	// as rendered by RenderRemoteTemplate; empty uses the default layout
+	Subdir string
+	// Flags overrides rclone's global options for transfers, as built by
+	// ParseRcloneFlags
+	Flags map[string]interface{}
+	// BwLimit is the upload bandwidth schedule, as parsed by
+	// ParseBwSchedule; empty uploads at full speed
+	BwLimit fs.BwTimetable
+}
+
 type copyFileRequest struct {
 	SrcFs     string `json:"srcFs"`
 	SrcRemote string `json:"srcRemote"`
 	DstFs     string `json:"dstFs"`
 	DstRemote string `json:"dstRemote"`
+	Async     bool   `json:"_async,omitempty"`
+	// Config overrides rclone's global options for this call
+	Config map[string]interface{} `json:"_config,omitempty"`
+}
+
+type jobRequest struct {
+	JobID int64 `json:"jobid"`
+}
+
+type jobStatusResponse struct {
+	Finished bool   `json:"finished"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error"`
+}
+
+type coreStatsRequest struct {
+	Group string `json:"group"`
+}
+
+type coreStatsResponse struct {
+	Bytes int64   `json:"bytes"`
+	Speed float64 `json:"speed"`
 }
 
-func UploadToRclone(source, destination string, verbose bool) error {
-	// Initialize logger
+// remoteRequest names a path on an rclone remote, as taken by operations/mkdir and operations/purge
+type remoteRequest struct {
+	Fs     string `json:"fs"`
+	Remote string `json:"remote"`
+}
+
+// rcloneInit guards the initialization in initRclone
+var rcloneInit struct {
+	sync.Mutex
+	once sync.Once
+}
+
+// initRclone initializes the logger, the package's sugar reference and
+// librclone for an upload. Uploads to several remotes may run at once, so
+// librclone, whose setup replaces global state, is only initialized once.
+func initRclone(verbose bool) error {
+	rcloneInit.Lock()
+	defer rcloneInit.Unlock()
+
 	if err := logging.InitLogger(verbose); err != nil {
 		return fmt.Errorf("failed to initialize logger: %w", err)
 	}
-	defer logging.SyncLogger()
+	if current := logging.GetSugar(); sugar != current {
+		sugar = current
+	}
+	startRclone()
+	return nil
+}
 
-	// Get the sugar reference for this package
-	sugar = logging.GetSugar()
+// startRclone initializes librclone once per process. Its setup replaces
+// global state and starts rclone's accounting, so every further call would
+// start another bandwidth ticker.
+func startRclone() {
+	rcloneInit.once.Do(librclone.Initialize)
+}
+
+// UploadToRclone uploads a backup file to an rclone destination. When ctx is
+// canceled the transfer job is stopped.
+func UploadToRclone(ctx context.Context, source string, config RcloneConfig, verbose bool) error {
+	// Initialize logger and librclone
+	if err := initRclone(verbose); err != nil {
+		return err
+	}
+	applyRcloneBwLimit(config)
+	defer logging.SyncLogger()
+	defer librclone.Finalize()
 
+	destination := config.Destination
 	sugar.Infof("Uploading backup to: %s", destination)
 	startTime := time.Now()
 
-	// Initialize librclone
-	librclone.Initialize()
-	defer librclone.Finalize()
-
 	// Prepare the request
 	srcDir := filepath.Dir(source)
 	srcFile := filepath.Base(source)
 
+	// Create the dated directory structure on the remote first
+	dstRemote := srcFile
+	if config.Dated {
+		subdir := datedSubdir(config.DateFormat, config.Subdir)
+		if err := rcloneMkdir(destination, subdir); err != nil {
+			return err
+		}
+		dstRemote = path.Join(subdir, srcFile)
+	}
+
+	// Get file info for progress tracking
+	fileInfo, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+
 	req := copyFileRequest{
 		SrcFs:     srcDir,
 		SrcRemote: srcFile,
 		DstFs:     destination,
-		DstRemote: srcFile,
+		DstRemote: dstRemote,
+		Async:     true,
+		Config:    config.Flags,
 	}
 
 	reqJSON, err := json.Marshal(req)
@@ -56,22 +153,146 @@ func UploadToRclone(source, destination string, verbose bool) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Execute the copy operation
+	// Start the copy as a background job so progress can be polled
 	out, status := librclone.RPC("operations/copyfile", string(reqJSON))
 	if status != 0 && status != 200 { // Allow both 0 and 200 as success codes
-		return fmt.Errorf("rclone copy failed with status %d: %s", status, out)
+		return fmt.Errorf("rclone copy failed: %w", rcloneError(status, out))
 	}
 
-	// Calculate and log statistics
-	elapsed := time.Since(startTime).Seconds()
-	fileInfo, err := os.Stat(source)
-	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
+	var job jobRequest
+	if err := json.Unmarshal([]byte(out), &job); err != nil {
+		return fmt.Errorf("failed to parse rclone job response: %w", err)
+	}
+
+	if err := waitForRcloneJob(ctx, job.JobID, fileInfo.Size(), startTime, "Upload"); err != nil {
+		return fmt.Errorf("rclone copy failed: %w", err)
 	}
 
+	// Calculate and log statistics
+	elapsed := time.Since(startTime).Seconds()
 	fileSizeMB := float64(fileInfo.Size()) / 1024 / 1024
 	mbPerSec := fileSizeMB / elapsed
 
 	sugar.Infof("Upload completed: %.2f MB transferred (%.2f MB/s)", fileSizeMB, mbPerSec)
+	sugar.Infof("Remote file: %s (on %s)", dstRemote, destination)
+	return nil
+}
+
+// waitForRcloneJob polls an async rclone job until it finishes, logging
+// transfer progress in the same style as the SSH upload. action names the
+// transfer in progress messages. When ctx is canceled the job is stopped and
+// ctx's error returned.
+func waitForRcloneJob(ctx context.Context, jobID int64, total int64, startTime time.Time, action string) error {
+	statusJSON, err := json.Marshal(jobRequest{JobID: jobID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	statsJSON, err := json.Marshal(coreStatsRequest{Group: fmt.Sprintf("job/%d", jobID)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	lastReport := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if out, status := librclone.RPC("job/stop", string(statusJSON)); status != 0 && status != 200 {
+				sugar.Debugf("Failed to stop rclone job %d: %v", jobID, rcloneError(status, out))
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		out, status := librclone.RPC("job/status", string(statusJSON))
+		if status != 0 && status != 200 {
+			return rcloneError(status, out)
+		}
+		var jobStatus jobStatusResponse
+		if err := json.Unmarshal([]byte(out), &jobStatus); err != nil {
+			return fmt.Errorf("failed to parse rclone job status: %w", err)
+		}
+		if jobStatus.Finished {
+			if !jobStatus.Success {
+				return fmt.Errorf("%s", jobStatus.Error)
+			}
+			transferred := total
+			if stats, err := rcloneJobStats(statsJSON); err == nil {
+				transferred = max(transferred, stats.Bytes)
+			}
+			progress.Report(progress.TypeDone, action, transferred, total, startTime)
+			return nil
+		}
+
+		if time.Since(lastReport) < progress.Interval() {
+			continue
+		}
+		lastReport = time.Now()
+
+		stats, err := rcloneJobStats(statsJSON)
+		if err != nil {
+			sugar.Debugf("Failed to get rclone stats: %v", err)
+			continue
+		}
+		progress.Report(progress.TypeProgress, action, stats.Bytes, total, startTime)
+
+		transferredMB := float64(stats.Bytes) / 1024 / 1024
+		totalMB := float64(total) / 1024 / 1024
+		mbPerSec := transferredMB / time.Since(startTime).Seconds()
+		if total > 0 {
+			percentage := float64(stats.Bytes) / float64(total) * 100
+			sugar.Infof("%s progress: %.1f%% (%.2f/%.2f MB, %.2f MB/s)",
+				action, percentage, transferredMB, totalMB, mbPerSec)
+		} else {
+			sugar.Infof("%s progress: %.2f MB (%.2f MB/s)", action, transferredMB, mbPerSec)
+		}
+	}
+}
+
+// rcloneJobStats reads the transfer stats of the job whose core/stats request
+// is statsJSON
+func rcloneJobStats(statsJSON []byte) (coreStatsResponse, error) {
+	var stats coreStatsResponse
+	out, status := librclone.RPC("core/stats", string(statsJSON))
+	if status != 0 && status != 200 {
+		return stats, rcloneError(status, out)
+	}
+	if err := json.Unmarshal([]byte(out), &stats); err != nil {
+		return stats, fmt.Errorf("failed to parse rclone stats: %w", err)
+	}
+	return stats, nil
+}
+
+// rcloneMkdir creates a directory (and its parents) on an rclone remote
+func rcloneMkdir(fs, remote string) error {
+	reqJSON, err := json.Marshal(remoteRequest{Fs: fs, Remote: remote})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	sugar.Debugf("Creating remote directory: %s", remote)
+	out, status := librclone.RPC("operations/mkdir", string(reqJSON))
+	if status != 0 && status != 200 {
+		return fmt.Errorf("rclone mkdir %s failed: %w", remote, rcloneError(status, out))
+	}
 	return nil
 }
+
+// rcErrorResponse is the JSON body librclone returns when an RPC call fails
+type rcErrorResponse struct {
+	Error  string `json:"error"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+// rcloneError turns a failed librclone RPC response into a readable error,
+// falling back to the raw output when it is not the usual error JSON
+func rcloneError(status int, out string) error {
+	var resp rcErrorResponse
+	if err := json.Unmarshal([]byte(out), &resp); err != nil || resp.Error == "" {
+		return fmt.Errorf("status %d: %s", status, out)
+	}
+	sugar.Debugf("rclone %s returned status %d: %s", resp.Path, status, out)
+	return fmt.Errorf("%s (status %d)", resp.Error, status)
+}
@@ -0,0 +1,126 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"backup-home/internal/checksum"
+	"backup-home/internal/logging"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// verifyAlgorithm is the checksum compared after an upload
+const verifyAlgorithm = "sha256"
+
+// remoteHashCommand returns the shell command printing the SHA-256 of
+// remoteFile, using sha256sum (Linux) or shasum (macOS, BSD)
+func remoteHashCommand(remoteFile string) string {
+	quoted := shellQuote(remoteFile)
+	return fmt.Sprintf("sha256sum -- %s 2>/dev/null || shasum -a 256 -- %s", quoted, quoted)
+}
+
+// verifySSHUpload compares the SHA-256 of the file uploaded from localPath
+// with the local one, failing on a mismatch. The remote checksum is computed
+// on the server where it has sha256sum or shasum; otherwise the Go methods
+// read the file back over SFTP.
+func verifySSHUpload(ctx context.Context, localPath string, config SSHConfig) error {
+	sugar := logging.GetSugar()
+	remoteFile := path.Join(remoteDir(config), filepath.Base(localPath))
+	sugar.Infof("Verifying upload: comparing SHA-256 of %s with the local archive", remoteFile)
+
+	localSum, err := localArchiveSum(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum local archive: %w", err)
+	}
+
+	var remoteSum string
+	if config.Method == "" || config.Method == SSHMethodBinary {
+		output, err := exec.CommandContext(ctx, "ssh", sshCommandArgs(config, remoteHashCommand(remoteFile))...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to checksum remote file: %w: %s", err, lastLine(string(output)))
+		}
+		remoteSum, err = parseHashOutput(string(output))
+		if err != nil {
+			return err
+		}
+	} else {
+		sshClient, sftpClient, err := connectSFTP(ctx, config)
+		if err != nil {
+			return err
+		}
+		defer sshClient.Close()
+		defer sftpClient.Close()
+		defer closeOnCancel(ctx, sshClient)()
+
+		if remoteSum, err = remoteHash(sshClient, remoteFile); err != nil {
+			sugar.Debugf("Remote checksum command failed, reading the file back: %v", err)
+			if remoteSum, err = readBackHash(ctx, sftpClient, remoteFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !strings.EqualFold(remoteSum, localSum) {
+		return fmt.Errorf("upload verification failed: %s has SHA-256 %s but the local archive has %s", remoteFile, remoteSum, localSum)
+	}
+	sugar.Infof("Upload verified: SHA-256 %s matches", localSum)
+	return nil
+}
+
+// localArchiveSum returns the SHA-256 of localPath, taken from its checksum
+// file when the archive was hashed while it was written, so a large archive
+// is not read a second time
+func localArchiveSum(localPath string) (string, error) {
+	sum, name, err := checksum.ReadSidecar(checksum.SidecarPath(localPath, verifyAlgorithm))
+	if err == nil && name == filepath.Base(localPath) {
+		logging.GetSugar().Debugf("Using the local checksum file of %s", localPath)
+		return sum, nil
+	}
+	return checksum.File(localPath, verifyAlgorithm)
+}
+
+// remoteHash runs the checksum command for remoteFile over client
+func remoteHash(client *ssh.Client, remoteFile string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	output, err := session.CombinedOutput(remoteHashCommand(remoteFile))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return parseHashOutput(string(output))
+}
+
+// readBackHash downloads remoteFile over SFTP and hashes it locally
+func readBackHash(ctx context.Context, client *sftp.Client, remoteFile string) (string, error) {
+	file, err := client.Open(remoteFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open remote file for verification: %w", err)
+	}
+	defer file.Close()
+
+	sum, err := checksum.Sum(&contextReader{ctx: ctx, reader: file}, verifyAlgorithm)
+	if err != nil {
+		return "", fmt.Errorf("failed to read back remote file: %w", err)
+	}
+	return sum, nil
+}
+
+// parseHashOutput returns the checksum at the start of sha256sum or shasum
+// output
+func parseHashOutput(output string) (string, error) {
+	fields := strings.Fields(output)
+	if len(fields) == 0 || len(fields[0]) != 64 {
+		return "", fmt.Errorf("unexpected remote checksum output: %q", strings.TrimSpace(output))
+	}
+	return fields[0], nil
+}
@@ -0,0 +1,229 @@
+// Package backuphome creates a backup archive of a directory and uploads it
+// over SSH or to rclone remotes, as the backup-home command does, for use
+// from other Go programs.
+package backuphome
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backup-home/internal/backup"
+	"backup-home/internal/upload"
+
+	_ "github.com/rclone/rclone/backend/all"   // import all backends
+	_ "github.com/rclone/rclone/fs/operations" // import operations/* rc commands
+	_ "github.com/rclone/rclone/fs/sync"       // import sync/*
+)
+
+// Config describes a single backup run
+type Config struct {
+	// Source is the directory to back up
+	Source string
+	// BackupPath is where the archive is written; empty uses a file in the
+	// temporary directory named after the format
+	BackupPath string
+	// Format is the archive format (e.g. tar.gz or zip); empty means the
+	// platform default
+	Format string
+	// CompressionLevel is 0 (store without compression) to 9; other values
+	// are rejected
+	CompressionLevel int
+	// Excludes are extra patterns added to the platform defaults
+	Excludes []string
+	// Includes are patterns, in the form of Excludes, re-including paths the
+	// excludes leave out, even inside excluded directories
+	Includes []string
+	// IgnoreExcludes archives everything, including the platform defaults
+	IgnoreExcludes bool
+	// SkipOnError leaves out files that cannot be read instead of failing
+	SkipOnError bool
+	// Passphrase encrypts the archive with AES-256-GCM when not empty
+	Passphrase string
+	// SSH uploads the archive to an SSH server when not nil
+	SSH *SSHConfig
+	// Rclone lists rclone destinations (remote:path) to upload the archive to
+	Rclone []string
+	// RcloneDated uploads into hostname/Users/date subdirectories of each
+	// rclone destination, like the SSH upload
+	RcloneDated bool
+	// DateFormat is the Go time layout of the date subdirectory; empty uses
+	// the default
+	DateFormat string
+	// KeepArchive keeps the local archive after a successful upload. Without
+	// destinations the archive is always kept.
+	KeepArchive bool
+	// Verbose enables debug logging
+	Verbose bool
+}
+
+// SSHConfig describes the SSH server receiving the archive
+type SSHConfig struct {
+	Host string
+	// Port defaults to 22
+	Port     string
+	User     string
+	Password string
+	KeyFile  string
+	// KeyPassphrase decrypts an encrypted key file or default key; there is
+	// no prompt
+	KeyPassphrase string
+	// RemotePath is the directory the dated hostname/Users/date
+	// subdirectory is created in
+	RemotePath string
+	// Flat uploads directly into RemotePath
+	Flat bool
+	// Method is the upload implementation: binary, sftp, scp or goph; empty
+	// uses the system ssh and scp commands
+	Method string
+	// AcceptNewHostKey adds the key of a host missing from known_hosts
+	// instead of failing
+	AcceptNewHostKey bool
+	// InsecureHostKey accepts any host key
+	InsecureHostKey bool
+}
+
+// Result describes a finished backup run
+type Result struct {
+	// ArchivePath is the local archive, which has been removed after a
+	// successful upload unless Config.KeepArchive is set
+	ArchivePath string
+	// Bytes is the size of the archive, summed over the parts of a split archive
+	Bytes int64
+	// Duration covers creating and uploading the archive
+	Duration time.Duration
+}
+
+// Run creates the archive described by config and uploads it to every
+// configured destination. The archive is kept when an upload fails so it can
+// be retried. Canceling ctx stops the run: an incomplete archive is removed,
+// while a finished one is kept when its upload is stopped.
+func Run(ctx context.Context, config Config) (Result, error) {
+	startTime := time.Now()
+	result := Result{}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	archivePath, err := backup.CreateBackup(ctx, backup.Options{
+		Source:           config.Source,
+		BackupPath:       config.BackupPath,
+		Format:           config.Format,
+		CompressionLevel: config.CompressionLevel,
+		Excludes:         config.Excludes,
+		Includes:         config.Includes,
+		IgnoreExcludes:   config.IgnoreExcludes,
+		SkipOnError:      config.SkipOnError,
+		Encrypt:          config.Passphrase != "",
+		Passphrase:       config.Passphrase,
+		Verbose:          config.Verbose,
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to create backup: %w", err)
+	}
+	result.ArchivePath = archivePath
+
+	parts, manifest, err := backup.ArchiveParts(archivePath)
+	if err != nil {
+		return result, err
+	}
+	for _, part := range parts {
+		info, err := os.Stat(part)
+		if err != nil {
+			return result, err
+		}
+		result.Bytes += info.Size()
+	}
+	paths := parts
+	if manifest != "" {
+		paths = append(paths, manifest)
+	}
+
+	var failed []string
+	uploaded := false
+	for _, dest := range destinations(config) {
+		if err := ctx.Err(); err != nil {
+			result.Duration = time.Since(startTime)
+			return result, err
+		}
+		for _, path := range paths {
+			if err := dest.upload(ctx, path); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", dest.name, err))
+				break
+			}
+		}
+		uploaded = true
+	}
+	result.Duration = time.Since(startTime)
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if len(failed) > 0 {
+		return result, fmt.Errorf("failed to upload backup to %d destinations: %s", len(failed), strings.Join(failed, "; "))
+	}
+
+	if uploaded && !config.KeepArchive {
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil {
+				return result, fmt.Errorf("failed to remove local backup: %w", err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// destination is one upload target of a run
+type destination struct {
+	name   string
+	upload func(ctx context.Context, path string) error
+}
+
+// destinations returns the upload targets of config, SSH first
+func destinations(config Config) []destination {
+	var dests []destination
+	if config.SSH != nil {
+		sshConfig := upload.SSHConfig{
+			Host:          config.SSH.Host,
+			Port:          config.SSH.Port,
+			User:          config.SSH.User,
+			Password:      config.SSH.Password,
+			KeyFile:       config.SSH.KeyFile,
+			KeyPassphrase: config.SSH.KeyPassphrase,
+			RemotePath:    config.SSH.RemotePath,
+			Flat:          config.SSH.Flat,
+			DateFormat:    config.DateFormat,
+			Method:        config.SSH.Method,
+			KeepAlive:     upload.DefaultKeepAlive,
+		}
+		if sshConfig.Port == "" {
+			sshConfig.Port = upload.DefaultSSHPort
+		}
+		if config.SSH.InsecureHostKey {
+			sshConfig.HostKey = upload.HostKeyInsecure
+		} else if config.SSH.AcceptNewHostKey {
+			sshConfig.HostKey = upload.HostKeyAcceptNew
+		}
+		dests = append(dests, destination{
+			name: "ssh://" + config.SSH.Host,
+			upload: func(ctx context.Context, path string) error {
+				return upload.UploadToSSH(ctx, path, sshConfig, config.Verbose)
+			},
+		})
+	}
+	for _, remote := range config.Rclone {
+		rcloneConfig := upload.RcloneConfig{
+			Destination: remote,
+			Dated:       config.RcloneDated,
+			DateFormat:  config.DateFormat,
+		}
+		dests = append(dests, destination{
+			name: remote,
+			upload: func(ctx context.Context, path string) error {
+				return upload.UploadToRclone(ctx, path, rcloneConfig, config.Verbose)
+			},
+		})
+	}
+	return dests
+}